@@ -1,14 +1,18 @@
 package libXray
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/xtls/libxray/geo"
 	"github.com/xtls/libxray/nodep"
 	"github.com/xtls/libxray/share"
 	"github.com/xtls/libxray/xray"
+	"github.com/xtls/xray-core/infra/conf"
 )
 
 type invokeResponse struct {
@@ -20,6 +24,8 @@ type invokeResponse struct {
 const (
 	maxInvokeJSONSizeMiB = 16
 	maxInvokeJSONBytes   = maxInvokeJSONSizeMiB * 1024 * 1024
+
+	defaultShareFetchTimeout = 10
 )
 
 func Invoke(requestJSON string) string {
@@ -39,14 +45,32 @@ func Invoke(requestJSON string) string {
 		return invokeGetFreePorts(request.Payload)
 	case LibXrayMethodConvertShareLinksToXrayJson:
 		return invokeConvertShareLinksToXrayJson(request.Payload)
+	case LibXrayMethodFetchSubscription:
+		return invokeFetchSubscription(request.Payload)
 	case LibXrayMethodConvertXrayJsonToShareLinks:
 		return invokeConvertXrayJsonToShareLinks(request.Payload)
+	case LibXrayMethodConvertXrayJsonToClashYaml:
+		return invokeConvertXrayJsonToClashYaml(request.Payload)
+	case LibXrayMethodConvertXrayJsonToSingBox:
+		return invokeConvertXrayJsonToSingBox(request.Payload)
+	case LibXrayMethodConvertXrayJsonToSIP008:
+		return invokeConvertXrayJsonToSIP008(request.Payload)
+	case LibXrayMethodConvertXrayJsonToProxyLines:
+		return invokeConvertXrayJsonToProxyLines(request.Payload)
+	case LibXrayMethodDedupeOutbounds:
+		return invokeDedupeOutbounds(request.Payload)
 	case LibXrayMethodGenerateAgeKeyPair:
 		return invokeGenerateAgeKeyPair(request.Payload)
+	case LibXrayMethodEncryptSubscriptionWithAge:
+		return invokeEncryptSubscriptionWithAge(request.Payload)
 	case LibXrayMethodCountGeoData:
 		return invokeCountGeoData(request.Payload)
 	case LibXrayMethodPingBatch:
 		return invokePingBatch(request.Payload)
+	case LibXrayMethodPingRunning:
+		return invokePingRunning(request.Payload)
+	case LibXrayMethodSelectBestOutbound:
+		return invokeSelectBestOutbound(request.Payload)
 	case LibXrayMethodTestXray:
 		return invokeTestXray(request.Payload)
 	case LibXrayMethodRunXray:
@@ -135,12 +159,130 @@ func invokeConvertShareLinksToXrayJson(payload json.RawMessage) string {
 	if err != nil {
 		return encodeInvokeResponse(nil, err)
 	}
-	secretKey := ""
-	if request.Age != nil {
-		secretKey = request.Age.SecretKey
+	// ssconf:// keys may sit inside a base64 or age subscription, so the
+	// client is passed whether or not the text shows one.
+	client, closeClient, err := shareFetchHTTPClient(request.Fetch)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	defer closeClient()
+	xrayJson, diagnostics, err := convertShareText(request.Text, request.Signature, request.Age, client)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	return encodeInvokeResponse(&ConvertShareLinksToXrayJsonResponse{Config: xrayJson, Diagnostics: diagnostics}, nil)
+}
+
+func invokeFetchSubscription(payload json.RawMessage) string {
+	request, err := decodePayload[FetchSubscriptionRequest](payload)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	client, closeClient, err := shareFetchHTTPClient(request.Fetch)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	defer closeClient()
+
+	var previous conf.Config
+	if request.PreviousXrayJson != "" {
+		if err := json.Unmarshal([]byte(request.PreviousXrayJson), &previous); err != nil {
+			return encodeInvokeResponse(nil, fmt.Errorf("invalid previousXrayJson: %w", err))
+		}
+	}
+	var cache share.SubscriptionCache
+	if request.Cache != nil {
+		cache = share.SubscriptionCache(*request.Cache)
+	}
+
+	subscription, err := share.FetchSubscriptionWithCache(client, request.URL, request.UserAgent, cache)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	response := &FetchSubscriptionResponse{
+		UpdateInterval: subscription.UpdateInterval,
+		Name:           subscription.Name,
+		NotModified:    subscription.NotModified,
+		Cache: SubscriptionCacheConfig{
+			ETag:         subscription.ETag,
+			LastModified: subscription.LastModified,
+			ContentHash:  subscription.ContentHash,
+		},
+	}
+	if subscription.UserInfo != nil {
+		response.UserInfo = (*SubscriptionUserInfoResponse)(subscription.UserInfo)
+	}
+	if subscription.NotModified {
+		return encodeInvokeResponse(response, nil)
 	}
-	xrayJson, err := share.ConvertShareLinksToXrayJsonWithAge(request.Text, secretKey)
-	return encodeInvokeResponse(xrayJson, err)
+
+	xrayJson, diagnostics, err := convertShareText(subscription.Text, request.Signature, request.Age, client)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	response.Config = xrayJson
+	response.Diagnostics = diagnostics
+	response.Diff = outboundDiffResponse(share.DiffOutbounds(previous.OutboundConfigs, xrayJson.OutboundConfigs))
+	return encodeInvokeResponse(response, nil)
+}
+
+func outboundDiffResponse(diff *share.OutboundDiff) *OutboundDiffResponse {
+	changes := func(changes []share.OutboundChange) []OutboundChangeResponse {
+		var responses []OutboundChangeResponse
+		for _, change := range changes {
+			responses = append(responses, OutboundChangeResponse(change))
+		}
+		return responses
+	}
+	return &OutboundDiffResponse{
+		Keys:      diff.Keys,
+		Added:     changes(diff.Added),
+		Removed:   changes(diff.Removed),
+		Changed:   changes(diff.Changed),
+		Unchanged: diff.Unchanged,
+	}
+}
+
+// convertShareText verifies, decrypts and converts text as
+// convertShareLinksToXrayJson does.
+func convertShareText(
+	text string,
+	signatureConfig *SignatureVerifyConfig,
+	ageConfig *AgeDecryptConfig,
+	client *http.Client,
+) (*conf.Config, []ShareDiagnosticResponse, error) {
+	var signature share.SignatureOptions
+	if signatureConfig != nil {
+		signature = share.SignatureOptions(*signatureConfig)
+	}
+	var ageOptions share.AgeDecryptOptions
+	if ageConfig != nil {
+		ageOptions = share.AgeDecryptOptions(*ageConfig)
+	}
+	xrayJson, diagnostics, err := share.ConvertShareLinksToXrayJsonWithDiagnostics(text, signature, ageOptions, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	var responses []ShareDiagnosticResponse
+	for _, diagnostic := range diagnostics {
+		responses = append(responses, ShareDiagnosticResponse(diagnostic))
+	}
+	return xrayJson, responses, nil
+}
+
+func shareFetchHTTPClient(fetch *ShareFetchConfig) (*http.Client, func() error, error) {
+	timeout := defaultShareFetchTimeout
+	if fetch != nil && fetch.Timeout > 0 {
+		timeout = fetch.Timeout
+	}
+	if fetch != nil && fetch.Running {
+		return xray.RunningOutboundHTTPClient(fetch.OutboundTag, timeout)
+	}
+	if fetch != nil && fetch.XrayJson != "" {
+		return xray.OutboundHTTPClient(fetch.XrayJson, fetch.OutboundTag, timeout)
+	}
+	client := &http.Client{Timeout: time.Second * time.Duration(timeout)}
+	return client, func() error { return nil }, nil
 }
 
 func invokeGenerateAgeKeyPair(payload json.RawMessage) string {
@@ -158,18 +300,133 @@ func invokeGenerateAgeKeyPair(payload json.RawMessage) string {
 	}, nil)
 }
 
+func invokeEncryptSubscriptionWithAge(payload json.RawMessage) string {
+	request, err := decodePayload[EncryptSubscriptionWithAgeRequest](payload)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	plaintext := request.Text
+	if plaintext == "" && request.XrayJson != "" {
+		plaintext, err = share.ConvertXrayJsonToShareLinks([]byte(request.XrayJson))
+		if err != nil {
+			return encodeInvokeResponse(nil, err)
+		}
+	}
+	if plaintext == "" {
+		return encodeInvokeResponse(nil, errors.New("missing text or xrayJson"))
+	}
+	armored := request.Armor == nil || *request.Armor
+	ciphertext, err := share.EncryptSubscriptionWithAge([]byte(plaintext), request.Recipients, armored)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	response := &EncryptSubscriptionWithAgeResponse{Ciphertext: string(ciphertext)}
+	if !armored {
+		response.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+	return encodeInvokeResponse(response, nil)
+}
+
 func invokeConvertXrayJsonToShareLinks(payload json.RawMessage) string {
 	request, err := decodePayload[ConvertXrayJsonToShareLinksRequest](payload)
 	if err != nil {
 		return encodeInvokeResponse(nil, err)
 	}
-	links, err := share.ConvertXrayJsonToShareLinks([]byte(request.XrayJson))
+	options := share.ShareLinkOptions{
+		VMessFormat:  share.VMessFormat(request.VMessFormat),
+		OutputFormat: share.ShareLinkOutputFormat(request.OutputFormat),
+	}
+	if request.Age != nil {
+		options.AgeRecipients = request.Age.Recipients
+	}
+	links, err := share.ConvertXrayJsonToShareLinksWithOptions([]byte(request.XrayJson), options)
 	if err != nil {
 		return encodeInvokeResponse(nil, err)
 	}
 	return encodeInvokeResponse(&ConvertXrayJsonToShareLinksResponse{Links: links}, nil)
 }
 
+func invokeConvertXrayJsonToClashYaml(payload json.RawMessage) string {
+	request, err := decodePayload[ConvertXrayJsonToClashYamlRequest](payload)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	clashYaml, err := share.ConvertXrayJsonToClashYaml([]byte(request.XrayJson), request.ProxyGroupName)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	return encodeInvokeResponse(&ConvertXrayJsonToClashYamlResponse{Yaml: clashYaml}, nil)
+}
+
+func invokeConvertXrayJsonToSingBox(payload json.RawMessage) string {
+	request, err := decodePayload[ConvertXrayJsonToSingBoxRequest](payload)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	singBox, warnings, err := share.ConvertXrayJsonToSingBox([]byte(request.XrayJson))
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	singBoxJson, err := json.MarshalIndent(singBox, "", "  ")
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	response := &ConvertXrayJsonToSingBoxResponse{SingBoxJson: string(singBoxJson)}
+	for _, warning := range warnings {
+		response.Warnings = append(response.Warnings, ConversionWarningResponse{
+			Index:   warning.Index,
+			Tag:     warning.Tag,
+			Message: warning.Message,
+		})
+	}
+	return encodeInvokeResponse(response, nil)
+}
+
+func invokeConvertXrayJsonToSIP008(payload json.RawMessage) string {
+	request, err := decodePayload[ConvertXrayJsonToSIP008Request](payload)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	sip008, err := share.ConvertXrayJsonToSIP008([]byte(request.XrayJson))
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	sip008Json, err := json.MarshalIndent(sip008, "", "  ")
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	return encodeInvokeResponse(&ConvertXrayJsonToSIP008Response{SIP008Json: string(sip008Json)}, nil)
+}
+
+func invokeConvertXrayJsonToProxyLines(payload json.RawMessage) string {
+	request, err := decodePayload[ConvertXrayJsonToProxyLinesRequest](payload)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	lines, err := share.ConvertXrayJsonToProxyLines([]byte(request.XrayJson), share.ProxyLineFormat(request.Format))
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	return encodeInvokeResponse(&ConvertXrayJsonToProxyLinesResponse{Lines: lines}, nil)
+}
+
+func invokeDedupeOutbounds(payload json.RawMessage) string {
+	request, err := decodePayload[DedupeOutboundsRequest](payload)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	xrayJson, deduped, err := share.DedupeXrayJsonOutbounds([]byte(request.XrayJson))
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	return encodeInvokeResponse(&DedupeOutboundsResponse{
+		XrayJson:     string(xrayJson),
+		Fingerprints: deduped.Fingerprints,
+		Indexes:      deduped.Indexes,
+		Removed:      len(deduped.Fingerprints) - len(deduped.Outbounds),
+	}, nil)
+}
+
 func invokeCountGeoData(payload json.RawMessage) string {
 	request, err := decodePayload[CountGeoDataRequest](payload)
 	if err != nil {
@@ -196,24 +453,93 @@ func invokePingBatch(payload json.RawMessage) string {
 		}
 	}
 
-	results, err := xray.PingBatch(
-		configs,
-		request.Timeout,
-		request.URL,
-	)
+	results, err := xray.PingBatch(configs, request.pingOptions())
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	return encodeInvokeResponse(&PingBatchResponse{Results: pingItemResponses(results)}, nil)
+}
+
+func invokePingRunning(payload json.RawMessage) string {
+	request, err := decodePayload[PingRunningRequest](payload)
 	if err != nil {
 		return encodeInvokeResponse(nil, err)
 	}
+	results, err := xray.PingRunning(request.OutboundTags, request.pingOptions())
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	return encodeInvokeResponse(&PingRunningResponse{Results: pingItemResponses(results)}, nil)
+}
+
+func invokeSelectBestOutbound(payload json.RawMessage) string {
+	request, err := decodePayload[SelectBestOutboundRequest](payload)
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	selection, err := xray.SelectBestOutbound(request.XrayJson, xray.SelectOptions{
+		Ping:       request.pingOptions(),
+		Policy:     xray.SelectPolicy(request.Policy),
+		Rounds:     request.Rounds,
+		MaxDelay:   request.MaxDelay,
+		EmitConfig: request.EmitConfig,
+	})
+	if err != nil {
+		return encodeInvokeResponse(nil, err)
+	}
+	ranking := make([]OutboundRankResponse, len(selection.Ranking))
+	for i, rank := range selection.Ranking {
+		ranking[i] = OutboundRankResponse{
+			Index:       rank.Index,
+			Tag:         rank.Tag,
+			Name:        rank.Name,
+			MedianDelay: rank.MedianDelay,
+			Successes:   rank.Successes,
+			Failures:    rank.Failures,
+			Error:       rank.Error,
+		}
+	}
+	return encodeInvokeResponse(&SelectBestOutboundResponse{
+		Selected: selection.Selected,
+		Index:    selection.Index,
+		Tag:      selection.Tag,
+		Ranking:  ranking,
+		XrayJson: selection.XrayJSON,
+	}, nil)
+}
+
+func (request PingOptionsRequest) pingOptions() xray.PingOptions {
+	return xray.PingOptions{
+		Timeout:  request.Timeout,
+		URL:      request.URL,
+		Mode:     xray.PingMode(request.Mode),
+		Target:   request.Target,
+		Resolver: request.Resolver,
+		Direct:   request.Direct,
+		HTTP: xray.PingHTTPCheck{
+			Method:         request.Method,
+			Headers:        request.Headers,
+			ExpectedStatus: request.ExpectedStatus,
+			BodyContains:   request.BodyContains,
+			BodySHA256:     request.BodySHA256,
+		},
+	}
+}
 
+func pingItemResponses(results []xray.PingBatchResult) []PingBatchItemResponse {
 	responseResults := make([]PingBatchItemResponse, len(results))
 	for i, result := range results {
 		responseResults[i] = PingBatchItemResponse{
-			Success: result.Success,
-			Delay:   result.Delay,
-			Error:   result.Error,
+			Success:     result.Success,
+			Delay:       result.Delay,
+			Error:       result.Error,
+			Reachable:   result.Reachable,
+			StatusCode:  result.StatusCode,
+			DirectDelay: result.DirectDelay,
+			DirectError: result.DirectError,
 		}
 	}
-	return encodeInvokeResponse(&PingBatchResponse{Results: responseResults}, nil)
+	return responseResults
 }
 
 func invokeTestXray(payload json.RawMessage) string {
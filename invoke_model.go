@@ -1,7 +1,11 @@
 // libXray is an Xray wrapper focusing on improving the experience of Xray-core mobile development.
 package libXray
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
 
 type LibXrayMethod string
 
@@ -10,10 +14,19 @@ const LibXrayAPIVersion = 2
 const (
 	LibXrayMethodGetFreePorts                LibXrayMethod = "getFreePorts"
 	LibXrayMethodConvertShareLinksToXrayJson LibXrayMethod = "convertShareLinksToXrayJson"
+	LibXrayMethodFetchSubscription           LibXrayMethod = "fetchSubscription"
 	LibXrayMethodConvertXrayJsonToShareLinks LibXrayMethod = "convertXrayJsonToShareLinks"
+	LibXrayMethodConvertXrayJsonToClashYaml  LibXrayMethod = "convertXrayJsonToClashYaml"
+	LibXrayMethodConvertXrayJsonToSingBox    LibXrayMethod = "convertXrayJsonToSingBox"
+	LibXrayMethodConvertXrayJsonToSIP008     LibXrayMethod = "convertXrayJsonToSIP008"
+	LibXrayMethodConvertXrayJsonToProxyLines LibXrayMethod = "convertXrayJsonToProxyLines"
+	LibXrayMethodDedupeOutbounds             LibXrayMethod = "dedupeOutbounds"
 	LibXrayMethodGenerateAgeKeyPair          LibXrayMethod = "generateAgeKeyPair"
+	LibXrayMethodEncryptSubscriptionWithAge  LibXrayMethod = "encryptSubscriptionWithAge"
 	LibXrayMethodCountGeoData                LibXrayMethod = "countGeoData"
 	LibXrayMethodPingBatch                   LibXrayMethod = "pingBatch"
+	LibXrayMethodPingRunning                 LibXrayMethod = "pingRunning"
+	LibXrayMethodSelectBestOutbound          LibXrayMethod = "selectBestOutbound"
 	LibXrayMethodTestXray                    LibXrayMethod = "testXray"
 	LibXrayMethodRunXray                     LibXrayMethod = "runXray"
 	LibXrayMethodStopXray                    LibXrayMethod = "stopXray"
@@ -35,13 +48,130 @@ type GetFreePortsResponse struct {
 	Ports []int `json:"ports,omitempty"`
 }
 
+// AgeDecryptConfig opens an age armored subscription. Identities is an age
+// identity file with one secret key per line and "#" comments; Passphrase
+// opens passphrase-encrypted files.
 type AgeDecryptConfig struct {
-	SecretKey string `json:"secretKey,omitempty"`
+	SecretKey  string `json:"secretKey,omitempty"`
+	Identities string `json:"identities,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// AgeEncryptConfig lists the age1 or age1pq1 public keys an "age" output is
+// encrypted to. Classic and post-quantum keys cannot be mixed.
+type AgeEncryptConfig struct {
+	Recipients []string `json:"recipients,omitempty"`
+}
+
+// SignatureVerifyConfig verifies an SSH or minisign signature of the text
+// before anything else. TrustedKeys are SSH authorized_keys lines or
+// minisign public keys. Without Signature, the signature block must end the
+// text.
+type SignatureVerifyConfig struct {
+	TrustedKeys []string `json:"trustedKeys,omitempty"`
+	Signature   string   `json:"signature,omitempty"`
+	Namespace   string   `json:"namespace,omitempty"`
 }
 
 type ConvertShareLinksToXrayJsonRequest struct {
-	Text string            `json:"text,omitempty"`
-	Age  *AgeDecryptConfig `json:"age,omitempty"`
+	Text      string                 `json:"text,omitempty"`
+	Signature *SignatureVerifyConfig `json:"signature,omitempty"`
+	Age       *AgeDecryptConfig      `json:"age,omitempty"`
+	Fetch     *ShareFetchConfig      `json:"fetch,omitempty"`
+}
+
+// ConvertShareLinksToXrayJsonResponse is the Xray config itself, plus a
+// "diagnostics" array naming every entry that was dropped and why.
+type ConvertShareLinksToXrayJsonResponse struct {
+	*conf.Config
+	Diagnostics []ShareDiagnosticResponse `json:"diagnostics,omitempty"`
+}
+
+// ShareDiagnosticResponse names a dropped entry by Line (share links and
+// proxy lines) or Index (YAML and JSON lists). Stage is "fetch", "parse" or
+// "build", and Text is the entry with credentials removed.
+type ShareDiagnosticResponse struct {
+	Line  int    `json:"line,omitempty"`
+	Index *int   `json:"index,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// ShareFetchConfig controls how ssconf:// keys and subscriptions are
+// downloaded. Timeout is in seconds and defaults to 10. With XrayJson, the
+// download goes through the outbound OutboundTag of that config. With
+// Running, it goes through the outbound OutboundTag of the instance started
+// by runXray.
+type ShareFetchConfig struct {
+	Timeout     int    `json:"timeout,omitempty"`
+	XrayJson    string `json:"xrayJson,omitempty"`
+	OutboundTag string `json:"outboundTag,omitempty"`
+	Running     bool   `json:"running,omitempty"`
+}
+
+// FetchSubscriptionRequest downloads URL and converts the body like
+// convertShareLinksToXrayJson. UserAgent defaults to "libXray". Cache is
+// what the previous fetch returned, and PreviousXrayJson its config, which
+// the new one is diffed against.
+type FetchSubscriptionRequest struct {
+	URL              string                   `json:"url,omitempty"`
+	UserAgent        string                   `json:"userAgent,omitempty"`
+	Fetch            *ShareFetchConfig        `json:"fetch,omitempty"`
+	Signature        *SignatureVerifyConfig   `json:"signature,omitempty"`
+	Age              *AgeDecryptConfig        `json:"age,omitempty"`
+	Cache            *SubscriptionCacheConfig `json:"cache,omitempty"`
+	PreviousXrayJson string                   `json:"previousXrayJson,omitempty"`
+}
+
+type SubscriptionCacheConfig struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	ContentHash  string `json:"contentHash,omitempty"`
+}
+
+// FetchSubscriptionResponse holds the converted config, and what the
+// response headers say: subscription-userinfo, profile-update-interval (in
+// hours) and the content-disposition file name. With NotModified, the
+// subscription is unchanged and Config, Diagnostics and Diff are absent.
+type FetchSubscriptionResponse struct {
+	Config         *conf.Config                  `json:"config,omitempty"`
+	Diagnostics    []ShareDiagnosticResponse     `json:"diagnostics,omitempty"`
+	Diff           *OutboundDiffResponse         `json:"diff,omitempty"`
+	UserInfo       *SubscriptionUserInfoResponse `json:"userInfo,omitempty"`
+	UpdateInterval int                           `json:"updateInterval,omitempty"`
+	Name           string                        `json:"name,omitempty"`
+	NotModified    bool                          `json:"notModified"`
+	Cache          SubscriptionCacheConfig       `json:"cache"`
+}
+
+// OutboundDiffResponse compares the config with previousXrayJson. Keys
+// holds a stable key for every outbound of the config, built from its
+// protocol, address, port and credentials.
+type OutboundDiffResponse struct {
+	Keys      []string                 `json:"keys"`
+	Added     []OutboundChangeResponse `json:"added,omitempty"`
+	Removed   []OutboundChangeResponse `json:"removed,omitempty"`
+	Changed   []OutboundChangeResponse `json:"changed,omitempty"`
+	Unchanged int                      `json:"unchanged"`
+}
+
+// OutboundChangeResponse indexes the config and previousXrayJson outbounds;
+// -1 marks the side an outbound is missing from.
+type OutboundChangeResponse struct {
+	Key           string `json:"key"`
+	Index         int    `json:"index"`
+	PreviousIndex int    `json:"previousIndex"`
+	Name          string `json:"name,omitempty"`
+}
+
+// SubscriptionUserInfoResponse is traffic in bytes, and Expire in Unix
+// seconds. Zero means the provider did not say.
+type SubscriptionUserInfoResponse struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+	Total    int64 `json:"total"`
+	Expire   int64 `json:"expire"`
 }
 
 type AgeKeyType string
@@ -60,24 +190,127 @@ type GenerateAgeKeyPairResponse struct {
 	PublicKey string `json:"publicKey,omitempty"`
 }
 
+// EncryptSubscriptionWithAgeRequest encrypts Text, or the share links of
+// XrayJson when Text is empty. Armor defaults to true; without it the
+// response holds the binary age file in base64.
+type EncryptSubscriptionWithAgeRequest struct {
+	Text       string   `json:"text,omitempty"`
+	XrayJson   string   `json:"xrayJson,omitempty"`
+	Recipients []string `json:"recipients,omitempty"`
+	Armor      *bool    `json:"armor,omitempty"`
+}
+
+type EncryptSubscriptionWithAgeResponse struct {
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
 type ConvertXrayJsonToShareLinksRequest struct {
 	XrayJson string `json:"xrayJson,omitempty"`
+	// VMessFormat is "url" (default) or "v2rayn", the base64 JSON of v2rayN
+	// QR codes.
+	VMessFormat string `json:"vmessFormat,omitempty"`
+	// OutputFormat is "plain" (default), "base64", "base64url" or "age".
+	OutputFormat string            `json:"outputFormat,omitempty"`
+	Age          *AgeEncryptConfig `json:"age,omitempty"`
 }
 
 type ConvertXrayJsonToShareLinksResponse struct {
 	Links string `json:"links,omitempty"`
 }
 
+type ConvertXrayJsonToClashYamlRequest struct {
+	XrayJson       string `json:"xrayJson,omitempty"`
+	ProxyGroupName string `json:"proxyGroupName,omitempty"`
+}
+
+type ConvertXrayJsonToClashYamlResponse struct {
+	Yaml string `json:"yaml,omitempty"`
+}
+
+type ConvertXrayJsonToSingBoxRequest struct {
+	XrayJson string `json:"xrayJson,omitempty"`
+}
+
+type ConvertXrayJsonToSingBoxResponse struct {
+	SingBoxJson string                      `json:"singBoxJson,omitempty"`
+	Warnings    []ConversionWarningResponse `json:"warnings,omitempty"`
+}
+
+type ConvertXrayJsonToSIP008Request struct {
+	XrayJson string `json:"xrayJson,omitempty"`
+}
+
+type ConvertXrayJsonToSIP008Response struct {
+	SIP008Json string `json:"sip008Json,omitempty"`
+}
+
+type ConvertXrayJsonToProxyLinesRequest struct {
+	XrayJson string `json:"xrayJson,omitempty"`
+	// Format is "surge" (also read by Shadowrocket), "loon" or "quantumultx".
+	Format string `json:"format,omitempty"`
+}
+
+type ConvertXrayJsonToProxyLinesResponse struct {
+	Lines string `json:"lines,omitempty"`
+}
+
+type DedupeOutboundsRequest struct {
+	XrayJson string `json:"xrayJson,omitempty"`
+}
+
+// DedupeOutboundsResponse holds XrayJson without the duplicate outbounds.
+// Fingerprints and Indexes follow the outbounds of the request: the
+// fingerprint of each, a hash of the whole outbound except its name and tag,
+// and the index in XrayJson of the outbound it was kept as. Outbounds that
+// routing or another outbound names by tag are never dropped.
+type DedupeOutboundsResponse struct {
+	XrayJson     string   `json:"xrayJson,omitempty"`
+	Fingerprints []string `json:"fingerprints"`
+	Indexes      []int    `json:"indexes"`
+	Removed      int      `json:"removed"`
+}
+
+type ConversionWarningResponse struct {
+	Index   int    `json:"index"`
+	Tag     string `json:"tag,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
 type CountGeoDataRequest struct {
 	Name    string `json:"name,omitempty"`
 	GeoType string `json:"geoType,omitempty"`
 	DatDir  string `json:"datDir,omitempty"`
 }
 
+type PingMode string
+
+const (
+	PingModeHTTPHead   PingMode = "http-head"
+	PingModeHTTPGet    PingMode = "http-get"
+	PingModeTCPConnect PingMode = "tcp-connect"
+	PingModeUDPDNS     PingMode = "udp-dns"
+)
+
+// PingOptionsRequest holds the probe options shared by pingBatch,
+// pingRunning, and selectBestOutbound.
+type PingOptionsRequest struct {
+	Timeout  int      `json:"timeout,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Mode     PingMode `json:"mode,omitempty"`
+	Target   string   `json:"target,omitempty"`
+	Resolver string   `json:"resolver,omitempty"`
+	Direct   bool     `json:"direct,omitempty"`
+
+	Method         string            `json:"method,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	ExpectedStatus []int             `json:"expectedStatus,omitempty"`
+	BodyContains   string            `json:"bodyContains,omitempty"`
+	BodySHA256     string            `json:"bodySha256,omitempty"`
+}
+
 type PingBatchRequest struct {
 	Configs []PingBatchItemRequest `json:"configs,omitempty"`
-	Timeout int                    `json:"timeout,omitempty"`
-	URL     string                 `json:"url,omitempty"`
+	PingOptionsRequest
 }
 
 type PingBatchItemRequest struct {
@@ -89,10 +322,61 @@ type PingBatchResponse struct {
 	Results []PingBatchItemResponse `json:"results,omitempty"`
 }
 
+type PingRunningRequest struct {
+	OutboundTags []string `json:"outboundTags,omitempty"`
+	PingOptionsRequest
+}
+
+type PingRunningResponse struct {
+	Results []PingBatchItemResponse `json:"results,omitempty"`
+}
+
+// PingBatchItemResponse is the result of one probe. For mode tcp-connect
+// through a tunneling protocol, Success and Delay only cover the connect to
+// the proxy server, not the proxied connect to the target.
 type PingBatchItemResponse struct {
-	Success bool   `json:"success"`
-	Delay   int64  `json:"delay,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Success     bool   `json:"success"`
+	Delay       int64  `json:"delay,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Reachable   bool   `json:"reachable,omitempty"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	DirectDelay int64  `json:"directDelay,omitempty"`
+	DirectError string `json:"directError,omitempty"`
+}
+
+type SelectPolicy string
+
+const (
+	SelectPolicyLowestMedian   SelectPolicy = "lowest-median"
+	SelectPolicyThreshold      SelectPolicy = "threshold"
+	SelectPolicyWeightedRandom SelectPolicy = "weighted-random"
+)
+
+type SelectBestOutboundRequest struct {
+	XrayJson   string       `json:"xrayJson,omitempty"`
+	Policy     SelectPolicy `json:"policy,omitempty"`
+	Rounds     int          `json:"rounds,omitempty"`
+	MaxDelay   int64        `json:"maxDelay,omitempty"`
+	EmitConfig bool         `json:"emitConfig,omitempty"`
+	PingOptionsRequest
+}
+
+type SelectBestOutboundResponse struct {
+	Selected bool                   `json:"selected"`
+	Index    int                    `json:"index"`
+	Tag      string                 `json:"tag,omitempty"`
+	Ranking  []OutboundRankResponse `json:"ranking,omitempty"`
+	XrayJson string                 `json:"xrayJson,omitempty"`
+}
+
+type OutboundRankResponse struct {
+	Index       int    `json:"index"`
+	Tag         string `json:"tag,omitempty"`
+	Name        string `json:"name,omitempty"`
+	MedianDelay int64  `json:"medianDelay"`
+	Successes   int    `json:"successes"`
+	Failures    int    `json:"failures"`
+	Error       string `json:"error,omitempty"`
 }
 
 type RunXrayRequest struct {
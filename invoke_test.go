@@ -2,12 +2,17 @@ package libXray
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/metacubex/age"
@@ -343,6 +348,408 @@ func TestInvokeConvertShareLinksFiltersBuildInvalidOutbounds(t *testing.T) {
 	}
 }
 
+func TestInvokeConvertShareLinksReportsDiagnostics(t *testing.T) {
+	links := "vless://12345678-abcd-abcd-abcd-123456789abc@valid.example:443?encryption=none&security=tls&sni=valid.example#Valid\n" +
+		"vless://2418d087-648k-4990-86e8-19dca1d006d3@invalid.example:443?encryption=none&security=tls#Invalid"
+
+	response := invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{Text: links},
+	)
+	if !response.Success {
+		t.Fatalf("ConvertShareLinksToXrayJson failed: %s", response.Err)
+	}
+	converted := decodeDataObject[ConvertShareLinksToXrayJsonResponse](t, response)
+	if converted.Config == nil || len(converted.OutboundConfigs) != 1 {
+		t.Fatalf("config = %+v, want one outbound", converted.Config)
+	}
+	if len(converted.Diagnostics) != 1 {
+		t.Fatalf("diagnostics = %+v, want one entry", converted.Diagnostics)
+	}
+	diagnostic := converted.Diagnostics[0]
+	if diagnostic.Line != 2 || diagnostic.Stage != "build" || diagnostic.Text != "vless://***@invalid.example:443?…#Invalid" || diagnostic.Error == "" {
+		t.Fatalf("diagnostic = %+v, want the build failure of line 2", diagnostic)
+	}
+}
+
+func TestInvokeConvertXrayJsonToClashYaml(t *testing.T) {
+	const name = "Trojan"
+	converted := invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text: "trojan://secret@trojan.example:443?security=tls&sni=trojan.example#" + name,
+		},
+	)
+	if !converted.Success {
+		t.Fatalf("ConvertShareLinksToXrayJson failed: %s", converted.Err)
+	}
+
+	response := invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToClashYaml,
+		ConvertXrayJsonToClashYamlRequest{
+			XrayJson:       string(converted.Data),
+			ProxyGroupName: "Proxy",
+		},
+	)
+	if !response.Success {
+		t.Fatalf("ConvertXrayJsonToClashYaml failed: %s", response.Err)
+	}
+	clash := decodeDataObject[ConvertXrayJsonToClashYamlResponse](t, response)
+	for _, want := range []string{"name: " + name, "type: trojan", "proxy-groups:", "type: select"} {
+		if !strings.Contains(clash.Yaml, want) {
+			t.Fatalf("yaml does not contain %q:\n%s", want, clash.Yaml)
+		}
+	}
+
+	response = invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToClashYaml,
+		ConvertXrayJsonToClashYamlRequest{XrayJson: `{"outbounds":[{"protocol":"freedom"}]}`},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+}
+
+func TestInvokeConvertShareLinksToXrayJsonFetchesSSConf(t *testing.T) {
+	response := invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text:  "ssconf://127.0.0.1:1/secret-key",
+			Fetch: &ShareFetchConfig{Timeout: 2},
+		},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+	if !strings.Contains(response.Err, "ssconf://127.0.0.1:1/") || strings.Contains(response.Err, "secret-key") {
+		t.Fatalf("error = %q, want the redacted ssconf link", response.Err)
+	}
+
+	// A key inside a base64 subscription is fetched too.
+	response = invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text: base64.StdEncoding.EncodeToString([]byte("ssconf://127.0.0.1:1/secret-key\n")),
+		},
+	)
+	if response.Success || !strings.Contains(response.Err, "ssconf://127.0.0.1:1/") {
+		t.Fatalf("response = %+v, want the fetch error of the wrapped ssconf link", response)
+	}
+
+	response = invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text: "ssconf://127.0.0.1:1/secret-key",
+			Fetch: &ShareFetchConfig{
+				Timeout:     2,
+				XrayJson:    `{"outbounds":[{"protocol":"freedom","tag":"direct"}]}`,
+				OutboundTag: "missing",
+			},
+		},
+	)
+	if response.Success || !strings.Contains(response.Err, `outbound tag "missing" not found`) {
+		t.Fatalf("response = %+v, want the missing outbound error", response)
+	}
+}
+
+func TestInvokeFetchSubscription(t *testing.T) {
+	const links = "trojan://secret@example.com:443?security=tls#Fetched\nunsupported://entry\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() != "libXray-test" {
+			http.Error(w, "unexpected user agent", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Subscription-Userinfo", "upload=1; download=2; total=3; expire=4")
+		w.Header().Set("Profile-Update-Interval", "24")
+		w.Header().Set("Content-Disposition", `attachment; filename="Provider"`)
+		io.WriteString(w, base64.StdEncoding.EncodeToString([]byte(links)))
+	}))
+	defer server.Close()
+
+	response := invokeForTest(
+		t,
+		LibXrayMethodFetchSubscription,
+		FetchSubscriptionRequest{
+			URL:       server.URL + "/sub?token=secret",
+			UserAgent: "libXray-test",
+			Fetch: &ShareFetchConfig{
+				Timeout:  2,
+				XrayJson: `{"outbounds":[{"protocol":"freedom","tag":"direct"}]}`,
+			},
+		},
+	)
+	if !response.Success {
+		t.Fatalf("FetchSubscription failed: %s", response.Err)
+	}
+	fetched := decodeDataObject[FetchSubscriptionResponse](t, response)
+	if fetched.Config == nil || len(fetched.Config.OutboundConfigs) != 1 {
+		t.Fatalf("config = %+v, want one outbound", fetched.Config)
+	}
+	if len(fetched.Diagnostics) != 1 || fetched.Diagnostics[0].Line != 2 {
+		t.Fatalf("diagnostics = %+v, want one for line 2", fetched.Diagnostics)
+	}
+	if fetched.UserInfo == nil || *fetched.UserInfo != (SubscriptionUserInfoResponse{Upload: 1, Download: 2, Total: 3, Expire: 4}) {
+		t.Fatalf("userInfo = %+v", fetched.UserInfo)
+	}
+	if fetched.UpdateInterval != 24 || fetched.Name != "Provider" {
+		t.Fatalf("updateInterval = %d, name = %q", fetched.UpdateInterval, fetched.Name)
+	}
+
+	response = invokeForTest(
+		t,
+		LibXrayMethodFetchSubscription,
+		FetchSubscriptionRequest{URL: server.URL + "/sub?token=secret", Fetch: &ShareFetchConfig{Timeout: 2}},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+	if !strings.Contains(response.Err, "HTTP 403") || strings.Contains(response.Err, "token=secret") {
+		t.Fatalf("error = %q, want the redacted HTTP status", response.Err)
+	}
+}
+
+func TestInvokeFetchSubscriptionRefresh(t *testing.T) {
+	var version atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, version.Load())
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, "trojan://a@a.example:443?security=tls#A\n")
+		if version.Load() == 0 {
+			io.WriteString(w, "trojan://b@b.example:443?security=tls#B\n")
+		} else {
+			io.WriteString(w, "trojan://c@c.example:443?security=tls#C\n")
+		}
+	}))
+	defer server.Close()
+
+	fetch := func(request FetchSubscriptionRequest) FetchSubscriptionResponse {
+		request.URL = server.URL
+		response := invokeForTest(t, LibXrayMethodFetchSubscription, request)
+		if !response.Success {
+			t.Fatalf("FetchSubscription failed: %s", response.Err)
+		}
+		return decodeDataObject[FetchSubscriptionResponse](t, response)
+	}
+
+	first := fetch(FetchSubscriptionRequest{})
+	if first.NotModified || first.Cache.ETag != `"v0"` || first.Cache.ContentHash == "" {
+		t.Fatalf("first fetch = %+v", first)
+	}
+	if first.Diff == nil || len(first.Diff.Keys) != 2 || len(first.Diff.Added) != 2 {
+		t.Fatalf("first diff = %+v, want two added outbounds", first.Diff)
+	}
+	previous, err := json.Marshal(first.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unchanged := fetch(FetchSubscriptionRequest{Cache: &first.Cache, PreviousXrayJson: string(previous)})
+	if !unchanged.NotModified || unchanged.Config != nil || unchanged.Diff != nil || unchanged.Cache != first.Cache {
+		t.Fatalf("unchanged fetch = %+v", unchanged)
+	}
+
+	version.Store(1)
+	changed := fetch(FetchSubscriptionRequest{Cache: &first.Cache, PreviousXrayJson: string(previous)})
+	if changed.NotModified || changed.Diff == nil {
+		t.Fatalf("changed fetch = %+v", changed)
+	}
+	diff := changed.Diff
+	if diff.Unchanged != 1 || diff.Keys[0] != first.Diff.Keys[0] ||
+		len(diff.Added) != 1 || diff.Added[0].Name != "C" ||
+		len(diff.Removed) != 1 || diff.Removed[0].Key != first.Diff.Keys[1] || diff.Removed[0].PreviousIndex != 1 {
+		t.Fatalf("diff = %+v", diff)
+	}
+
+	response := invokeForTest(
+		t,
+		LibXrayMethodFetchSubscription,
+		FetchSubscriptionRequest{URL: server.URL, PreviousXrayJson: "{"},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+}
+
+func TestInvokeConvertXrayJsonToSingBox(t *testing.T) {
+	response := invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToSingBox,
+		ConvertXrayJsonToSingBoxRequest{
+			XrayJson: `{"outbounds":[
+				{"protocol":"freedom","tag":"direct"},
+				{"protocol":"trojan","tag":"Trojan","settings":{"address":"trojan.example","port":443,"password":"secret"},
+				 "streamSettings":{"security":"tls","tlsSettings":{"serverName":"trojan.example"}}}
+			]}`,
+		},
+	)
+	if !response.Success {
+		t.Fatalf("ConvertXrayJsonToSingBox failed: %s", response.Err)
+	}
+	converted := decodeDataObject[ConvertXrayJsonToSingBoxResponse](t, response)
+	for _, want := range []string{`"type": "trojan"`, `"tag": "Trojan"`, `"server_name": "trojan.example"`} {
+		if !strings.Contains(converted.SingBoxJson, want) {
+			t.Fatalf("sing-box json does not contain %q:\n%s", want, converted.SingBoxJson)
+		}
+	}
+	if len(converted.Warnings) != 1 || converted.Warnings[0].Index != 0 || converted.Warnings[0].Tag != "direct" {
+		t.Fatalf("warnings = %+v, want one for the freedom outbound", converted.Warnings)
+	}
+
+	response = invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToSingBox,
+		ConvertXrayJsonToSingBoxRequest{XrayJson: `{"outbounds":[{"protocol":"freedom"}]}`},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+}
+
+func TestInvokeConvertXrayJsonToSIP008(t *testing.T) {
+	response := invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToSIP008,
+		ConvertXrayJsonToSIP008Request{
+			XrayJson: `{"outbounds":[
+				{"protocol":"freedom"},
+				{"protocol":"shadowsocks","tag":"SS","settings":{"address":"ss.example","port":8388,"method":"aes-128-gcm","password":"secret"}}
+			]}`,
+		},
+	)
+	if !response.Success {
+		t.Fatalf("ConvertXrayJsonToSIP008 failed: %s", response.Err)
+	}
+	converted := decodeDataObject[ConvertXrayJsonToSIP008Response](t, response)
+	for _, want := range []string{`"version": 1`, `"remarks": "SS"`, `"server": "ss.example"`, `"method": "aes-128-gcm"`} {
+		if !strings.Contains(converted.SIP008Json, want) {
+			t.Fatalf("SIP008 json does not contain %q:\n%s", want, converted.SIP008Json)
+		}
+	}
+
+	response = invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToSIP008,
+		ConvertXrayJsonToSIP008Request{XrayJson: `{"outbounds":[{"protocol":"freedom"}]}`},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+}
+
+func TestInvokeConvertXrayJsonToProxyLines(t *testing.T) {
+	xrayJson := `{"outbounds":[
+		{"protocol":"freedom"},
+		{"protocol":"trojan","tag":"Trojan","settings":{"address":"trojan.example","port":443,"password":"secret"},"streamSettings":{"security":"tls"}}
+	]}`
+	for format, want := range map[string]string{
+		"surge":       "Trojan = trojan, trojan.example, 443, password=secret",
+		"loon":        `Trojan = trojan,trojan.example,443,"secret",transport=tcp`,
+		"quantumultx": "trojan=trojan.example:443, password=secret, over-tls=true, tag=Trojan",
+	} {
+		response := invokeForTest(
+			t,
+			LibXrayMethodConvertXrayJsonToProxyLines,
+			ConvertXrayJsonToProxyLinesRequest{XrayJson: xrayJson, Format: format},
+		)
+		if !response.Success {
+			t.Fatalf("ConvertXrayJsonToProxyLines(%s) failed: %s", format, response.Err)
+		}
+		converted := decodeDataObject[ConvertXrayJsonToProxyLinesResponse](t, response)
+		if converted.Lines != want {
+			t.Fatalf("%s lines = %q, want %q", format, converted.Lines, want)
+		}
+	}
+
+	response := invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToProxyLines,
+		ConvertXrayJsonToProxyLinesRequest{XrayJson: xrayJson, Format: "clash"},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+}
+
+func TestInvokeDedupeOutbounds(t *testing.T) {
+	response := invokeForTest(
+		t,
+		LibXrayMethodDedupeOutbounds,
+		DedupeOutboundsRequest{
+			XrayJson: `{"outbounds":[
+				{"protocol":"trojan","tag":"A","sendThrough":"A","settings":{"address":"trojan.example","port":443,"password":"secret"},"streamSettings":{"security":"tls"}},
+				{"protocol":"freedom","tag":"direct"},
+				{"protocol":"trojan","tag":"B","sendThrough":"B","settings":{"address":"trojan.example","port":443,"password":"secret"},"streamSettings":{"security":"tls"}}
+			]}`,
+		},
+	)
+	if !response.Success {
+		t.Fatalf("DedupeOutbounds failed: %s", response.Err)
+	}
+	deduped := decodeDataObject[DedupeOutboundsResponse](t, response)
+	if deduped.Removed != 1 || len(deduped.Fingerprints) != 3 || deduped.Fingerprints[0] != deduped.Fingerprints[2] {
+		t.Fatalf("deduped = %+v, want the third outbound removed as a copy of the first", deduped)
+	}
+	if want := []int{0, 1, 0}; fmt.Sprint(deduped.Indexes) != fmt.Sprint(want) {
+		t.Fatalf("indexes = %v, want %v", deduped.Indexes, want)
+	}
+	var config conf.Config
+	if err := json.Unmarshal([]byte(deduped.XrayJson), &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.OutboundConfigs) != 2 || config.OutboundConfigs[0].Tag != "A" {
+		t.Fatalf("outbounds = %+v, want A and direct", config.OutboundConfigs)
+	}
+
+	response = invokeForTest(t, LibXrayMethodDedupeOutbounds, DedupeOutboundsRequest{XrayJson: "{"})
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+}
+
+func TestInvokeConvertXrayJsonToShareLinksVMessFormat(t *testing.T) {
+	xrayJson := `{"outbounds":[
+		{"protocol":"vmess","tag":"VMess","settings":{"address":"vmess.example","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811"}}
+	]}`
+	for format, prefix := range map[string]string{
+		"":       "vmess://b831381d-6324-4d53-ad4f-8cda48b30811@vmess.example:443",
+		"url":    "vmess://b831381d-6324-4d53-ad4f-8cda48b30811@vmess.example:443",
+		"v2rayn": "vmess://eyJ2IjoiMiIs",
+	} {
+		response := invokeForTest(
+			t,
+			LibXrayMethodConvertXrayJsonToShareLinks,
+			ConvertXrayJsonToShareLinksRequest{XrayJson: xrayJson, VMessFormat: format},
+		)
+		if !response.Success {
+			t.Fatalf("ConvertXrayJsonToShareLinks(%q) failed: %s", format, response.Err)
+		}
+		converted := decodeDataObject[ConvertXrayJsonToShareLinksResponse](t, response)
+		if !strings.HasPrefix(converted.Links, prefix) {
+			t.Fatalf("%q links = %q, want prefix %q", format, converted.Links, prefix)
+		}
+	}
+
+	response := invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToShareLinks,
+		ConvertXrayJsonToShareLinksRequest{XrayJson: xrayJson, VMessFormat: "qr"},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+}
+
 func TestInvokeAgeKeyGenerationAndConversion(t *testing.T) {
 	generated := invokeForTest(
 		t,
@@ -395,6 +802,168 @@ func TestInvokeAgeKeyGenerationAndConversion(t *testing.T) {
 	}
 }
 
+func TestInvokeConvertXrayJsonToShareLinksAgeOutput(t *testing.T) {
+	generated := invokeForTest(
+		t,
+		LibXrayMethodGenerateAgeKeyPair,
+		GenerateAgeKeyPairRequest{KeyType: AgeKeyTypeHybrid},
+	)
+	if !generated.Success {
+		t.Fatalf("GenerateAgeKeyPair failed: %s", generated.Err)
+	}
+	pair := decodeDataObject[GenerateAgeKeyPairResponse](t, generated)
+
+	exported := invokeForTest(
+		t,
+		LibXrayMethodConvertXrayJsonToShareLinks,
+		ConvertXrayJsonToShareLinksRequest{
+			XrayJson:     `{"outbounds":[{"protocol":"trojan","tag":"AgeOutput","settings":{"address":"example.com","port":443,"password":"secret"},"streamSettings":{"security":"tls"}}]}`,
+			OutputFormat: "age",
+			Age:          &AgeEncryptConfig{Recipients: []string{pair.PublicKey}},
+		},
+	)
+	if !exported.Success {
+		t.Fatalf("ConvertXrayJsonToShareLinks failed: %s", exported.Err)
+	}
+	links := decodeDataObject[ConvertXrayJsonToShareLinksResponse](t, exported).Links
+	if !strings.HasPrefix(links, "-----BEGIN AGE ENCRYPTED FILE-----") {
+		t.Fatalf("links = %q, want an armored age file", links)
+	}
+
+	converted := invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text: links,
+			Age:  &AgeDecryptConfig{SecretKey: pair.SecretKey},
+		},
+	)
+	if !converted.Success {
+		t.Fatalf("ConvertShareLinksToXrayJson failed: %s", converted.Err)
+	}
+	config := decodeDataObject[conf.Config](t, converted)
+	if len(config.OutboundConfigs) != 1 {
+		t.Fatalf("outbounds = %d, want 1", len(config.OutboundConfigs))
+	}
+}
+
+func TestInvokeEncryptSubscriptionWithAge(t *testing.T) {
+	generated := invokeForTest(
+		t,
+		LibXrayMethodGenerateAgeKeyPair,
+		GenerateAgeKeyPairRequest{KeyType: AgeKeyTypeX25519},
+	)
+	if !generated.Success {
+		t.Fatalf("GenerateAgeKeyPair failed: %s", generated.Err)
+	}
+	pair := decodeDataObject[GenerateAgeKeyPairResponse](t, generated)
+
+	encrypted := invokeForTest(
+		t,
+		LibXrayMethodEncryptSubscriptionWithAge,
+		EncryptSubscriptionWithAgeRequest{
+			XrayJson:   `{"outbounds":[{"protocol":"trojan","settings":{"address":"example.com","port":443,"password":"secret"},"streamSettings":{"security":"tls"}}]}`,
+			Recipients: []string{pair.PublicKey},
+		},
+	)
+	if !encrypted.Success {
+		t.Fatalf("EncryptSubscriptionWithAge failed: %s", encrypted.Err)
+	}
+	ciphertext := decodeDataObject[EncryptSubscriptionWithAgeResponse](t, encrypted).Ciphertext
+	if !strings.HasPrefix(ciphertext, "-----BEGIN AGE ENCRYPTED FILE-----") {
+		t.Fatalf("ciphertext = %q, want an armored age file", ciphertext)
+	}
+	converted := invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text: ciphertext,
+			Age:  &AgeDecryptConfig{SecretKey: pair.SecretKey},
+		},
+	)
+	if !converted.Success {
+		t.Fatalf("ConvertShareLinksToXrayJson failed: %s", converted.Err)
+	}
+	fromIdentities := invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text: ciphertext,
+			Age:  &AgeDecryptConfig{Identities: "# phone\n" + pair.SecretKey + "\n"},
+		},
+	)
+	if !fromIdentities.Success {
+		t.Fatalf("ConvertShareLinksToXrayJson with identities failed: %s", fromIdentities.Err)
+	}
+
+	armored := false
+	binary := invokeForTest(
+		t,
+		LibXrayMethodEncryptSubscriptionWithAge,
+		EncryptSubscriptionWithAgeRequest{
+			Text:       "trojan://secret@example.com:443",
+			Recipients: []string{pair.PublicKey},
+			Armor:      &armored,
+		},
+	)
+	if !binary.Success {
+		t.Fatalf("EncryptSubscriptionWithAge failed: %s", binary.Err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(decodeDataObject[EncryptSubscriptionWithAgeResponse](t, binary).Ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(decoded, []byte("age-encryption.org/v1\n")) {
+		t.Fatalf("binary ciphertext = %q", decoded)
+	}
+}
+
+func TestInvokeConvertShareLinksVerifiesSignature(t *testing.T) {
+	publicKey, secretKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const text = "trojan://secret@example.com:443?security=tls#Signed\n"
+	keyID := []byte("libxray!")
+	signature := ed25519.Sign(secretKey, []byte(text))
+	block := "untrusted comment: signature\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), signature...)) + "\n" +
+		"trusted comment: test\n" +
+		base64.StdEncoding.EncodeToString(ed25519.Sign(secretKey, append(signature, "test"...))) + "\n"
+	trustedKey := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), publicKey...))
+
+	response := invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text:      text + block,
+			Signature: &SignatureVerifyConfig{TrustedKeys: []string{trustedKey}},
+		},
+	)
+	if !response.Success {
+		t.Fatalf("ConvertShareLinksToXrayJson failed: %s", response.Err)
+	}
+	config := decodeDataObject[conf.Config](t, response)
+	if len(config.OutboundConfigs) != 1 {
+		t.Fatalf("outbounds = %d, want 1", len(config.OutboundConfigs))
+	}
+
+	response = invokeForTest(
+		t,
+		LibXrayMethodConvertShareLinksToXrayJson,
+		ConvertShareLinksToXrayJsonRequest{
+			Text:      strings.Replace(text, "example.com", "attacker.example", 1) + block,
+			Signature: &SignatureVerifyConfig{TrustedKeys: []string{trustedKey}},
+		},
+	)
+	if response.Success || string(response.Data) != "null" {
+		t.Fatalf("response = %+v, want failure with null data", response)
+	}
+	if !strings.Contains(response.Err, "signature does not verify") {
+		t.Fatalf("error = %q", response.Err)
+	}
+}
+
 func TestInvokeAgeFailuresHaveNullData(t *testing.T) {
 	for _, test := range []struct {
 		method  LibXrayMethod
@@ -410,6 +979,24 @@ func TestInvokeAgeFailuresHaveNullData(t *testing.T) {
 				Text: "-----BEGIN AGE ENCRYPTED FILE-----\ninvalid",
 			},
 		},
+		{
+			method: LibXrayMethodConvertXrayJsonToShareLinks,
+			payload: ConvertXrayJsonToShareLinksRequest{
+				XrayJson:     `{"outbounds":[{"protocol":"freedom"}]}`,
+				OutputFormat: "age",
+			},
+		},
+		{
+			method:  LibXrayMethodEncryptSubscriptionWithAge,
+			payload: EncryptSubscriptionWithAgeRequest{Recipients: []string{"age1invalid"}},
+		},
+		{
+			method: LibXrayMethodEncryptSubscriptionWithAge,
+			payload: EncryptSubscriptionWithAgeRequest{
+				Text:       "trojan://secret@example.com:443",
+				Recipients: []string{"AGE-SECRET-KEY-1QQQQ"},
+			},
+		},
 	} {
 		response := invokeForTest(t, test.method, test.payload)
 		if response.Success {
@@ -453,8 +1040,10 @@ func TestInvokePingBatchReturnsPerItemFailures(t *testing.T) {
 					XrayJson: "not JSON",
 				},
 			},
-			Timeout: 1,
-			URL:     "https://example.com",
+			PingOptionsRequest: PingOptionsRequest{
+				Timeout: 1,
+				URL:     "https://example.com",
+			},
 		},
 	)
 	if !response.Success {
@@ -481,8 +1070,10 @@ func TestInvokePingBatchRejectsInvalidRequest(t *testing.T) {
 		t,
 		LibXrayMethodPingBatch,
 		PingBatchRequest{
-			Timeout: 1,
-			URL:     "https://example.com",
+			PingOptionsRequest: PingOptionsRequest{
+				Timeout: 1,
+				URL:     "https://example.com",
+			},
 		},
 	)
 	if response.Success {
@@ -508,8 +1099,10 @@ func TestInvokePingBatchRejectsMoreThanFiveConfigs(t *testing.T) {
 		LibXrayMethodPingBatch,
 		PingBatchRequest{
 			Configs: configs,
-			Timeout: 1,
-			URL:     "https://example.com",
+			PingOptionsRequest: PingOptionsRequest{
+				Timeout: 1,
+				URL:     "https://example.com",
+			},
 		},
 	)
 	if response.Success {
@@ -523,6 +1116,103 @@ func TestInvokePingBatchRejectsMoreThanFiveConfigs(t *testing.T) {
 	}
 }
 
+func TestInvokePingBatchRejectsUnsupportedMode(t *testing.T) {
+	response := invokeForTest(
+		t,
+		LibXrayMethodPingBatch,
+		PingBatchRequest{
+			Configs: []PingBatchItemRequest{
+				{XrayJson: `{"outbounds":[{"protocol":"freedom"}]}`},
+			},
+			PingOptionsRequest: PingOptionsRequest{
+				Timeout: 1,
+				Mode:    PingMode("icmp"),
+			},
+		},
+	)
+	if response.Success {
+		t.Fatal("PingBatch should reject an unsupported mode")
+	}
+	if !strings.Contains(response.Err, "unsupported ping mode") {
+		t.Fatalf("error = %q", response.Err)
+	}
+}
+
+func TestInvokePingRunningRequiresRunningInstance(t *testing.T) {
+	xrayStopForTest(t)
+	response := invokeForTest(
+		t,
+		LibXrayMethodPingRunning,
+		PingRunningRequest{
+			OutboundTags: []string{"proxy"},
+			PingOptionsRequest: PingOptionsRequest{
+				Timeout: 1,
+				URL:     "https://example.com",
+			},
+		},
+	)
+	if response.Success {
+		t.Fatal("PingRunning should fail without a running instance")
+	}
+	if response.Err != "xray is not running" {
+		t.Fatalf("error = %q", response.Err)
+	}
+	if got := string(response.Data); got != "null" {
+		t.Fatalf("data = %s, want null", got)
+	}
+}
+
+func TestInvokeSelectBestOutboundReturnsRankingWithoutWinner(t *testing.T) {
+	response := invokeForTest(
+		t,
+		LibXrayMethodSelectBestOutbound,
+		SelectBestOutboundRequest{
+			XrayJson: `{"outbounds":[
+				{"protocol":"socks","tag":"dead","settings":{"address":"127.0.0.1","port":1}},
+				{"protocol":"freedom","tag":"direct"}
+			]}`,
+			Rounds: 1,
+			PingOptionsRequest: PingOptionsRequest{
+				Timeout: 1,
+				Mode:    PingModeTCPConnect,
+				Target:  "127.0.0.1:1",
+			},
+		},
+	)
+	if !response.Success {
+		t.Fatalf("SelectBestOutbound failed: %s", response.Err)
+	}
+	data := decodeDataObject[SelectBestOutboundResponse](t, response)
+	if data.Selected || data.Index != -1 || data.XrayJson != "" {
+		t.Fatalf("data = %+v", data)
+	}
+	if len(data.Ranking) != 1 || data.Ranking[0].Tag != "dead" ||
+		data.Ranking[0].Failures != 1 || data.Ranking[0].Error == "" {
+		t.Fatalf("ranking = %+v", data.Ranking)
+	}
+}
+
+func TestInvokeSelectBestOutboundRejectsUnknownPolicy(t *testing.T) {
+	response := invokeForTest(
+		t,
+		LibXrayMethodSelectBestOutbound,
+		SelectBestOutboundRequest{
+			XrayJson: `{"outbounds":[{"protocol":"freedom"}]}`,
+			Policy:   SelectPolicy("fastest"),
+			PingOptionsRequest: PingOptionsRequest{
+				Timeout: 1,
+				URL:     "https://example.com",
+			},
+		},
+	)
+	if response.Success {
+		t.Fatal("SelectBestOutbound should reject an unknown policy")
+	}
+	if got := string(response.Data); got != "null" {
+		t.Fatalf("data = %s, want null", got)
+	}
+}
+
 func TestInvokeCountGeoDataUsesPayloadDatDir(t *testing.T) {
 	datDir := t.TempDir()
 	writeGeoSiteDatForTest(t, filepath.Join(datDir, "geosite.dat"))
@@ -58,12 +58,18 @@ func PingHTTPRequest(c *http.Client, url string, timeout int) (int64, error) {
 	response, err := c.Do(req)
 	delay := time.Since(start).Milliseconds()
 	if err != nil {
-		precision := delay - int64(timeout)*1000
-		if math.Abs(float64(precision)) < 50 {
-			return PingDelayTimeout, err
-		}
-		return PingDelayError, err
+		return PingFailureDelay(delay, timeout), err
 	}
 	response.Body.Close()
 	return delay, nil
 }
+
+// PingFailureDelay maps the elapsed time of a failed probe to PingDelayTimeout
+// when it ended at the timeout, and to PingDelayError otherwise.
+func PingFailureDelay(delay int64, timeout int) int64 {
+	precision := delay - int64(timeout)*1000
+	if math.Abs(float64(precision)) < 50 {
+		return PingDelayTimeout
+	}
+	return PingDelayError
+}
@@ -1,8 +1,11 @@
 package share
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"slices"
 	"strings"
 
 	"github.com/metacubex/age"
@@ -23,6 +26,11 @@ var (
 	ErrAgePlaintextTooLarge    = errors.New("decrypted subscription exceeds the 16 MiB size limit")
 	ErrAgePlaintextUnsupported = errors.New("decrypted subscription is unsupported")
 	ErrAgeKeyTypeUnsupported   = errors.New("unsupported age key type")
+	ErrAgeRecipientMissing     = errors.New("missing age recipient")
+	ErrAgeRecipientInvalid     = errors.New("invalid or unsupported age recipient")
+	ErrAgeRecipientsMixed      = errors.New("post-quantum and classic age recipients cannot be mixed")
+	ErrAgePassphraseRequired   = errors.New("age subscription is passphrase-encrypted")
+	ErrAgePassphraseIncorrect  = errors.New("incorrect age passphrase")
 )
 
 type AgeKeyType string
@@ -62,40 +70,116 @@ func GenerateAgeKeyPair(keyType AgeKeyType) (*AgeKeyPair, error) {
 	}
 }
 
+// AgeDecryptOptions holds every way to open an age armored subscription.
+// All of them are tried together.
+type AgeDecryptOptions struct {
+	// SecretKey is a single secret key, as in
+	// ConvertShareLinksToXrayJsonWithAge.
+	SecretKey string
+	// Identities is an age identity file: one secret key per line, with
+	// empty lines and "#" comments skipped.
+	Identities string
+	// Passphrase opens files encrypted with "age -p".
+	Passphrase string
+}
+
 func ConvertShareLinksToXrayJsonWithAge(links, secretKey string) (*conf.Config, error) {
+	return convertShareLinksWithAge(links, AgeDecryptOptions{SecretKey: secretKey}, nil)
+}
+
+// ConvertShareLinksToXrayJsonWithAgeOptions is
+// ConvertShareLinksToXrayJsonWithAge with several identities or a
+// passphrase.
+func ConvertShareLinksToXrayJsonWithAgeOptions(links string, options AgeDecryptOptions) (*conf.Config, error) {
+	return convertShareLinksWithAge(links, options, nil)
+}
+
+func convertShareLinksWithAge(links string, options AgeDecryptOptions, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	plaintext, encrypted, err := decryptAgeSubscription(links, options)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return convertShareLinks(links, diagnostics)
+	}
+	config, err := convertShareLinks(plaintext, diagnostics)
+	if err != nil {
+		return nil, ErrAgePlaintextUnsupported
+	}
+	return config, nil
+}
+
+// decryptAgeSubscription returns the plaintext of an age armored
+// subscription. Text that is not age armored is returned with encrypted
+// set to false.
+func decryptAgeSubscription(links string, options AgeDecryptOptions) (plaintext string, encrypted bool, err error) {
 	text := strings.TrimSpace(FixWindowsReturn(links))
 	if !strings.HasPrefix(text, ageArmorHeader) {
-		return ConvertShareLinksToXrayJson(links)
-	}
-	if strings.TrimSpace(secretKey) == "" {
-		return nil, ErrAgeSecretKeyMissing
+		return "", false, nil
 	}
 
-	identity, _, err := parseNativeAgeIdentity(secretKey)
+	identities, err := parseAgeDecryptOptions(options)
 	if err != nil {
-		return nil, err
+		return "", true, err
 	}
-	reader, err := age.Decrypt(armor.NewReader(strings.NewReader(text)), identity)
+	reader, err := age.Decrypt(armor.NewReader(strings.NewReader(text)), identities...)
 	if err != nil {
 		var noMatch *age.NoIdentityMatchError
 		if errors.As(err, &noMatch) {
-			return nil, ErrAgeDecryptFailed
+			switch {
+			case !slices.Contains(noMatch.StanzaTypes, "scrypt"):
+				return "", true, ErrAgeDecryptFailed
+			case options.Passphrase == "":
+				return "", true, ErrAgePassphraseRequired
+			default:
+				return "", true, ErrAgePassphraseIncorrect
+			}
 		}
-		return nil, ErrAgeArmorMalformed
+		return "", true, ErrAgeArmorMalformed
 	}
 
-	plaintext, err := io.ReadAll(io.LimitReader(reader, maxAgePlaintextBytes+1))
+	decrypted, err := io.ReadAll(io.LimitReader(reader, maxAgePlaintextBytes+1))
 	if err != nil {
-		return nil, ErrAgeArmorMalformed
+		return "", true, ErrAgeArmorMalformed
 	}
-	if len(plaintext) > maxAgePlaintextBytes {
-		return nil, ErrAgePlaintextTooLarge
+	if len(decrypted) > maxAgePlaintextBytes {
+		return "", true, ErrAgePlaintextTooLarge
 	}
-	config, err := ConvertShareLinksToXrayJson(string(plaintext))
-	if err != nil {
-		return nil, ErrAgePlaintextUnsupported
+	return string(decrypted), true, nil
+}
+
+func parseAgeDecryptOptions(options AgeDecryptOptions) ([]age.Identity, error) {
+	var identities []age.Identity
+	if strings.TrimSpace(options.SecretKey) != "" {
+		identity, _, err := parseNativeAgeIdentity(options.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
 	}
-	return config, nil
+	for index, line := range strings.Split(FixWindowsReturn(options.Identities), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, _, err := parseNativeAgeIdentity(line)
+		if err != nil {
+			// The line itself is a secret and stays out of the error.
+			return nil, fmt.Errorf("%w at identity line %d", err, index+1)
+		}
+		identities = append(identities, identity)
+	}
+	if options.Passphrase != "" {
+		identity, err := age.NewScryptIdentity(options.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	if len(identities) == 0 {
+		return nil, ErrAgeSecretKeyMissing
+	}
+	return identities, nil
 }
 
 func parseNativeAgeIdentity(secretKey string) (age.Identity, age.Recipient, error) {
@@ -120,3 +204,90 @@ func parseNativeAgeIdentity(secretKey string) (age.Identity, age.Recipient, erro
 	}
 	return nil, nil, ErrAgeSecretKeyInvalid
 }
+
+// encryptAgeSubscription encrypts plaintext to every recipient and returns
+// the ASCII armored file that decryptAgeSubscription reads.
+func encryptAgeSubscription(plaintext string, publicKeys []string) (string, error) {
+	ciphertext, err := EncryptSubscriptionWithAge([]byte(plaintext), publicKeys, true)
+	if err != nil {
+		return "", err
+	}
+	return string(ciphertext), nil
+}
+
+// EncryptSubscriptionWithAge encrypts plaintext to every X25519 or hybrid
+// public key. With armored, the result is the ASCII armored file that
+// ConvertShareLinksToXrayJsonWithAge reads; otherwise it is the binary age
+// format.
+func EncryptSubscriptionWithAge(plaintext []byte, publicKeys []string, armored bool) ([]byte, error) {
+	if len(publicKeys) == 0 {
+		return nil, ErrAgeRecipientMissing
+	}
+	recipients := make([]age.Recipient, 0, len(publicKeys))
+	hybrid := 0
+	for _, publicKey := range publicKeys {
+		recipient, err := parseNativeAgeRecipient(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := recipient.(*age.HybridRecipient); ok {
+			hybrid++
+		}
+		recipients = append(recipients, recipient)
+	}
+	// age refuses the mix too, but with an error that is not comparable.
+	if hybrid != 0 && hybrid != len(recipients) {
+		return nil, ErrAgeRecipientsMixed
+	}
+
+	var output bytes.Buffer
+	var destination io.WriteCloser = nopWriteCloser{&output}
+	if armored {
+		destination = armor.NewWriter(&output)
+	}
+	writer, err := age.Encrypt(destination, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := destination.Close(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// parseNativeAgeRecipient accepts the public keys GenerateAgeKeyPair
+// returns, with the same rules as parseNativeAgeIdentity.
+func parseNativeAgeRecipient(publicKey string) (age.Recipient, error) {
+	key := strings.TrimSpace(publicKey)
+	if key == "" || strings.ContainsAny(key, "\r\n") {
+		return nil, ErrAgeRecipientInvalid
+	}
+
+	if strings.HasPrefix(key, "age1pq1") {
+		recipient, err := age.ParseHybridRecipient(key)
+		if err != nil {
+			return nil, ErrAgeRecipientInvalid
+		}
+		return recipient, nil
+	}
+	if strings.HasPrefix(key, "age1") {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, ErrAgeRecipientInvalid
+		}
+		return recipient, nil
+	}
+	return nil, ErrAgeRecipientInvalid
+}
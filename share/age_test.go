@@ -177,3 +177,198 @@ func encryptAgeForTest(t *testing.T, pair *AgeKeyPair, plaintext string) string
 	}
 	return output.String()
 }
+
+func TestConvertXrayJsonToShareLinksWithAgeOutput(t *testing.T) {
+	xrayJson := []byte(`{"outbounds":[{"protocol":"trojan","tag":"Trojan","settings":{"address":"example.com","port":443,"password":"secret"},"streamSettings":{"security":"tls"}}]}`)
+	for _, keyType := range []AgeKeyType{AgeKeyTypeX25519, AgeKeyTypeHybrid} {
+		t.Run(string(keyType), func(t *testing.T) {
+			first, err := GenerateAgeKeyPair(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := GenerateAgeKeyPair(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			armored, err := ConvertXrayJsonToShareLinksWithOptions(xrayJson, ShareLinkOptions{
+				OutputFormat:  ShareLinkOutputAge,
+				AgeRecipients: []string{first.PublicKey, " " + second.PublicKey + " "},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(armored, ageArmorHeader) || strings.Contains(armored, "secret") {
+				t.Fatalf("armored = %q", armored)
+			}
+			for _, pair := range []*AgeKeyPair{first, second} {
+				config, err := ConvertShareLinksToXrayJsonWithAge(armored, pair.SecretKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(config.OutboundConfigs) != 1 {
+					t.Fatalf("outbounds = %d, want 1", len(config.OutboundConfigs))
+				}
+			}
+		})
+	}
+}
+
+func TestConvertXrayJsonToShareLinksWithAgeOutputErrors(t *testing.T) {
+	xrayJson := []byte(`{"outbounds":[{"protocol":"trojan","settings":{"address":"example.com","port":443,"password":"secret"},"streamSettings":{"security":"tls"}}]}`)
+	classic, err := GenerateAgeKeyPair(AgeKeyTypeX25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hybrid, err := GenerateAgeKeyPair(AgeKeyTypeHybrid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, test := range map[string]struct {
+		recipients []string
+		want       error
+	}{
+		"missing":     {nil, ErrAgeRecipientMissing},
+		"secret key":  {[]string{classic.SecretKey}, ErrAgeRecipientInvalid},
+		"two in one":  {[]string{classic.PublicKey + "\n" + classic.PublicKey}, ErrAgeRecipientInvalid},
+		"ssh key":     {[]string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHsKLqeplhpW+uObz5dvMgjz1OxfM/XXUB+VHtZ6isGN"}, ErrAgeRecipientInvalid},
+		"bad bech32":  {[]string{classic.PublicKey + "x"}, ErrAgeRecipientInvalid},
+		"mixed kinds": {[]string{classic.PublicKey, hybrid.PublicKey}, ErrAgeRecipientsMixed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ConvertXrayJsonToShareLinksWithOptions(xrayJson, ShareLinkOptions{
+				OutputFormat:  ShareLinkOutputAge,
+				AgeRecipients: test.recipients,
+			})
+			if !errors.Is(err, test.want) {
+				t.Fatalf("error = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestEncryptSubscriptionWithAgeBinary(t *testing.T) {
+	pair, err := GenerateAgeKeyPair(AgeKeyTypeX25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := EncryptSubscriptionWithAge([]byte(ageTestShareLink), []string{pair.PublicKey}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(ciphertext, []byte(ageArmorHeader)) {
+		t.Fatal("binary output is armored")
+	}
+
+	identity, _, err := parseNativeAgeIdentity(pair.SecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := age.Decrypt(bytes.NewReader(ciphertext), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != ageTestShareLink {
+		t.Fatalf("plaintext = %q", plaintext)
+	}
+}
+
+func TestConvertShareLinksToXrayJsonWithAgeIdentities(t *testing.T) {
+	other, err := GenerateAgeKeyPair(AgeKeyTypeHybrid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := GenerateAgeKeyPair(AgeKeyTypeX25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	armored := encryptAgeForTest(t, pair, ageTestShareLink)
+	identities := "# created: 2026-10-19\r\n# public key: " + other.PublicKey + "\r\n" +
+		other.SecretKey + "\r\n\r\n  " + pair.SecretKey + "  \r\n"
+
+	config, err := ConvertShareLinksToXrayJsonWithAgeOptions(armored, AgeDecryptOptions{Identities: identities})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.OutboundConfigs) != 1 {
+		t.Fatalf("outbounds = %d, want 1", len(config.OutboundConfigs))
+	}
+
+	_, err = ConvertShareLinksToXrayJsonWithAgeOptions(armored, AgeDecryptOptions{Identities: "# only comments\n"})
+	if !errors.Is(err, ErrAgeSecretKeyMissing) {
+		t.Fatalf("empty identities error = %v", err)
+	}
+
+	_, err = ConvertShareLinksToXrayJsonWithAgeOptions(armored, AgeDecryptOptions{Identities: other.SecretKey})
+	if !errors.Is(err, ErrAgeDecryptFailed) {
+		t.Fatalf("unmatched identities error = %v", err)
+	}
+
+	invalid := pair.SecretKey + "\n# comment\n" + pair.PublicKey + "\n"
+	_, err = ConvertShareLinksToXrayJsonWithAgeOptions(armored, AgeDecryptOptions{Identities: invalid})
+	if !errors.Is(err, ErrAgeSecretKeyInvalid) || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("invalid identity error = %v", err)
+	}
+	if strings.Contains(err.Error(), pair.PublicKey) || strings.Contains(err.Error(), pair.SecretKey) {
+		t.Fatal("identity error contains a key")
+	}
+}
+
+func TestConvertShareLinksToXrayJsonWithAgePassphrase(t *testing.T) {
+	recipient, err := age.NewScryptRecipient("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient.SetWorkFactor(10)
+	var output bytes.Buffer
+	armored := armor.NewWriter(&output)
+	writer, err := age.Encrypt(armored, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(writer, ageTestShareLink); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := armored.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := GenerateAgeKeyPair(AgeKeyTypeX25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ConvertShareLinksToXrayJsonWithAgeOptions(output.String(), AgeDecryptOptions{
+		SecretKey:  pair.SecretKey,
+		Passphrase: "correct horse",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.OutboundConfigs) != 1 {
+		t.Fatalf("outbounds = %d, want 1", len(config.OutboundConfigs))
+	}
+
+	for name, test := range map[string]struct {
+		options AgeDecryptOptions
+		want    error
+	}{
+		"no passphrase":    {AgeDecryptOptions{SecretKey: pair.SecretKey}, ErrAgePassphraseRequired},
+		"wrong passphrase": {AgeDecryptOptions{Passphrase: "battery staple"}, ErrAgePassphraseIncorrect},
+	} {
+		_, err := ConvertShareLinksToXrayJsonWithAgeOptions(output.String(), test.options)
+		if !errors.Is(err, test.want) {
+			t.Fatalf("%s error = %v, want %v", name, err, test.want)
+		}
+	}
+
+	_, err = ConvertShareLinksToXrayJsonWithAgeOptions(encryptAgeForTest(t, pair, ageTestShareLink), AgeDecryptOptions{Passphrase: "correct horse"})
+	if !errors.Is(err, ErrAgeDecryptFailed) {
+		t.Fatalf("passphrase on a key-encrypted file error = %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package share
 
 import (
+	"errors"
 	"fmt"
 
 	"gopkg.in/yaml.v3"
@@ -11,7 +12,14 @@ import (
 // https://github.com/MetaCubeX/mihomo/blob/Alpha/docs/config.yaml
 
 type ClashYaml struct {
-	Proxies []ClashProxy `yaml:"proxies,omitempty"`
+	Proxies     []ClashProxy      `yaml:"proxies,omitempty"`
+	ProxyGroups []ClashProxyGroup `yaml:"proxy-groups,omitempty"`
+}
+
+type ClashProxyGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
 }
 
 type ClashProxy struct {
@@ -25,6 +33,8 @@ type ClashProxy struct {
 	Password   string `yaml:"password,omitempty"`
 	Encryption string `yaml:"encryption,omitempty"`
 
+	Headers map[string]string `yaml:"headers,omitempty"`
+
 	Ports        string `yaml:"ports,omitempty"`
 	HopInterval  int32  `yaml:"hop-interval,omitempty"`
 	Up           string `yaml:"up,omitempty"`
@@ -32,6 +42,15 @@ type ClashProxy struct {
 	Obfs         string `yaml:"obfs,omitempty"`
 	ObfsPassword string `yaml:"obfs-password,omitempty"`
 
+	PrivateKey   string        `yaml:"private-key,omitempty"`
+	PublicKey    string        `yaml:"public-key,omitempty"`
+	PreSharedKey string        `yaml:"pre-shared-key,omitempty"`
+	Ip           string        `yaml:"ip,omitempty"`
+	Ipv6         string        `yaml:"ipv6,omitempty"`
+	AllowedIps   []string      `yaml:"allowed-ips,omitempty"`
+	Reserved     ClashReserved `yaml:"reserved,omitempty"`
+	Mtu          int32         `yaml:"mtu,omitempty"`
+
 	Udp        bool `yaml:"udp,omitempty"`
 	UdpOverTcp bool `yaml:"udp-over-tcp,omitempty"`
 
@@ -55,6 +74,36 @@ type ClashProxy struct {
 	XhttpOpts  *ClashProxyXhttpOpts  `yaml:"xhttp-opts,omitempty"`
 }
 
+// ClashReserved holds WireGuard reserved bytes, written as a list
+// ([1, 2, 3]) rather than the base64 string yaml uses for []byte.
+type ClashReserved []byte
+
+// UnmarshalYAML reads the list form and the base64 string mihomo also
+// accepts. WireGuard proxies are not imported, so a value of neither form is
+// dropped instead of failing the whole document.
+func (reserved *ClashReserved) UnmarshalYAML(node *yaml.Node) error {
+	*reserved = nil
+	if node.Kind == yaml.ScalarNode {
+		if value, err := parseWireGuardReserved(node.Value); err == nil {
+			*reserved = value
+		}
+		return nil
+	}
+	var values []uint8
+	if err := node.Decode(&values); err == nil {
+		*reserved = values
+	}
+	return nil
+}
+
+func (reserved ClashReserved) MarshalYAML() (any, error) {
+	values := make([]int, len(reserved))
+	for i, value := range reserved {
+		values[i] = int(value)
+	}
+	return values, nil
+}
+
 type ClashProxyEchOpts struct {
 	Enable bool   `yaml:"enable,omitempty"`
 	Config string `yaml:"config,omitempty"`
@@ -131,28 +180,38 @@ type ClashProxyXhttpOptsDownloadSettings struct {
 	ClientFingerprint string                 `yaml:"client-fingerprint,omitempty"`
 }
 
-func tryToParseClashYaml(text string) (*conf.Config, error) {
+func tryToParseClashYaml(text string, diagnostics *shareDiagnostics) (*conf.Config, error) {
 	var clash ClashYaml
 	if err := yaml.Unmarshal([]byte(text), &clash); err != nil {
 		return nil, err
 	}
-	config := clash.toXrayConfig()
+	config, skipped := clash.toXrayConfig(diagnostics)
 	if len(config.OutboundConfigs) == 0 {
+		if len(skipped) > 0 {
+			return nil, fmt.Errorf("no valid outbound found: %w", errors.Join(skipped...))
+		}
 		return nil, fmt.Errorf("no valid outbound found")
 	}
 	return config, nil
 }
 
-func (clash ClashYaml) toXrayConfig() *conf.Config {
+// toXrayConfig converts every proxy and returns one error per skipped
+// entry, naming its index and name.
+func (clash ClashYaml) toXrayConfig(diagnostics *shareDiagnostics) (*conf.Config, []error) {
 	outbounds := make([]conf.OutboundDetourConfig, 0, len(clash.Proxies))
-	for _, proxy := range clash.Proxies {
+	var skipped []error
+	for index, proxy := range clash.Proxies {
+		origin := indexOrigin(index, redactEntry(proxy.Name, proxy.Type, proxy.Server, proxy.Port))
 		outbound, err := proxy.outbound()
 		if err != nil {
+			skipped = append(skipped, fmt.Errorf("proxy %d (%q): %w", index, proxy.Name, err))
+			diagnostics.drop(origin, ShareDiagnosticStageParse, err)
 			continue
 		}
 		outbounds = append(outbounds, *outbound)
+		diagnostics.keep(origin)
 	}
-	return &conf.Config{OutboundConfigs: outbounds}
+	return &conf.Config{OutboundConfigs: outbounds}, skipped
 }
 
 func (proxy ClashProxy) outbound() (*conf.OutboundDetourConfig, error) {
@@ -184,6 +243,12 @@ func (proxy ClashProxy) outbound() (*conf.OutboundDetourConfig, error) {
 			return nil, err
 		}
 		return outbound, nil
+	case "http":
+		outbound, err := proxy.httpOutbound()
+		if err != nil {
+			return nil, err
+		}
+		return outbound, nil
 	case "trojan":
 		outbound, err := proxy.trojanOutbound()
 		if err != nil {
@@ -220,45 +285,38 @@ func (proxy ClashProxy) shadowsocksOutbound() (*conf.OutboundDetourConfig, error
 	outbound.Settings = &settingsRawMessage
 
 	if len(proxy.Plugin) != 0 {
-		if proxy.Plugin != "v2ray-plugin" {
-			return nil, fmt.Errorf("unsupport ss plugin: obfs")
-		}
-		if proxy.PluginOpts == nil {
-			return nil, fmt.Errorf("unsupport ss plugin-opts: nil")
-		}
-		if proxy.PluginOpts.Mode != "websocket" {
-			return nil, fmt.Errorf("unsupport ss plugin-opts mode: %s", proxy.PluginOpts.Mode)
-		}
-		streamSetting := &conf.StreamConfig{}
-		streamSetting.Network = new(conf.TransportProtocol("websocket"))
-
-		wsSettings := &conf.WebSocketConfig{}
-		if len(proxy.PluginOpts.Host) > 0 {
-			wsSettings.Host = proxy.PluginOpts.Host
-		}
-		if len(proxy.PluginOpts.Path) > 0 {
-			wsSettings.Path = proxy.PluginOpts.Path
-		}
-		streamSetting.WSSettings = wsSettings
-
-		if proxy.PluginOpts.Tls {
-			tlsSettings := &conf.TLSConfig{}
-			tlsSettings.Fingerprint = proxy.PluginOpts.Fingerprint
-
-			if proxy.PluginOpts.EchOpts != nil {
-				if proxy.PluginOpts.EchOpts.Enable {
-					tlsSettings.ECHConfigList = proxy.PluginOpts.EchOpts.Config
-				}
-			}
-
-			streamSetting.TLSSettings = tlsSettings
+		streamSetting, err := proxy.shadowsocksPluginStreamSettings()
+		if err != nil {
+			return nil, err
 		}
-
 		outbound.StreamSetting = streamSetting
 	}
 	return outbound, nil
 }
 
+func (proxy ClashProxy) shadowsocksPluginStreamSettings() (*conf.StreamConfig, error) {
+	if proxy.PluginOpts == nil {
+		return nil, fmt.Errorf("unsupport ss plugin-opts: nil")
+	}
+	switch proxy.Plugin {
+	case "v2ray-plugin":
+		return v2rayPluginStreamSettings(*proxy.PluginOpts)
+	case "obfs":
+		// Mihomo sends bing.com when plugin-opts has no host.
+		host := proxy.PluginOpts.Host
+		if host == "" {
+			host = "bing.com"
+		}
+		return simpleObfsStreamSettings(proxy.PluginOpts.Mode, host, "")
+	case "shadow-tls":
+		// Every shadow-tls version authenticates inside a relayed TLS
+		// handshake, which no Xray transport or dialerProxy chain can do.
+		return nil, fmt.Errorf("unsupport ss plugin: shadow-tls has no Xray equivalent")
+	default:
+		return nil, fmt.Errorf("unsupport ss plugin: %s", proxy.Plugin)
+	}
+}
+
 func (proxy ClashProxy) vmessOutbound() (*conf.OutboundDetourConfig, error) {
 	outbound := &conf.OutboundDetourConfig{}
 	outbound.Protocol = "vmess"
@@ -348,6 +406,37 @@ func (proxy ClashProxy) socksOutbound() (*conf.OutboundDetourConfig, error) {
 	return outbound, nil
 }
 
+func (proxy ClashProxy) httpOutbound() (*conf.OutboundDetourConfig, error) {
+	outbound := &conf.OutboundDetourConfig{}
+	outbound.Protocol = "http"
+	setOutboundName(outbound, proxy.Name)
+
+	settings := conf.HTTPClientConfig{}
+
+	settings.Address = parseAddress(proxy.Server)
+	settings.Port = proxy.Port
+
+	settings.Username = proxy.Username
+	settings.Password = proxy.Password
+	if len(proxy.Headers) > 0 {
+		settings.Headers = proxy.Headers
+	}
+
+	settingsRawMessage, err := convertJsonToRawMessage(settings)
+	if err != nil {
+		return nil, err
+	}
+	outbound.Settings = &settingsRawMessage
+
+	streamSettings, err := proxy.streamSettings(*outbound)
+	if err != nil {
+		return nil, err
+	}
+	outbound.StreamSetting = streamSettings
+
+	return outbound, nil
+}
+
 func (proxy ClashProxy) trojanOutbound() (*conf.OutboundDetourConfig, error) {
 	outbound := &conf.OutboundDetourConfig{}
 	outbound.Protocol = "trojan"
@@ -447,9 +536,9 @@ func (proxy ClashProxy) streamSettings(outbound conf.OutboundDetourConfig) (*con
 		hysteriaSettings.Auth = proxy.Password
 		streamSettings.HysteriaSettings = hysteriaSettings
 
-		var hopPtr *int32
+		var hopPtr *conf.Int32Range
 		if proxy.HopInterval > 0 {
-			hopPtr = new(proxy.HopInterval)
+			hopPtr = hopIntervalSeconds(proxy.HopInterval)
 		}
 		fm, err := buildHy2FinalMask(proxy.Up, proxy.Down, proxy.Ports, hopPtr, proxy.Obfs, proxy.ObfsPassword)
 		if err != nil {
@@ -1,13 +1,17 @@
 package share
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/xtls/xray-core/infra/conf"
+	"gopkg.in/yaml.v3"
 )
 
 func clashHysteria2YAML(fields string) string {
@@ -22,7 +26,7 @@ func clashHysteria2YAML(fields string) string {
 
 func parseClashHy2(t *testing.T, yaml string) *conf.OutboundDetourConfig {
 	t.Helper()
-	config, err := tryToParseClashYaml(yaml)
+	config, err := tryToParseClashYaml(yaml, nil)
 	require.NoError(t, err)
 	require.Len(t, config.OutboundConfigs, 1)
 	return &config.OutboundConfigs[0]
@@ -30,7 +34,7 @@ func parseClashHy2(t *testing.T, yaml string) *conf.OutboundDetourConfig {
 
 func parseClashYAML(t *testing.T, yaml string) *conf.Config {
 	t.Helper()
-	cfg, err := tryToParseClashYaml(yaml)
+	cfg, err := tryToParseClashYaml(yaml, nil)
 	require.NoError(t, err)
 	return cfg
 }
@@ -178,6 +182,39 @@ func TestClashShadowsocks_Plain(t *testing.T) {
 	assert.Nil(t, ob.StreamSetting)
 }
 
+func TestClashWireGuardReservedKeepsOtherProxies(t *testing.T) {
+	text := `proxies:
+  - name: wg-base64
+    type: wireguard
+    server: wg.host
+    port: 51820
+    private-key: key
+    public-key: peer
+    reserved: "U4An"
+  - name: wg-invalid
+    type: wireguard
+    server: wg.host
+    port: 51820
+    private-key: key
+    public-key: peer
+    reserved: "not reserved"
+  - name: ss-plain
+    type: ss
+    server: ss.host
+    port: 8388
+    cipher: aes-128-gcm
+    password: secret`
+	cfg := parseClashYAML(t, text)
+	require.Len(t, cfg.OutboundConfigs, 1)
+	assert.Equal(t, "shadowsocks", cfg.OutboundConfigs[0].Protocol)
+
+	var clash ClashYaml
+	require.NoError(t, yaml.Unmarshal([]byte(text), &clash))
+	require.Len(t, clash.Proxies, 3)
+	assert.Equal(t, ClashReserved{0x53, 0x80, 0x27}, clash.Proxies[0].Reserved)
+	assert.Nil(t, clash.Proxies[1].Reserved)
+}
+
 func TestClashShadowsocks_V2rayPluginWebsocketTLS(t *testing.T) {
 	yaml := `proxies:
   - name: ss-ws
@@ -200,8 +237,159 @@ func TestClashShadowsocks_V2rayPluginWebsocketTLS(t *testing.T) {
 	require.NotNil(t, ss.WSSettings)
 	assert.Equal(t, "/ws", ss.WSSettings.Path)
 	assert.Equal(t, "cloud.cdn", ss.WSSettings.Host)
+	assert.Equal(t, "tls", ss.Security)
 	require.NotNil(t, ss.TLSSettings)
 	assert.Equal(t, "chrome", ss.TLSSettings.Fingerprint)
+	assert.Equal(t, "cloud.cdn", ss.TLSSettings.ServerName)
+}
+
+func TestClashShadowsocks_V2rayPluginSkipCertVerify(t *testing.T) {
+	_, err := tryToParseClashYaml(`proxies:
+  - name: ss-ws
+    type: ss
+    server: ss.ws
+    port: 443
+    cipher: aes-256-gcm
+    password: p
+    plugin: v2ray-plugin
+    plugin-opts:
+      mode: websocket
+      host: cloud.cdn
+      tls: true
+      skip-cert-verify: true`, nil)
+	assert.ErrorContains(t, err, "tls insecure has no Xray equivalent")
+}
+
+// Shadowsocks plugin entries as written in the Mihomo example config.
+const clashShadowsocksPluginsYAML = `proxies:
+  - name: "ss2"
+    type: ss
+    server: server
+    port: 443
+    cipher: chacha20-ietf-poly1305
+    password: "password"
+    plugin: obfs
+    plugin-opts:
+      mode: http
+      host: bing.com
+  - name: "ss2-default-host"
+    type: ss
+    server: server
+    port: 443
+    cipher: chacha20-ietf-poly1305
+    password: "password"
+    plugin: obfs
+    plugin-opts:
+      mode: http
+  - name: "ss2-tls"
+    type: ss
+    server: server
+    port: 443
+    cipher: chacha20-ietf-poly1305
+    password: "password"
+    plugin: obfs
+    plugin-opts:
+      mode: tls
+  - name: "ss4-shadow-tls"
+    type: ss
+    server: server
+    port: 443
+    cipher: chacha20-ietf-poly1305
+    password: "password"
+    plugin: shadow-tls
+    client-fingerprint: chrome
+    plugin-opts:
+      host: "cloud.tencent.com"
+      password: "shadow_tls_password"
+      version: 2
+`
+
+func TestClashShadowsocks_ObfsHTTP(t *testing.T) {
+	cfg := parseClashYAML(t, clashShadowsocksPluginsYAML)
+	require.Len(t, cfg.OutboundConfigs, 2)
+
+	for i, name := range []string{"ss2", "ss2-default-host"} {
+		ob := cfg.OutboundConfigs[i]
+		assert.Equal(t, name, getOutboundName(ob))
+		ss := ob.StreamSetting
+		require.NotNil(t, ss)
+		assert.Equal(t, conf.TransportProtocol("raw"), *ss.Network)
+		require.NotNil(t, ss.RAWSettings)
+		var header XrayRawSettingsHeader
+		require.NoError(t, json.Unmarshal(ss.RAWSettings.HeaderConfig, &header))
+		assert.Equal(t, "http", header.Type)
+		require.NotNil(t, header.Request)
+		require.NotNil(t, header.Request.Headers)
+		assert.Equal(t, []string{"bing.com"}, header.Request.Headers.Host)
+	}
+}
+
+func TestClashShadowsocks_UnsupportedPluginErrors(t *testing.T) {
+	var clash ClashYaml
+	require.NoError(t, yaml.Unmarshal([]byte(clashShadowsocksPluginsYAML), &clash))
+	require.Len(t, clash.Proxies, 4)
+
+	_, err := clash.Proxies[2].outbound()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tls has no Xray equivalent")
+
+	_, err = clash.Proxies[3].outbound()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "shadow-tls has no Xray equivalent")
+}
+
+// A Clash.Meta profile in the layout subscription providers export, with
+// flow-style plugin-opts, quoted names, and the groups and rules around the
+// proxies.
+const clashMetaExportedProfileYAML = `mixed-port: 7890
+allow-lan: false
+mode: rule
+log-level: info
+external-controller: 127.0.0.1:9090
+dns:
+  enable: true
+  enhanced-mode: fake-ip
+  nameserver:
+    - https://doh.pub/dns-query
+proxies:
+  - {name: "🇭🇰 香港 01", server: hk01.example.net, port: 31001, type: ss, cipher: aes-128-gcm, password: hk01-pass, plugin: obfs, plugin-opts: {mode: http, host: 0e2c1a.download.windowsupdate.com}, udp: true}
+  - {name: "🇯🇵 日本 01", server: jp01.example.net, port: 443, type: ss, cipher: chacha20-ietf-poly1305, password: jp01-pass, plugin: v2ray-plugin, plugin-opts: {mode: websocket, tls: true, host: jp01.example.net, path: /ss, mux: false}, udp: true}
+  - {name: "🇸🇬 新加坡 01", server: sg01.example.net, port: 31003, type: ss, cipher: aes-256-gcm, password: sg01-pass, plugin: obfs, plugin-opts: {mode: tls, host: www.bing.com}}
+  - {name: "🇺🇸 美国 01", server: us01.example.net, port: 443, type: ss, cipher: 2022-blake3-aes-128-gcm, password: dXMwMS1wYXNzLTIwMjI=, client-fingerprint: chrome, plugin: shadow-tls, plugin-opts: {host: www.apple.com, password: us01-stls, version: 3}}
+proxy-groups:
+  - {name: 节点选择, type: select, proxies: ["🇭🇰 香港 01", "🇯🇵 日本 01", "🇸🇬 新加坡 01", "🇺🇸 美国 01"]}
+  - {name: 自动选择, type: url-test, proxies: ["🇭🇰 香港 01", "🇯🇵 日本 01"], url: "http://www.gstatic.com/generate_204", interval: 300}
+rules:
+  - DOMAIN-SUFFIX,cn,DIRECT
+  - GEOIP,CN,DIRECT
+  - MATCH,节点选择
+`
+
+func TestClashShadowsocks_ExportedProfile(t *testing.T) {
+	config, diagnostics, err := ConvertShareLinksToXrayJsonWithDiagnostics(clashMetaExportedProfileYAML, SignatureOptions{}, AgeDecryptOptions{}, nil)
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 2)
+
+	obfs := config.OutboundConfigs[0]
+	assert.Equal(t, "🇭🇰 香港 01", getOutboundName(obfs))
+	require.NotNil(t, obfs.StreamSetting)
+	var header XrayRawSettingsHeader
+	require.NoError(t, json.Unmarshal(obfs.StreamSetting.RAWSettings.HeaderConfig, &header))
+	assert.Equal(t, "http", header.Type)
+	assert.Equal(t, []string{"0e2c1a.download.windowsupdate.com"}, header.Request.Headers.Host)
+
+	ws := config.OutboundConfigs[1]
+	assert.Equal(t, "🇯🇵 日本 01", getOutboundName(ws))
+	require.NotNil(t, ws.StreamSetting)
+	assert.Equal(t, "/ss", ws.StreamSetting.WSSettings.Path)
+	assert.Equal(t, "tls", ws.StreamSetting.Security)
+	assert.Equal(t, "jp01.example.net", ws.StreamSetting.TLSSettings.ServerName)
+
+	require.Len(t, diagnostics, 2)
+	assert.Equal(t, "🇸🇬 新加坡 01 (ss sg01.example.net:31003)", diagnostics[0].Text)
+	assert.Contains(t, diagnostics[0].Error, "tls has no Xray equivalent")
+	assert.Equal(t, "🇺🇸 美国 01 (ss us01.example.net:443)", diagnostics[1].Text)
+	assert.Contains(t, diagnostics[1].Error, "shadow-tls has no Xray equivalent")
 }
 
 func TestClashVmess_WsTLS(t *testing.T) {
@@ -335,6 +523,40 @@ func TestClashSocks5_WithAuth(t *testing.T) {
 	assert.Equal(t, "sp", s.Password)
 }
 
+func TestClashHTTP_WithAuthTLSAndHeaders(t *testing.T) {
+	yaml := `proxies:
+  - name: corp
+    type: http
+    server: proxy.corp.example
+    port: 8443
+    username: hu
+    password: hp
+    tls: true
+    sni: proxy.corp.example
+    skip-cert-verify: true
+    headers:
+      X-Client: libxray`
+	cfg := parseClashYAML(t, yaml)
+	require.Len(t, cfg.OutboundConfigs, 1)
+	outbound := cfg.OutboundConfigs[0]
+	assert.Equal(t, "http", outbound.Protocol)
+	assert.Equal(t, "corp", getOutboundName(outbound))
+
+	var s conf.HTTPClientConfig
+	require.NoError(t, json.Unmarshal(*outbound.Settings, &s))
+	assert.Equal(t, uint16(8443), s.Port)
+	assert.Equal(t, "hu", s.Username)
+	assert.Equal(t, "hp", s.Password)
+	assert.Equal(t, map[string]string{"X-Client": "libxray"}, s.Headers)
+
+	ss := outbound.StreamSetting
+	require.NotNil(t, ss)
+	assert.Equal(t, "tls", ss.Security)
+	require.NotNil(t, ss.TLSSettings)
+	assert.Equal(t, "proxy.corp.example", ss.TLSSettings.ServerName)
+	assert.True(t, ss.TLSSettings.AllowInsecure)
+}
+
 func TestClashTrojan_TlsFromType(t *testing.T) {
 	yaml := `proxies:
   - name: tr
@@ -373,3 +595,120 @@ func TestClashVless_Reality(t *testing.T) {
 	require.NotNil(t, ss.REALITYSettings)
 	assert.Equal(t, "XYAbCdEfGhIjKlMnOpQrStUvWxYz0123456789AB", ss.REALITYSettings.PublicKey)
 }
+
+func TestConvertXrayJsonToClashYaml_RoundTrip(t *testing.T) {
+	links := strings.Join([]string{
+		"vless://" + testShareUUID + "@r.example.com:443?encryption=none&flow=xtls-rprx-vision&security=reality&sni=www.microsoft.com&fp=chrome&pbk=jNXHt1yRo0vDuchQlIP6Z0ZvjT3KtzVI-T4E7RoLJS0&sid=abcdef01&type=tcp#reality",
+		"vmess://" + testShareUUID + "@ws.example.com:443?encryption=auto&security=tls&sni=ws.example.com&alpn=h2,http/1.1&type=ws&host=cdn.example.com&path=%2Fray#vmess-ws",
+		"trojan://secret@grpc.example.com:443?security=tls&sni=grpc.example.com&type=grpc&serviceName=Tun#trojan-grpc",
+		"vless://" + testShareUUID + "@x.example.com:443?encryption=none&security=tls&sni=x.example.com&type=xhttp&host=x.example.com&path=%2Fx&mode=packet-up#xhttp",
+		"ss://" + ssUserB64("aes-128-gcm", "pass") + "@ss.example.com:8388?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dbing.com#ss-obfs",
+		"hysteria2://auth@hy2.example.com:443?sni=hy2.example.com&up=100+mbps&down=200+mbps&ports=20000-30000&hop-interval=30&obfs=salamander&obfs-password=obfs#hy2",
+		"socks://" + base64.StdEncoding.EncodeToString([]byte("u:p")) + "@127.0.0.1:1080#socks",
+		"https://u:p@proxy.example.com:443#https-proxy",
+	}, "\n")
+	config, err := ConvertShareLinksToXrayJson(links)
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 8)
+	config.OutboundConfigs = append(config.OutboundConfigs, conf.OutboundDetourConfig{Protocol: "freedom", Tag: "direct"})
+	xrayBytes, err := json.Marshal(config)
+	require.NoError(t, err)
+
+	text, err := ConvertXrayJsonToClashYaml(xrayBytes, "Proxy")
+	require.NoError(t, err)
+
+	var clash ClashYaml
+	require.NoError(t, yaml.Unmarshal([]byte(text), &clash))
+	require.Len(t, clash.Proxies, 8)
+	require.Len(t, clash.ProxyGroups, 1)
+	assert.Equal(t, ClashProxyGroup{
+		Name: "Proxy",
+		Type: "select",
+		Proxies: []string{
+			"reality", "vmess-ws", "trojan-grpc", "xhttp",
+			"ss-obfs", "hy2", "socks", "https-proxy",
+		},
+	}, clash.ProxyGroups[0])
+
+	reality := clash.Proxies[0]
+	assert.Equal(t, "vless", reality.Type)
+	assert.Equal(t, "xtls-rprx-vision", reality.Flow)
+	assert.True(t, reality.Tls)
+	assert.Equal(t, "www.microsoft.com", reality.Servername)
+	assert.Equal(t, "chrome", reality.ClientFingerprint)
+	require.NotNil(t, reality.RealityOpts)
+	assert.Equal(t, "abcdef01", reality.RealityOpts.ShortId)
+
+	ss := clash.Proxies[4]
+	assert.Equal(t, "obfs", ss.Plugin)
+	require.NotNil(t, ss.PluginOpts)
+	assert.Equal(t, ClashProxyPluginOpts{Mode: "http", Host: "bing.com"}, *ss.PluginOpts)
+
+	hy2 := clash.Proxies[5]
+	assert.Equal(t, "hy2.example.com", hy2.Sni)
+	assert.Equal(t, "20000-30000", hy2.Ports)
+	assert.Equal(t, int32(30), hy2.HopInterval)
+
+	// Clash.Meta http proxies cannot relay UDP.
+	assert.True(t, clash.Proxies[6].Udp)
+	assert.False(t, clash.Proxies[7].Udp)
+
+	reparsed, err := tryToParseClashYaml(text, nil)
+	require.NoError(t, err)
+	require.Len(t, reparsed.OutboundConfigs, len(config.OutboundConfigs)-1)
+	for index, outbound := range reparsed.OutboundConfigs {
+		original := config.OutboundConfigs[index]
+		assert.Equal(t, original.Protocol, outbound.Protocol)
+		assert.Equal(t, getOutboundName(original), getOutboundName(outbound))
+		assert.JSONEq(t, string(*original.Settings), string(*outbound.Settings), getOutboundName(original))
+		if original.StreamSetting != nil && original.Protocol != "shadowsocks" {
+			require.NotNil(t, outbound.StreamSetting)
+			assert.Equal(t, original.StreamSetting.Security, outbound.StreamSetting.Security, getOutboundName(original))
+		}
+	}
+}
+
+func TestConvertXrayJsonToClashYaml_WireGuardAndDuplicateNames(t *testing.T) {
+	config, err := ConvertShareLinksToXrayJson(strings.Join([]string{
+		"wireguard://" + url.QueryEscape(testWireGuardPrivateKey) + "@203.0.113.1:51820?publickey=" +
+			url.QueryEscape(testWireGuardPublicKey) + "&address=172.16.0.2%2F32,fd01::2%2F128&reserved=1,2,3&mtu=1280#node",
+		"trojan://secret@a.example.com:443?security=tls&sni=a.example.com#node",
+		"trojan://secret@b.example.com:443?security=tls&sni=b.example.com#node",
+	}, "\n"))
+	require.NoError(t, err)
+	xrayBytes, err := json.Marshal(config)
+	require.NoError(t, err)
+
+	text, err := ConvertXrayJsonToClashYaml(xrayBytes, "")
+	require.NoError(t, err)
+	assert.NotContains(t, text, "proxy-groups")
+	assert.Contains(t, text, "reserved:\n      - 1\n      - 2\n      - 3\n")
+
+	var clash ClashYaml
+	require.NoError(t, yaml.Unmarshal([]byte(text), &clash))
+	require.Len(t, clash.Proxies, 3)
+	assert.Equal(t, []string{"node", "node 2", "node 3"},
+		[]string{clash.Proxies[0].Name, clash.Proxies[1].Name, clash.Proxies[2].Name})
+
+	wireGuard := clash.Proxies[0]
+	assert.Equal(t, "wireguard", wireGuard.Type)
+	assert.Equal(t, "203.0.113.1", wireGuard.Server)
+	assert.Equal(t, uint16(51820), wireGuard.Port)
+	assert.Equal(t, "172.16.0.2/32", wireGuard.Ip)
+	assert.Equal(t, "fd01::2/128", wireGuard.Ipv6)
+	assert.Equal(t, ClashReserved{1, 2, 3}, wireGuard.Reserved)
+}
+
+func TestConvertXrayJsonToClashYaml_Errors(t *testing.T) {
+	_, err := ConvertXrayJsonToClashYaml([]byte(`{`), "")
+	assert.Error(t, err)
+	_, err = ConvertXrayJsonToClashYaml([]byte(`{"outbounds":[]}`), "")
+	assert.EqualError(t, err, "no valid outbounds")
+	_, err = ConvertXrayJsonToClashYaml([]byte(`{"outbounds":[{"protocol":"freedom"}]}`), "Proxy")
+	assert.EqualError(t, err, "no valid outbounds")
+
+	config, err := ConvertShareLinksToXrayJson("vless://" + testShareUUID + "@u.example.com:443?encryption=none&security=tls&sni=u.example.com&type=httpupgrade&path=%2Fu#upgrade")
+	require.NoError(t, err)
+	_, err = clashProxy(config.OutboundConfigs[0])
+	assert.EqualError(t, err, "unsupported network for Clash: httpupgrade")
+}
@@ -0,0 +1,192 @@
+package share
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// OutboundConfigFingerprint hashes the whole outbound except its name
+// (sendThrough) and tag, with JSON keys sorted, so two outbounds share it
+// exactly when they would connect the same way. Outbounds without a server,
+// such as freedom and blackhole, keep their tag in the hash, so "direct" and
+// "bypass" get different fingerprints. Unlike OutboundFingerprint, it
+// changes with the transport and TLS settings.
+func OutboundConfigFingerprint(outbound conf.OutboundDetourConfig) (string, error) {
+	outbound.SendThrough = nil
+	if _, hasServer := outboundIdentity(outbound); hasServer {
+		outbound.Tag = ""
+	}
+	// Marshal through a pointer so that Int32Range fields keep their string
+	// form.
+	encoded, err := json.Marshal(&outbound)
+	if err != nil {
+		return "", err
+	}
+	// Decoding into interfaces sorts the keys of settings, which are kept
+	// as the raw JSON they were read from.
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var canonical any
+	if err := decoder.Decode(&canonical); err != nil {
+		return "", err
+	}
+	if encoded, err = json.Marshal(canonical); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// DedupedOutbounds is the result of DedupeOutbounds. Fingerprints and
+// Indexes follow the input: Fingerprints[i] is the OutboundConfigFingerprint
+// of input outbound i, and Indexes[i] the position in Outbounds of the
+// outbound it collapsed into.
+type DedupedOutbounds struct {
+	Outbounds    []conf.OutboundDetourConfig
+	Fingerprints []string
+	Indexes      []int
+}
+
+// DedupeOutbounds keeps the first of every group of outbounds with one
+// OutboundConfigFingerprint.
+func DedupeOutbounds(outbounds []conf.OutboundDetourConfig) (*DedupedOutbounds, error) {
+	return dedupeOutbounds(outbounds, func(string) bool { return false })
+}
+
+// dedupeOutbounds also keeps every outbound whose tag is referenced, even
+// when an earlier one has its fingerprint.
+func dedupeOutbounds(outbounds []conf.OutboundDetourConfig, referenced func(tag string) bool) (*DedupedOutbounds, error) {
+	deduped := &DedupedOutbounds{
+		Fingerprints: make([]string, len(outbounds)),
+		Indexes:      make([]int, len(outbounds)),
+	}
+	kept := make(map[string]int, len(outbounds))
+	for index, outbound := range outbounds {
+		fingerprint, err := OutboundConfigFingerprint(outbound)
+		if err != nil {
+			return nil, fmt.Errorf("outbound %d: %w", index, err)
+		}
+		deduped.Fingerprints[index] = fingerprint
+
+		keptIndex, found := kept[fingerprint]
+		if found && (outbound.Tag == "" || !referenced(outbound.Tag)) {
+			deduped.Indexes[index] = keptIndex
+			continue
+		}
+		if !found {
+			kept[fingerprint] = len(deduped.Outbounds)
+		}
+		deduped.Indexes[index] = len(deduped.Outbounds)
+		deduped.Outbounds = append(deduped.Outbounds, outbound)
+	}
+	return deduped, nil
+}
+
+// xrayJsonOutboundReferences reads the routing of an Xray config for the
+// outbound tags that rules, balancers and outbound chains name.
+type xrayJsonOutboundReferences struct {
+	Routing *struct {
+		Rules []struct {
+			OutboundTag string `json:"outboundTag"`
+		} `json:"rules"`
+		Balancers []struct {
+			Selector    []string `json:"selector"`
+			FallbackTag string   `json:"fallbackTag"`
+		} `json:"balancers"`
+	} `json:"routing"`
+}
+
+// referencedOutboundTags reports whether the config names tag in a routing
+// rule, a balancer selector (by prefix) or fallback, or the proxySettings or
+// dialerProxy of an outbound. Dropping such an outbound would leave the
+// config unable to build.
+func referencedOutboundTags(xrayBytes []byte, outbounds []conf.OutboundDetourConfig) (func(tag string) bool, error) {
+	var references xrayJsonOutboundReferences
+	if err := json.Unmarshal(xrayBytes, &references); err != nil {
+		return nil, fmt.Errorf("invalid routing: %w", err)
+	}
+	tags := make(map[string]bool)
+	var prefixes []string
+	if routing := references.Routing; routing != nil {
+		for _, rule := range routing.Rules {
+			tags[rule.OutboundTag] = true
+		}
+		for _, balancer := range routing.Balancers {
+			tags[balancer.FallbackTag] = true
+			prefixes = append(prefixes, balancer.Selector...)
+		}
+	}
+	for _, outbound := range outbounds {
+		if outbound.ProxySettings != nil {
+			tags[outbound.ProxySettings.Tag] = true
+		}
+		if outbound.StreamSetting != nil && outbound.StreamSetting.SocketSettings != nil {
+			tags[outbound.StreamSetting.SocketSettings.DialerProxy] = true
+		}
+	}
+	return func(tag string) bool {
+		if tags[tag] {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(tag, prefix) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// DedupeXrayJsonOutbounds runs DedupeOutbounds on the outbounds of an Xray
+// config and returns the config without the dropped ones. Kept outbounds
+// and every other top-level field are written back unchanged. A duplicate
+// whose tag routing rules, balancers or other outbounds name is kept, so
+// the config still builds.
+func DedupeXrayJsonOutbounds(xrayBytes []byte) ([]byte, *DedupedOutbounds, error) {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(xrayBytes, &root); err != nil {
+		return nil, nil, err
+	}
+	var rawOutbounds []json.RawMessage
+	if err := json.Unmarshal(root["outbounds"], &rawOutbounds); err != nil {
+		return nil, nil, fmt.Errorf("invalid outbounds: %w", err)
+	}
+	if len(rawOutbounds) == 0 {
+		return nil, nil, fmt.Errorf("no outbounds")
+	}
+	outbounds := make([]conf.OutboundDetourConfig, len(rawOutbounds))
+	for index, raw := range rawOutbounds {
+		if err := json.Unmarshal(raw, &outbounds[index]); err != nil {
+			return nil, nil, fmt.Errorf("outbound %d: %w", index, err)
+		}
+	}
+
+	referenced, err := referencedOutboundTags(xrayBytes, outbounds)
+	if err != nil {
+		return nil, nil, err
+	}
+	deduped, err := dedupeOutbounds(outbounds, referenced)
+	if err != nil {
+		return nil, nil, err
+	}
+	emitted := make([]json.RawMessage, 0, len(deduped.Outbounds))
+	for index, raw := range rawOutbounds {
+		if deduped.Indexes[index] == len(emitted) {
+			emitted = append(emitted, raw)
+		}
+	}
+	if root["outbounds"], err = json.Marshal(emitted); err != nil {
+		return nil, nil, err
+	}
+	encoded, err := json.Marshal(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encoded, deduped, nil
+}
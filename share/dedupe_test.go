@@ -0,0 +1,133 @@
+package share
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestOutboundConfigFingerprint(t *testing.T) {
+	outbounds := outboundsForTest(t, `[
+		{"protocol":"vless","tag":"A","sendThrough":"A","settings":{"address":"example.com","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","encryption":"none"},
+		 "streamSettings":{"network":"ws","security":"tls","wsSettings":{"path":"/ws"}}},
+		{"tag":"B","sendThrough":"B from another provider","protocol":"vless","settings":{"encryption":"none","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","address":"example.com"},
+		 "streamSettings":{"security":"tls","wsSettings":{"path":"/ws"},"network":"ws"}},
+		{"protocol":"vless","settings":{"address":"example.com","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","encryption":"none"},
+		 "streamSettings":{"network":"grpc","security":"tls"}},
+		{"protocol":"vless","settings":{"address":"example.com","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","encryption":"none"},
+		 "streamSettings":{"network":"ws","security":"tls","wsSettings":{"path":"/other"}}}
+	]`)
+	fingerprints := make([]string, len(outbounds))
+	for index, outbound := range outbounds {
+		fingerprint, err := OutboundConfigFingerprint(outbound)
+		require.NoError(t, err)
+		assert.Len(t, fingerprint, 32)
+		fingerprints[index] = fingerprint
+	}
+
+	// Name, tag and key order do not matter; the transport does.
+	assert.Equal(t, fingerprints[0], fingerprints[1])
+	assert.NotEqual(t, fingerprints[0], fingerprints[2])
+	assert.NotEqual(t, fingerprints[0], fingerprints[3])
+	assert.NotEqual(t, fingerprints[2], fingerprints[3])
+
+	again, err := OutboundConfigFingerprint(outbounds[0])
+	require.NoError(t, err)
+	assert.Equal(t, fingerprints[0], again)
+	assert.Equal(t, "A", outbounds[0].Tag)
+}
+
+func TestDedupeOutbounds(t *testing.T) {
+	outbounds := outboundsForTest(t, `[
+		{"protocol":"trojan","tag":"a","sendThrough":"A","settings":{"address":"a.example","port":443,"password":"a"}},
+		{"protocol":"trojan","tag":"b","sendThrough":"B","settings":{"address":"b.example","port":443,"password":"b"}},
+		{"protocol":"trojan","tag":"a2","sendThrough":"A again","settings":{"password":"a","port":443,"address":"a.example"}},
+		{"protocol":"freedom","tag":"direct"},
+		{"protocol":"freedom","tag":"bypass"},
+		{"protocol":"trojan","sendThrough":"B again","settings":{"address":"b.example","port":443,"password":"b"}},
+		{"protocol":"trojan","tag":"a-ws","settings":{"address":"a.example","port":443,"password":"a"},"streamSettings":{"network":"ws"}}
+	]`)
+	deduped, err := DedupeOutbounds(outbounds)
+	require.NoError(t, err)
+
+	require.Len(t, deduped.Outbounds, 5)
+	assert.Equal(t, []string{"a", "b", "direct", "bypass", "a-ws"}, []string{
+		deduped.Outbounds[0].Tag, deduped.Outbounds[1].Tag, deduped.Outbounds[2].Tag, deduped.Outbounds[3].Tag, deduped.Outbounds[4].Tag,
+	})
+	assert.Equal(t, []int{0, 1, 0, 2, 3, 1, 4}, deduped.Indexes)
+	require.Len(t, deduped.Fingerprints, len(outbounds))
+	for index, outbound := range outbounds {
+		fingerprint, err := OutboundConfigFingerprint(outbound)
+		require.NoError(t, err)
+		assert.Equal(t, fingerprint, deduped.Fingerprints[index])
+	}
+	assert.Equal(t, deduped.Fingerprints[0], deduped.Fingerprints[2])
+	assert.Equal(t, deduped.Fingerprints[1], deduped.Fingerprints[5])
+	// Without a server, the tag is part of the fingerprint.
+	assert.NotEqual(t, deduped.Fingerprints[3], deduped.Fingerprints[4])
+	// Another transport to the same server is another fingerprint.
+	assert.NotEqual(t, deduped.Fingerprints[0], deduped.Fingerprints[6])
+}
+
+func TestDedupeXrayJsonOutbounds(t *testing.T) {
+	xrayJson := `{
+		"log":{"loglevel":"warning"},
+		"outbounds":[
+			{"protocol":"trojan","tag":"a","settings":{"address":"a.example","port":443,"password":"a"}},
+			{"protocol":"trojan","tag":"a2","settings":{"address":"a.example","port":443,"password":"a"}},
+			{"protocol":"freedom","tag":"direct","settings":{"domainStrategy":"UseIP"}}
+		]
+	}`
+	encoded, deduped, err := DedupeXrayJsonOutbounds([]byte(xrayJson))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 0, 1}, deduped.Indexes)
+
+	var root struct {
+		Log       json.RawMessage   `json:"log"`
+		Outbounds []json.RawMessage `json:"outbounds"`
+	}
+	require.NoError(t, json.Unmarshal(encoded, &root))
+	assert.JSONEq(t, `{"loglevel":"warning"}`, string(root.Log))
+	require.Len(t, root.Outbounds, 2)
+	assert.JSONEq(t, `{"protocol":"freedom","tag":"direct","settings":{"domainStrategy":"UseIP"}}`, string(root.Outbounds[1]))
+
+	for _, invalid := range []string{`{`, `{"outbounds":{}}`, `{"outbounds":[]}`, `{"outbounds":[{"protocol":1}]}`} {
+		_, _, err := DedupeXrayJsonOutbounds([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDedupeXrayJsonOutboundsKeepsReferencedTags(t *testing.T) {
+	xrayJson := `{
+		"outbounds":[
+			{"protocol":"trojan","tag":"a","settings":{"address":"a.example","port":443,"password":"a"}},
+			{"protocol":"trojan","tag":"ruled","settings":{"address":"a.example","port":443,"password":"a"}},
+			{"protocol":"trojan","tag":"lb-1","settings":{"address":"a.example","port":443,"password":"a"}},
+			{"protocol":"trojan","tag":"chained","settings":{"address":"a.example","port":443,"password":"a"}},
+			{"protocol":"trojan","tag":"dialed","settings":{"address":"a.example","port":443,"password":"a"}},
+			{"protocol":"trojan","tag":"unused","settings":{"address":"a.example","port":443,"password":"a"}},
+			{"protocol":"vless","tag":"front","settings":{"address":"f.example","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","encryption":"none"},"proxySettings":{"tag":"chained"}},
+			{"protocol":"vless","tag":"front2","settings":{"address":"g.example","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","encryption":"none"},"streamSettings":{"sockopt":{"dialerProxy":"dialed"}}}
+		],
+		"routing":{
+			"rules":[{"domain":["example.com"],"outboundTag":"ruled"},{"ip":["10.0.0.0/8"],"balancerTag":"lb"}],
+			"balancers":[{"tag":"lb","selector":["lb-"]}]
+		}
+	}`
+	encoded, deduped, err := DedupeXrayJsonOutbounds([]byte(xrayJson))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 0, 5, 6}, deduped.Indexes)
+
+	var config conf.Config
+	require.NoError(t, json.Unmarshal(encoded, &config))
+	tags := make([]string, len(config.OutboundConfigs))
+	for index, outbound := range config.OutboundConfigs {
+		tags[index] = outbound.Tag
+	}
+	assert.Equal(t, []string{"a", "ruled", "lb-1", "chained", "dialed", "front", "front2"}, tags)
+	_, err = config.Build()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,225 @@
+package share
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const (
+	ShareDiagnosticStageFetch = "fetch"
+	ShareDiagnosticStageParse = "parse"
+	ShareDiagnosticStageBuild = "build"
+)
+
+// ShareDiagnostic explains why one entry of a share text produced no
+// outbound.
+type ShareDiagnostic struct {
+	// Line is the 1-based line of a line based format: share links, proxy
+	// lines, and the ssconf:// key a fetched document came from. Lines of a
+	// base64 or age subscription count lines of the decoded text.
+	Line int `json:"line,omitempty"`
+	// Index is the 0-based entry of a YAML or JSON list: Clash proxies,
+	// sing-box outbounds, SIP008 servers, Xray outbounds.
+	Index *int `json:"index,omitempty"`
+	// Text identifies the entry with credentials removed.
+	Text  string `json:"text,omitempty"`
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// ConvertShareLinksToXrayJsonWithDiagnostics is ConvertShareLinksToXrayJson
+// that also reports every entry it dropped and why. A signature with
+// trusted keys is verified first, as in
+// ConvertShareLinksToXrayJsonWithSignature. ageOptions then decrypts an
+// age armored subscription as in ConvertShareLinksToXrayJsonWithAgeOptions. A
+// non-nil client fetches ssconf:// keys as in
+// ConvertShareLinksToXrayJsonWithClient; with a nil client they fail with
+// ErrSSConfNotFetched.
+//
+// Diagnostics are returned with the error too, when no entry survived.
+func ConvertShareLinksToXrayJsonWithDiagnostics(links string, signature SignatureOptions, ageOptions AgeDecryptOptions, client *http.Client) (*conf.Config, []ShareDiagnostic, error) {
+	if len(signature.TrustedKeys) != 0 || signature.Signature != "" {
+		signed, err := VerifySubscriptionSignature(links, signature)
+		if err != nil {
+			return nil, nil, err
+		}
+		links = signed
+	}
+	plaintext, encrypted, err := decryptAgeSubscription(links, ageOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	if encrypted {
+		links = plaintext
+	}
+
+	diagnostics := &shareDiagnostics{}
+	var config *conf.Config
+	if client != nil {
+		config, err = convertShareLinksWithClient(links, client, diagnostics)
+	} else {
+		config, err = convertShareLinks(links, diagnostics)
+	}
+	if err != nil && encrypted {
+		err = ErrAgePlaintextUnsupported
+	}
+	return config, diagnostics.entries, err
+}
+
+// shareDiagnostics collects the dropped entries of one conversion, and the
+// origin of every kept outbound so that a later build failure can name its
+// entry. A nil *shareDiagnostics collects nothing.
+type shareDiagnostics struct {
+	entries []ShareDiagnostic
+	origins []ShareDiagnostic
+	// lineOffset counts the lines trimmed from the start of the input.
+	lineOffset int
+}
+
+func (diagnostics *shareDiagnostics) lineOrigin(line int, text string) ShareDiagnostic {
+	if diagnostics != nil {
+		line += diagnostics.lineOffset
+	}
+	return ShareDiagnostic{Line: line, Text: text}
+}
+
+func indexOrigin(index int, text string) ShareDiagnostic {
+	return ShareDiagnostic{Index: &index, Text: text}
+}
+
+func (diagnostics *shareDiagnostics) keep(origin ShareDiagnostic) {
+	if diagnostics == nil {
+		return
+	}
+	diagnostics.origins = append(diagnostics.origins, origin)
+}
+
+func (diagnostics *shareDiagnostics) drop(origin ShareDiagnostic, stage string, err error) {
+	if diagnostics == nil {
+		return
+	}
+	origin.Stage = stage
+	origin.Error = err.Error()
+	diagnostics.entries = append(diagnostics.entries, origin)
+}
+
+// origin returns the origin of outbound index of a config of count
+// outbounds, or nil when the parser did not record origins.
+func (diagnostics *shareDiagnostics) origin(index, count int) *ShareDiagnostic {
+	if diagnostics == nil || len(diagnostics.origins) != count {
+		return nil
+	}
+	return &diagnostics.origins[index]
+}
+
+// redactShareLink keeps the scheme, server and name of a share link. User
+// info, query and the base64 body of vmess:// and legacy ss:// links can
+// carry credentials and are removed.
+func redactShareLink(line string) string {
+	if body, ok := strings.CutPrefix(line, "vmess://"); ok && !strings.Contains(body, "@") {
+		body, name, _ := strings.Cut(body, "#")
+		if server := redactVMessQRCode(body); server != "" {
+			return "vmess://" + server
+		}
+		if name, err := url.PathUnescape(name); err == nil && name != "" {
+			return "vmess://…#" + name
+		}
+		return "vmess://…"
+	}
+	u, err := url.Parse(line)
+	if err != nil || u.Scheme == "" {
+		return redactOpaque(line)
+	}
+	name := ""
+	if u.Fragment != "" {
+		name = "#" + u.Fragment
+	}
+	if u.User == nil && u.Scheme == "ss" {
+		return "ss://…" + name
+	}
+	var builder strings.Builder
+	builder.WriteString(u.Scheme + "://")
+	if u.User != nil {
+		builder.WriteString("***@")
+	}
+	builder.WriteString(u.Host)
+	if u.Path != "" && u.Path != "/" {
+		builder.WriteString("/…")
+	}
+	if u.RawQuery != "" {
+		builder.WriteString("?…")
+	}
+	builder.WriteString(name)
+	return builder.String()
+}
+
+// redactVMessQRCode returns "server:port#name" of a vmess:// body.
+func redactVMessQRCode(body string) string {
+	decoded, err := decodeBase64Text(body)
+	if err != nil {
+		return ""
+	}
+	var qrCode struct {
+		Ps   string          `json:"ps"`
+		Add  string          `json:"add"`
+		Port json.RawMessage `json:"port"`
+	}
+	if err := json.Unmarshal([]byte(decoded), &qrCode); err != nil || qrCode.Add == "" {
+		return ""
+	}
+	server := qrCode.Add + ":" + strings.Trim(string(qrCode.Port), `"`)
+	if qrCode.Ps != "" {
+		server += "#" + qrCode.Ps
+	}
+	return server
+}
+
+// redactProxyLine keeps the name, type, server and port of a Surge, Loon
+// or Quantumult X proxy line.
+func redactProxyLine(line string) string {
+	if isQuantumultXProxyLine(line) {
+		items := splitProxyLine(line)
+		text := items[0].Key + "=" + items[0].Value + ", …"
+		for _, item := range items[1:] {
+			if item.Key == "tag" {
+				text += ", tag=" + item.Value
+			}
+		}
+		return text
+	}
+	name, rest, _ := strings.Cut(line, "=")
+	items := splitProxyLine(rest)
+	values := make([]string, 0, 4)
+	for _, item := range items[:min(3, len(items))] {
+		values = append(values, item.Value)
+	}
+	return strings.TrimSpace(name) + " = " + strings.Join(values, ", ") + ", …"
+}
+
+// redactEntry describes a YAML or JSON list entry without its credentials.
+func redactEntry(name, protocol, server string, port uint16) string {
+	text := protocol
+	if server != "" {
+		text = fmt.Sprintf("%s %s:%d", protocol, server, port)
+	}
+	if name == "" {
+		return text
+	}
+	return fmt.Sprintf("%s (%s)", name, text)
+}
+
+// redactOpaque is used for text no parser recognized. Only a short prefix
+// is kept.
+func redactOpaque(line string) string {
+	const keep = 8
+	runes := []rune(line)
+	if len(runes) <= keep {
+		return line
+	}
+	return string(runes[:keep]) + "…"
+}
@@ -0,0 +1,520 @@
+package share
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// ConvertXrayJsonToClashYaml converts Xray outbounds to Clash.Meta proxies.
+// A non-empty proxyGroupName adds a select group listing every proxy.
+// Outbounds that Clash.Meta cannot express are skipped.
+func ConvertXrayJsonToClashYaml(xrayBytes []byte, proxyGroupName string) (string, error) {
+	var xray conf.Config
+	if err := json.Unmarshal(xrayBytes, &xray); err != nil {
+		return "", err
+	}
+
+	outbounds := xray.OutboundConfigs
+	if len(outbounds) == 0 {
+		return "", fmt.Errorf("no valid outbounds")
+	}
+
+	clash := ClashYaml{}
+	names := make(map[string]int, len(outbounds))
+	for _, outbound := range outbounds {
+		proxy, err := clashProxy(outbound)
+		if err != nil {
+			continue
+		}
+		proxy.Name = uniqueOutboundName(names, proxy.Name)
+		clash.Proxies = append(clash.Proxies, *proxy)
+	}
+	if len(clash.Proxies) == 0 {
+		return "", fmt.Errorf("no valid outbounds")
+	}
+
+	if len(proxyGroupName) > 0 {
+		group := ClashProxyGroup{Name: proxyGroupName, Type: "select"}
+		for _, proxy := range clash.Proxies {
+			group.Proxies = append(group.Proxies, proxy.Name)
+		}
+		clash.ProxyGroups = []ClashProxyGroup{group}
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(clash); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// uniqueOutboundName suffixes repeated names, because Clash and sing-box
+// refer to proxies by name in groups and rules.
+func uniqueOutboundName(names map[string]int, name string) string {
+	names[name]++
+	if names[name] == 1 {
+		return name
+	}
+	for {
+		candidate := fmt.Sprintf("%s %d", name, names[name])
+		if names[candidate] == 0 {
+			names[candidate]++
+			return candidate
+		}
+		names[name]++
+	}
+}
+
+func clashProxy(outbound conf.OutboundDetourConfig) (*ClashProxy, error) {
+	proxy := &ClashProxy{}
+	proxy.Name = getOutboundName(outbound)
+	// Xray outbounds have no switch for UDP: every protocol here relays it
+	// except HTTP, which only tunnels TCP.
+	proxy.Udp = outbound.Protocol != "http"
+
+	var err error
+	switch outbound.Protocol {
+	case "shadowsocks":
+		err = proxy.fromShadowsocks(outbound)
+	case "vmess":
+		err = proxy.fromVmess(outbound)
+	case "vless":
+		err = proxy.fromVless(outbound)
+	case "socks":
+		err = proxy.fromSocks(outbound)
+	case "http":
+		err = proxy.fromHTTP(outbound)
+	case "trojan":
+		err = proxy.fromTrojan(outbound)
+	case "hysteria":
+		err = proxy.fromHysteria(outbound)
+	case "wireguard":
+		err = proxy.fromWireGuard(outbound)
+	default:
+		return nil, fmt.Errorf("unsupported outbound protocol %q", outbound.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+func (proxy *ClashProxy) fromShadowsocks(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.ShadowsocksClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	proxy.Type = "ss"
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Cipher = settings.Cipher
+	proxy.Password = settings.Password
+
+	return proxy.setShadowsocksPlugin(outbound.StreamSetting)
+}
+
+// setShadowsocksPlugin is the reverse of shadowsocksPluginStreamSettings:
+// WebSocket becomes v2ray-plugin and the raw HTTP header becomes obfs.
+func (proxy *ClashProxy) setShadowsocksPlugin(streamSettings *conf.StreamConfig) error {
+	if streamSettings == nil {
+		return nil
+	}
+	switch streamNetwork(streamSettings) {
+	case "raw", "tcp":
+		header, err := rawHTTPHeader(streamSettings)
+		if err != nil || header == nil {
+			return err
+		}
+		proxy.Plugin = "obfs"
+		proxy.PluginOpts = &ClashProxyPluginOpts{Mode: "http"}
+		if header.Request != nil && header.Request.Headers != nil && len(header.Request.Headers.Host) > 0 {
+			proxy.PluginOpts.Host = header.Request.Headers.Host[0]
+		}
+	case "ws", "websocket":
+		proxy.Plugin = "v2ray-plugin"
+		proxy.PluginOpts = &ClashProxyPluginOpts{Mode: "websocket"}
+		if streamSettings.WSSettings != nil {
+			proxy.PluginOpts.Host = streamSettings.WSSettings.Host
+			proxy.PluginOpts.Path = streamSettings.WSSettings.Path
+		}
+		switch streamSettings.Security {
+		case "", "none":
+		case "tls":
+			proxy.PluginOpts.Tls = true
+			if tlsSettings := streamSettings.TLSSettings; tlsSettings != nil {
+				proxy.PluginOpts.Fingerprint = tlsSettings.Fingerprint
+				proxy.PluginOpts.SkipCertVerify = tlsSettings.AllowInsecure
+				if len(tlsSettings.ECHConfigList) > 0 {
+					proxy.PluginOpts.EchOpts = &ClashProxyEchOpts{Enable: true, Config: tlsSettings.ECHConfigList}
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported shadowsocks security for Clash: %s", streamSettings.Security)
+		}
+	default:
+		return fmt.Errorf("unsupported shadowsocks network for Clash: %s", streamNetwork(streamSettings))
+	}
+	return nil
+}
+
+func (proxy *ClashProxy) fromVmess(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.VMessOutboundConfig](outbound)
+	if err != nil {
+		return err
+	}
+	proxy.Type = "vmess"
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Uuid = settings.ID
+	proxy.Cipher = settings.Security
+	if len(proxy.Cipher) == 0 {
+		proxy.Cipher = "auto"
+	}
+
+	return proxy.setStreamSettings(outbound.StreamSetting)
+}
+
+func (proxy *ClashProxy) fromVless(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.VLessOutboundConfig](outbound)
+	if err != nil {
+		return err
+	}
+	proxy.Type = "vless"
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Uuid = settings.Id
+	proxy.Flow = settings.Flow
+	if settings.Encryption != "none" {
+		proxy.Encryption = settings.Encryption
+	}
+
+	return proxy.setStreamSettings(outbound.StreamSetting)
+}
+
+func (proxy *ClashProxy) fromSocks(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.SocksClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	proxy.Type = "socks5"
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Username = settings.Username
+	proxy.Password = settings.Password
+
+	return proxy.setStreamSettings(outbound.StreamSetting)
+}
+
+func (proxy *ClashProxy) fromHTTP(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.HTTPClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	proxy.Type = "http"
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Username = settings.Username
+	proxy.Password = settings.Password
+	if len(settings.Headers) > 0 {
+		proxy.Headers = settings.Headers
+	}
+
+	return proxy.setStreamSettings(outbound.StreamSetting)
+}
+
+func (proxy *ClashProxy) fromTrojan(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.TrojanClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	proxy.Type = "trojan"
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Password = settings.Password
+
+	if err := proxy.setStreamSettings(outbound.StreamSetting); err != nil {
+		return err
+	}
+	// Clash.Meta trojan always runs over TLS.
+	if !proxy.Tls {
+		return fmt.Errorf("trojan without tls has no Clash equivalent")
+	}
+	return nil
+}
+
+func (proxy *ClashProxy) fromHysteria(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.HysteriaClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if settings.Version != 2 {
+		return fmt.Errorf("unsupported hysteria version %d", settings.Version)
+	}
+	proxy.Type = "hysteria2"
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+
+	streamSettings := outbound.StreamSetting
+	if streamSettings == nil {
+		return nil
+	}
+	if streamSettings.HysteriaSettings != nil {
+		proxy.Password = streamSettings.HysteriaSettings.Auth
+	}
+	if finalMask := streamSettings.FinalMask; finalMask != nil {
+		if quicParams := finalMask.QuicParams; quicParams != nil {
+			proxy.Up = string(quicParams.BrutalUp)
+			proxy.Down = string(quicParams.BrutalDown)
+			if len(quicParams.UdpHop.PortList.Range) > 0 {
+				proxy.Ports = quicParams.UdpHop.PortList.String()
+			}
+			proxy.HopInterval = quicParams.UdpHop.Interval.From
+		}
+		if len(finalMask.Udp) > 0 && finalMask.Udp[0].Type == "salamander" && finalMask.Udp[0].Settings != nil {
+			var salamander conf.Salamander
+			if err := json.Unmarshal(*finalMask.Udp[0].Settings, &salamander); err != nil {
+				return err
+			}
+			proxy.Obfs = "salamander"
+			proxy.ObfsPassword = salamander.Password
+		}
+	}
+	return proxy.setSecurity(streamSettings)
+}
+
+func (proxy *ClashProxy) fromWireGuard(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.WireGuardConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if len(settings.Peers) != 1 || settings.Peers[0] == nil {
+		return fmt.Errorf("clash wireguard needs exactly one peer")
+	}
+	peer := settings.Peers[0]
+	host, port, err := net.SplitHostPort(peer.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid wireguard peer endpoint %q", peer.Endpoint)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid wireguard peer endpoint %q", peer.Endpoint)
+	}
+
+	proxy.Type = "wireguard"
+	proxy.Server = host
+	proxy.Port = uint16(portNumber)
+	proxy.PrivateKey = settings.SecretKey
+	proxy.PublicKey = peer.PublicKey
+	proxy.PreSharedKey = peer.PreSharedKey
+	proxy.AllowedIps = peer.AllowedIPs
+	proxy.Reserved = settings.Reserved
+	proxy.Mtu = settings.MTU
+	for _, address := range settings.Address {
+		if strings.Contains(address, ":") {
+			proxy.Ipv6 = address
+		} else {
+			proxy.Ip = address
+		}
+	}
+	return nil
+}
+
+func (proxy *ClashProxy) setServer(address *conf.Address, port uint16) error {
+	if address == nil || address.Address == nil {
+		return fmt.Errorf("%s outbound has no server address", proxy.Type)
+	}
+	proxy.Server = address.String()
+	proxy.Port = port
+	return nil
+}
+
+// setStreamSettings is the reverse of ClashProxy.streamSettings.
+func (proxy *ClashProxy) setStreamSettings(streamSettings *conf.StreamConfig) error {
+	if streamSettings == nil {
+		return nil
+	}
+
+	network := streamNetwork(streamSettings)
+	switch network {
+	case "raw", "tcp":
+		header, err := rawHTTPHeader(streamSettings)
+		if err != nil {
+			return err
+		}
+		if header != nil {
+			return fmt.Errorf("raw http header has no Clash equivalent for %s", proxy.Type)
+		}
+	case "ws", "websocket":
+		proxy.Network = "ws"
+		if wsSettings := streamSettings.WSSettings; wsSettings != nil {
+			proxy.WsOpts = &ClashProxyWsOpts{Path: wsSettings.Path}
+			if len(wsSettings.Host) > 0 {
+				proxy.WsOpts.Headers = &ClashProxyWsOptsHeaders{Host: wsSettings.Host}
+			}
+		}
+	case "grpc", "gun":
+		proxy.Network = "grpc"
+		if grpcSettings := streamSettings.GRPCSettings; grpcSettings != nil {
+			proxy.GrpcOpts = &ClashProxyGrpcOpts{GrpcServiceName: grpcSettings.ServiceName}
+		}
+	case "xhttp", "splithttp":
+		proxy.Network = "xhttp"
+		if xhttpSettings := streamXHTTPSettings(streamSettings); xhttpSettings != nil {
+			xhttpOpts, err := clashXHTTPOpts(xhttpSettings)
+			if err != nil {
+				return err
+			}
+			proxy.XhttpOpts = xhttpOpts
+		}
+	default:
+		return fmt.Errorf("unsupported network for Clash: %s", network)
+	}
+
+	return proxy.setSecurity(streamSettings)
+}
+
+// setSecurity is the reverse of ClashProxy.parseSecurity.
+func (proxy *ClashProxy) setSecurity(streamSettings *conf.StreamConfig) error {
+	var serverName, fingerprint string
+	switch streamSettings.Security {
+	case "", "none":
+		return nil
+	case "tls":
+		proxy.Tls = true
+		if tlsSettings := streamSettings.TLSSettings; tlsSettings != nil {
+			serverName = tlsSettings.ServerName
+			fingerprint = tlsSettings.Fingerprint
+			if tlsSettings.ALPN != nil {
+				proxy.Alpn = *tlsSettings.ALPN
+			}
+			proxy.SkipCertVerify = tlsSettings.AllowInsecure
+			if len(tlsSettings.ECHConfigList) > 0 {
+				proxy.EchOpts = &ClashProxyEchOpts{Enable: true, Config: tlsSettings.ECHConfigList}
+			}
+		}
+	case "reality":
+		proxy.Tls = true
+		if realitySettings := streamSettings.REALITYSettings; realitySettings != nil {
+			serverName = realitySettings.ServerName
+			fingerprint = realitySettings.Fingerprint
+			publicKey := realitySettings.PublicKey
+			if len(publicKey) == 0 {
+				publicKey = realitySettings.Password
+			}
+			proxy.RealityOpts = &ClashProxyRealityOpts{
+				PublicKey: publicKey,
+				ShortId:   realitySettings.ShortId,
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported security for Clash: %s", streamSettings.Security)
+	}
+
+	// Mihomo reads servername for VMess and VLESS, and sni elsewhere.
+	switch proxy.Type {
+	case "vmess", "vless":
+		proxy.Servername = serverName
+	default:
+		proxy.Sni = serverName
+	}
+	proxy.ClientFingerprint = fingerprint
+	return nil
+}
+
+func streamNetwork(streamSettings *conf.StreamConfig) string {
+	if streamSettings.Network == nil || len(*streamSettings.Network) == 0 {
+		return "raw"
+	}
+	return string(*streamSettings.Network)
+}
+
+// rawHTTPHeader returns the raw transport HTTP header, or nil when the
+// header is absent or "none".
+func rawHTTPHeader(streamSettings *conf.StreamConfig) (*XrayRawSettingsHeader, error) {
+	rawSettings := streamRAWSettings(streamSettings)
+	if rawSettings == nil || len(rawSettings.HeaderConfig) == 0 {
+		return nil, nil
+	}
+	var header XrayRawSettingsHeader
+	if err := json.Unmarshal(rawSettings.HeaderConfig, &header); err != nil {
+		return nil, err
+	}
+	switch header.Type {
+	case "", "none":
+		return nil, nil
+	case "http":
+		return &header, nil
+	default:
+		return nil, fmt.Errorf("unsupported raw header type: %s", header.Type)
+	}
+}
+
+// clashXHTTPOpts is the reverse of parseXHTTPOpts. Extra fields Mihomo has
+// no option for are dropped; downloadSettings is rejected because it
+// describes a second connection.
+func clashXHTTPOpts(xhttpSettings *conf.SplitHTTPConfig) (*ClashProxyXhttpOpts, error) {
+	xhttpOpts := &ClashProxyXhttpOpts{}
+	xhttpOpts.Path = xhttpSettings.Path
+	xhttpOpts.Host = xhttpSettings.Host
+	xhttpOpts.Mode = xhttpSettings.Mode
+
+	if len(xhttpSettings.Extra) == 0 {
+		return xhttpOpts, nil
+	}
+	var extra conf.SplitHTTPConfig
+	if err := json.Unmarshal(xhttpSettings.Extra, &extra); err != nil {
+		return nil, err
+	}
+	if extra.DownloadSettings != nil {
+		return nil, fmt.Errorf("xhttp downloadSettings is not exported to Clash")
+	}
+	if len(extra.Headers) > 0 {
+		xhttpOpts.Headers = extra.Headers
+	}
+	xhttpOpts.NoGrpcHeader = extra.NoGRPCHeader
+	xhttpOpts.XPaddingBytes = formatInt32Range(extra.XPaddingBytes)
+	xhttpOpts.ScMaxEachPostBytes = formatInt32Range(extra.ScMaxEachPostBytes)
+
+	reuseSettings := &ClashProxyXhttpOptsXMUX{
+		MaxConnections:   formatInt32Range(extra.Xmux.MaxConnections),
+		MaxConcurrency:   formatInt32Range(extra.Xmux.MaxConcurrency),
+		CMaxReuseTimes:   formatInt32Range(extra.Xmux.CMaxReuseTimes),
+		HMaxRequestTimes: formatInt32Range(extra.Xmux.HMaxRequestTimes),
+		HMaxReusableSecs: formatInt32Range(extra.Xmux.HMaxReusableSecs),
+	}
+	if *reuseSettings != (ClashProxyXhttpOptsXMUX{}) {
+		xhttpOpts.ReuseSettings = reuseSettings
+	}
+	return xhttpOpts, nil
+}
+
+// formatInt32Range writes a range the way parseInt32RangeString reads it.
+func formatInt32Range(value conf.Int32Range) string {
+	if value.From == 0 && value.To == 0 {
+		return ""
+	}
+	if value.From == value.To {
+		return strconv.Itoa(int(value.From))
+	}
+	return fmt.Sprintf("%d-%d", value.From, value.To)
+}
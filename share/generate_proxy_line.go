@@ -0,0 +1,545 @@
+package share
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+type ProxyLineFormat string
+
+const (
+	// ProxyLineFormatSurge is also read by Shadowrocket.
+	ProxyLineFormatSurge       ProxyLineFormat = "surge"
+	ProxyLineFormatLoon        ProxyLineFormat = "loon"
+	ProxyLineFormatQuantumultX ProxyLineFormat = "quantumultx"
+)
+
+// ConvertXrayJsonToProxyLines converts the outbounds of an Xray config to
+// proxy lines of one iOS client, one per line. Outbounds the client cannot
+// express are skipped.
+func ConvertXrayJsonToProxyLines(xrayBytes []byte, format ProxyLineFormat) (string, error) {
+	var writeLine func(proxyLineFields) (string, error)
+	switch format {
+	case ProxyLineFormatSurge:
+		writeLine = proxyLineFields.surgeLine
+	case ProxyLineFormatLoon:
+		writeLine = proxyLineFields.loonLine
+	case ProxyLineFormatQuantumultX:
+		writeLine = proxyLineFields.quantumultXLine
+	default:
+		return "", fmt.Errorf("unsupported proxy line format: %q", format)
+	}
+
+	var xray conf.Config
+	if err := json.Unmarshal(xrayBytes, &xray); err != nil {
+		return "", err
+	}
+	if len(xray.OutboundConfigs) == 0 {
+		return "", fmt.Errorf("no valid outbounds")
+	}
+
+	var lines []string
+	names := make(map[string]int, len(xray.OutboundConfigs))
+	for _, outbound := range xray.OutboundConfigs {
+		fields, err := proxyLineFieldsFromOutbound(outbound)
+		if err != nil {
+			continue
+		}
+		// Surge and Loon end the name at "=", Quantumult X the tag at ",",
+		// and none of them can quote a double quote.
+		fields.Name = strings.NewReplacer("=", " ", ",", " ", `"`, "").Replace(fields.Name)
+		if _, err := writeLine(*fields); err != nil {
+			continue
+		}
+		fields.Name = uniqueOutboundName(names, strings.TrimSpace(fields.Name))
+		line, err := writeLine(*fields)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("no valid outbounds")
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// proxyLineFieldsFromOutbound is the reverse of proxyLineFields.outbound.
+func proxyLineFieldsFromOutbound(outbound conf.OutboundDetourConfig) (*proxyLineFields, error) {
+	fields := &proxyLineFields{Name: getOutboundName(outbound), Protocol: outbound.Protocol}
+	var address *conf.Address
+	switch outbound.Protocol {
+	case "shadowsocks":
+		settings, err := decodeOutboundSettings[conf.ShadowsocksClientConfig](outbound)
+		if err != nil {
+			return nil, err
+		}
+		address, fields.Port = settings.Address, settings.Port
+		fields.Method = settings.Cipher
+		fields.Password = settings.Password
+	case "vmess":
+		settings, err := decodeOutboundSettings[conf.VMessOutboundConfig](outbound)
+		if err != nil {
+			return nil, err
+		}
+		address, fields.Port = settings.Address, settings.Port
+		fields.ID = settings.ID
+		fields.Method = settings.Security
+	case "vless":
+		settings, err := decodeOutboundSettings[conf.VLessOutboundConfig](outbound)
+		if err != nil {
+			return nil, err
+		}
+		if settings.Encryption != "" && settings.Encryption != "none" {
+			return nil, fmt.Errorf("vless encryption has no proxy line equivalent")
+		}
+		address, fields.Port = settings.Address, settings.Port
+		fields.ID = settings.Id
+		fields.Flow = settings.Flow
+	case "trojan":
+		settings, err := decodeOutboundSettings[conf.TrojanClientConfig](outbound)
+		if err != nil {
+			return nil, err
+		}
+		address, fields.Port = settings.Address, settings.Port
+		fields.Password = settings.Password
+	case "http":
+		settings, err := decodeOutboundSettings[conf.HTTPClientConfig](outbound)
+		if err != nil {
+			return nil, err
+		}
+		address, fields.Port = settings.Address, settings.Port
+		fields.Username = settings.Username
+		fields.Password = settings.Password
+	case "socks":
+		settings, err := decodeOutboundSettings[conf.SocksClientConfig](outbound)
+		if err != nil {
+			return nil, err
+		}
+		address, fields.Port = settings.Address, settings.Port
+		fields.Username = settings.Username
+		fields.Password = settings.Password
+	case "hysteria":
+		settings, err := decodeOutboundSettings[conf.HysteriaClientConfig](outbound)
+		if err != nil {
+			return nil, err
+		}
+		if settings.Version != 2 {
+			return nil, fmt.Errorf("unsupported hysteria version %d", settings.Version)
+		}
+		address, fields.Port = settings.Address, settings.Port
+		// Hysteria2 always runs over TLS.
+		fields.TLS = true
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", outbound.Protocol)
+	}
+	if address == nil || address.Address == nil {
+		return nil, fmt.Errorf("%s outbound has no server address", outbound.Protocol)
+	}
+	// The proxy line grammars write IPv6 servers without brackets.
+	fields.Server = strings.Trim(address.String(), "[]")
+
+	if err := fields.setStreamSettings(outbound.StreamSetting); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// setStreamSettings is the reverse of proxyLineFields.streamSettings.
+func (fields *proxyLineFields) setStreamSettings(streamSettings *conf.StreamConfig) error {
+	fields.Transport.Network = "raw"
+	if streamSettings == nil {
+		return nil
+	}
+
+	switch network := streamNetwork(streamSettings); network {
+	case "raw", "tcp":
+		header, err := rawHTTPHeader(streamSettings)
+		if err != nil {
+			return err
+		}
+		if header != nil {
+			if fields.Protocol == "shadowsocks" {
+				fields.Obfs = "http"
+			} else {
+				fields.Transport.HeaderType = "http"
+			}
+			if header.Request != nil {
+				if header.Request.Headers != nil {
+					fields.Transport.Host = strings.Join(header.Request.Headers.Host, ",")
+				}
+				fields.Transport.Path = strings.Join(header.Request.Path, ",")
+			}
+		}
+	case "ws", "websocket":
+		fields.Transport.Network = "ws"
+		if wsSettings := streamSettings.WSSettings; wsSettings != nil {
+			fields.Transport.Host = wsSettings.Host
+			fields.Transport.Path = wsSettings.Path
+			if len(wsSettings.Headers) > 0 {
+				fields.Headers = wsSettings.Headers
+			}
+		}
+	case "hysteria":
+		if streamSettings.HysteriaSettings != nil {
+			fields.Password = streamSettings.HysteriaSettings.Auth
+		}
+		if finalMask := streamSettings.FinalMask; finalMask != nil {
+			if len(finalMask.Udp) > 0 || len(finalMask.Tcp) > 0 {
+				return fmt.Errorf("hysteria masks have no proxy line equivalent")
+			}
+			if quicParams := finalMask.QuicParams; quicParams != nil {
+				if len(quicParams.UdpHop.PortList.Range) > 0 {
+					return fmt.Errorf("hysteria port hopping has no proxy line equivalent")
+				}
+				down, err := bandwidthMbps(quicParams.BrutalDown)
+				if err != nil {
+					return err
+				}
+				if down > 0 {
+					fields.Down = strconv.Itoa(down) + " mbps"
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported network for proxy lines: %s", network)
+	}
+
+	switch streamSettings.Security {
+	case "", "none":
+	case "tls":
+		fields.TLS = true
+		if tlsSettings := streamSettings.TLSSettings; tlsSettings != nil {
+			fields.ServerName = tlsSettings.ServerName
+			fields.Insecure = tlsSettings.AllowInsecure
+			fields.PinnedPeerCertSha256 = tlsSettings.PinnedPeerCertSha256
+		}
+	case "reality":
+		fields.TLS = true
+		if realitySettings := streamSettings.REALITYSettings; realitySettings != nil {
+			fields.ServerName = realitySettings.ServerName
+			fields.RealityPublicKey = realitySettings.PublicKey
+			if len(fields.RealityPublicKey) == 0 {
+				fields.RealityPublicKey = realitySettings.Password
+			}
+			fields.RealityShortID = realitySettings.ShortId
+		}
+		if fields.RealityPublicKey == "" {
+			return fmt.Errorf("reality outbound has no public key")
+		}
+	default:
+		return fmt.Errorf("unsupported security for proxy lines: %s", streamSettings.Security)
+	}
+	if fields.Protocol == "shadowsocks" && fields.TLS && fields.Transport.Network != "ws" {
+		return fmt.Errorf("shadowsocks over tls has no proxy line equivalent")
+	}
+	return nil
+}
+
+// proxyLineValue quotes value when it would otherwise break the comma
+// separated grammar. The grammars have no escape for a double quote.
+func proxyLineValue(value string) (string, error) {
+	if strings.ContainsAny(value, "\"\r\n") {
+		return "", fmt.Errorf("proxy line value %q cannot be quoted", value)
+	}
+	if strings.Contains(value, ",") || strings.TrimSpace(value) != value {
+		return `"` + value + `"`, nil
+	}
+	return value, nil
+}
+
+// proxyLineItems builds the items of a line in order, stopping at the
+// first value that cannot be written.
+type proxyLineItems struct {
+	items []string
+	err   error
+}
+
+func (items *proxyLineItems) add(value string) {
+	if items.err != nil {
+		return
+	}
+	value, items.err = proxyLineValue(value)
+	items.items = append(items.items, value)
+}
+
+func (items *proxyLineItems) quoted(value string) {
+	if items.err == nil && strings.ContainsAny(value, "\"\r\n") {
+		items.err = fmt.Errorf("proxy line value %q cannot be quoted", value)
+	}
+	items.items = append(items.items, `"`+value+`"`)
+}
+
+func (items *proxyLineItems) option(key, value string) {
+	if value == "" || items.err != nil {
+		return
+	}
+	value, items.err = proxyLineValue(value)
+	items.items = append(items.items, key+"="+value)
+}
+
+func (items *proxyLineItems) flag(key string, value bool) {
+	if value {
+		items.option(key, "true")
+	}
+}
+
+func (items *proxyLineItems) join(separator string) (string, error) {
+	if items.err != nil {
+		return "", items.err
+	}
+	return strings.Join(items.items, separator), nil
+}
+
+func (fields proxyLineFields) downMbps() string {
+	return strings.TrimSuffix(fields.Down, " mbps")
+}
+
+// surgeLine is the reverse of parseSurgeProxyLine for the Surge grammar.
+func (fields proxyLineFields) surgeLine() (string, error) {
+	if fields.RealityPublicKey != "" {
+		return "", fmt.Errorf("reality has no Surge equivalent")
+	}
+	if fields.Transport.HeaderType != "" {
+		return "", fmt.Errorf("raw http header has no Surge equivalent")
+	}
+	proxyType := map[string]string{
+		"shadowsocks": "ss",
+		"vmess":       "vmess",
+		"trojan":      "trojan",
+		"http":        "http",
+		"socks":       "socks5",
+		"hysteria":    "hysteria2",
+	}[fields.Protocol]
+	if proxyType == "" {
+		return "", fmt.Errorf("%s has no Surge equivalent", fields.Protocol)
+	}
+	if fields.TLS && proxyType == "http" {
+		proxyType = "https"
+	}
+	if fields.TLS && proxyType == "socks5" {
+		proxyType = "socks5-tls"
+	}
+
+	items := &proxyLineItems{}
+	items.items = []string{proxyType}
+	items.add(fields.Server)
+	items.add(strconv.Itoa(int(fields.Port)))
+	switch fields.Protocol {
+	case "shadowsocks":
+		if fields.Transport.Network != "raw" {
+			return "", fmt.Errorf("shadowsocks over %s has no Surge equivalent", fields.Transport.Network)
+		}
+		items.option("encrypt-method", fields.Method)
+		items.option("password", fields.Password)
+		items.option("obfs", fields.Obfs)
+		items.option("obfs-host", fields.Transport.Host)
+		items.option("obfs-uri", fields.Transport.Path)
+	case "vmess":
+		items.option("username", fields.ID)
+		items.flag("vmess-aead", true)
+	case "trojan", "hysteria":
+		items.option("password", fields.Password)
+	case "http", "socks":
+		if fields.Username != "" || fields.Password != "" {
+			items.add(fields.Username)
+			items.add(fields.Password)
+		}
+	}
+	if fields.Protocol == "hysteria" {
+		items.option("download-bandwidth", fields.downMbps())
+	}
+	if fields.Transport.Network == "ws" && fields.Protocol != "shadowsocks" {
+		items.flag("ws", true)
+		items.option("ws-path", fields.Transport.Path)
+		var headers []string
+		if fields.Transport.Host != "" {
+			headers = append(headers, "Host:"+fields.Transport.Host)
+		}
+		for _, name := range slices.Sorted(maps.Keys(fields.Headers)) {
+			headers = append(headers, name+":"+fields.Headers[name])
+		}
+		items.option("ws-headers", strings.Join(headers, "|"))
+	}
+	if fields.TLS {
+		items.flag("tls", fields.Protocol == "vmess")
+		items.option("sni", fields.ServerName)
+		items.flag("skip-cert-verify", fields.Insecure)
+		items.option("server-cert-fingerprint-sha256", fields.PinnedPeerCertSha256)
+	}
+	line, err := items.join(", ")
+	if err != nil {
+		return "", err
+	}
+	return fields.Name + " = " + line, nil
+}
+
+// loonLine is the reverse of parseSurgeProxyLine for the Loon grammar.
+func (fields proxyLineFields) loonLine() (string, error) {
+	if fields.RealityPublicKey != "" && fields.Protocol != "vless" {
+		return "", fmt.Errorf("reality has no Loon equivalent for %s", fields.Protocol)
+	}
+	proxyType := map[string]string{
+		"shadowsocks": "Shadowsocks",
+		"vmess":       "vmess",
+		"vless":       "VLESS",
+		"trojan":      "trojan",
+		"http":        "http",
+		"socks":       "socks5",
+		"hysteria":    "Hysteria2",
+	}[fields.Protocol]
+	if proxyType == "" {
+		return "", fmt.Errorf("%s has no Loon equivalent", fields.Protocol)
+	}
+	if fields.TLS && proxyType == "http" {
+		proxyType = "https"
+	}
+
+	items := &proxyLineItems{}
+	items.items = []string{proxyType}
+	items.add(fields.Server)
+	items.add(strconv.Itoa(int(fields.Port)))
+	switch fields.Protocol {
+	case "shadowsocks":
+		if fields.Transport.Network != "raw" {
+			return "", fmt.Errorf("shadowsocks over %s has no Loon equivalent", fields.Transport.Network)
+		}
+		items.add(fields.Method)
+		items.quoted(fields.Password)
+		items.option("obfs-name", fields.Obfs)
+		items.option("obfs-host", fields.Transport.Host)
+		items.option("obfs-uri", fields.Transport.Path)
+	case "vmess":
+		items.add(firstNonEmpty(fields.Method, "auto"))
+		items.quoted(fields.ID)
+	case "vless":
+		items.quoted(fields.ID)
+	case "trojan", "hysteria":
+		items.quoted(fields.Password)
+	case "http", "socks":
+		if fields.Username != "" || fields.Password != "" {
+			items.add(fields.Username)
+			items.quoted(fields.Password)
+		}
+	}
+	switch fields.Protocol {
+	case "vmess", "vless", "trojan":
+		switch {
+		case fields.Transport.Network == "ws":
+			items.option("transport", "ws")
+		case fields.Transport.HeaderType == "http":
+			items.option("transport", "http")
+		default:
+			items.option("transport", "tcp")
+		}
+		if len(fields.Headers) > 0 {
+			return "", fmt.Errorf("websocket headers have no Loon equivalent")
+		}
+		items.option("path", fields.Transport.Path)
+		items.option("host", fields.Transport.Host)
+	case "hysteria":
+		items.option("download-bandwidth", fields.downMbps())
+	}
+	items.option("flow", fields.Flow)
+	items.option("public-key", fields.RealityPublicKey)
+	items.option("short-id", fields.RealityShortID)
+	if fields.TLS {
+		items.flag("over-tls", fields.Protocol == "vmess" || fields.Protocol == "vless" || fields.Protocol == "socks")
+		items.option("sni", fields.ServerName)
+		items.flag("skip-cert-verify", fields.Insecure)
+	}
+	line, err := items.join(",")
+	if err != nil {
+		return "", err
+	}
+	return fields.Name + " = " + line, nil
+}
+
+// quantumultXLine is the reverse of parseQuantumultXProxyLine.
+func (fields proxyLineFields) quantumultXLine() (string, error) {
+	proxyType := map[string]string{
+		"shadowsocks": "shadowsocks",
+		"vmess":       "vmess",
+		"vless":       "vless",
+		"trojan":      "trojan",
+		"http":        "http",
+		"socks":       "socks5",
+	}[fields.Protocol]
+	if proxyType == "" {
+		return "", fmt.Errorf("%s has no Quantumult X equivalent", fields.Protocol)
+	}
+	if fields.RealityPublicKey != "" && fields.Protocol != "vless" {
+		return "", fmt.Errorf("reality has no Quantumult X equivalent for %s", fields.Protocol)
+	}
+	if len(fields.Headers) > 0 {
+		return "", fmt.Errorf("websocket headers have no Quantumult X equivalent")
+	}
+
+	items := &proxyLineItems{}
+	items.items = []string{proxyType + "=" + net.JoinHostPort(fields.Server, strconv.Itoa(int(fields.Port)))}
+	switch fields.Protocol {
+	case "shadowsocks":
+		items.option("method", fields.Method)
+		items.option("password", fields.Password)
+	case "vmess":
+		// Quantumult X has no "auto"; any AEAD cipher the server accepts works.
+		method := fields.Method
+		if method == "" || method == "auto" {
+			method = "chacha20-poly1305"
+		}
+		items.option("method", method)
+		items.option("password", fields.ID)
+	case "vless":
+		items.option("method", "none")
+		items.option("password", fields.ID)
+	case "trojan":
+		items.option("password", fields.Password)
+	case "http", "socks":
+		items.option("username", fields.Username)
+		items.option("password", fields.Password)
+	}
+
+	var obfs string
+	switch {
+	case fields.Transport.Network == "ws" && fields.TLS:
+		obfs = "wss"
+	case fields.Transport.Network == "ws":
+		obfs = "ws"
+	case fields.Obfs != "":
+		obfs = fields.Obfs
+	case fields.Transport.HeaderType == "http":
+		obfs = "http"
+	case fields.TLS && (fields.Protocol == "vmess" || fields.Protocol == "vless"):
+		obfs = "over-tls"
+	}
+	if obfs == "http" && fields.TLS {
+		return "", fmt.Errorf("http obfs over tls has no Quantumult X equivalent")
+	}
+	items.option("obfs", obfs)
+	if obfs != "" {
+		items.option("obfs-host", fields.Transport.Host)
+		items.option("obfs-uri", fields.Transport.Path)
+	}
+	if fields.TLS {
+		if obfs == "" {
+			items.flag("over-tls", true)
+		}
+		items.option("tls-host", fields.ServerName)
+		if fields.Insecure {
+			items.option("tls-verification", "false")
+		}
+		items.option("tls-cert-sha256", fields.PinnedPeerCertSha256)
+	}
+	items.option("vless-flow", fields.Flow)
+	items.option("reality-base64-pubkey", fields.RealityPublicKey)
+	items.option("reality-hex-shortid", fields.RealityShortID)
+	items.option("tag", fields.Name)
+	return items.join(", ")
+}
@@ -12,9 +12,62 @@ import (
 	"github.com/xtls/xray-core/infra/conf"
 )
 
+type VMessFormat string
+
+const (
+	// VMessFormatURL is vmess://uuid@host:port?..., with the same query as
+	// VLESS links.
+	VMessFormatURL VMessFormat = "url"
+	// VMessFormatV2rayN is the base64 JSON of v2rayN QR codes, for clients
+	// that read no other VMess link.
+	VMessFormatV2rayN VMessFormat = "v2rayn"
+)
+
+// ShareLinkOutputFormat is how the links are wrapped for serving as a
+// subscription.
+type ShareLinkOutputFormat string
+
+const (
+	// ShareLinkOutputPlain is one link per line.
+	ShareLinkOutputPlain     ShareLinkOutputFormat = "plain"
+	ShareLinkOutputBase64    ShareLinkOutputFormat = "base64"
+	ShareLinkOutputBase64URL ShareLinkOutputFormat = "base64url"
+	// ShareLinkOutputAge is an ASCII armored age file encrypted to
+	// AgeRecipients, which ConvertShareLinksToXrayJsonWithAge decrypts.
+	ShareLinkOutputAge ShareLinkOutputFormat = "age"
+)
+
+type ShareLinkOptions struct {
+	// VMessFormat defaults to VMessFormatURL.
+	VMessFormat VMessFormat
+	// OutputFormat defaults to ShareLinkOutputPlain.
+	OutputFormat ShareLinkOutputFormat
+	// AgeRecipients are the X25519 or hybrid public keys of
+	// ShareLinkOutputAge.
+	AgeRecipients []string
+}
+
 // Convert XrayJson to share links.
 // VMess will generate VMessAEAD link.
 func ConvertXrayJsonToShareLinks(xrayBytes []byte) (string, error) {
+	return ConvertXrayJsonToShareLinksWithOptions(xrayBytes, ShareLinkOptions{})
+}
+
+// ConvertXrayJsonToShareLinksWithOptions is ConvertXrayJsonToShareLinks with
+// a choice of link format. With VMessFormatV2rayN, VMess outbounds the QR
+// JSON cannot express are skipped like unsupported protocols.
+func ConvertXrayJsonToShareLinksWithOptions(xrayBytes []byte, options ShareLinkOptions) (string, error) {
+	switch options.VMessFormat {
+	case "", VMessFormatURL, VMessFormatV2rayN:
+	default:
+		return "", fmt.Errorf("unsupported vmess format: %q", options.VMessFormat)
+	}
+	switch options.OutputFormat {
+	case "", ShareLinkOutputPlain, ShareLinkOutputBase64, ShareLinkOutputBase64URL, ShareLinkOutputAge:
+	default:
+		return "", fmt.Errorf("unsupported output format: %q", options.OutputFormat)
+	}
+
 	var xray conf.Config
 	if err := json.Unmarshal(xrayBytes, &xray); err != nil {
 		return "", err
@@ -27,6 +80,12 @@ func ConvertXrayJsonToShareLinks(xrayBytes []byte) (string, error) {
 
 	links := make([]string, 0, len(outbounds))
 	for _, outbound := range outbounds {
+		if outbound.Protocol == "vmess" && options.VMessFormat == VMessFormatV2rayN {
+			if text, err := vmessQrCodeLink(outbound); err == nil {
+				links = append(links, text)
+			}
+			continue
+		}
 		link, err := shareLink(outbound)
 		if err != nil || link == nil {
 			continue
@@ -40,7 +99,20 @@ func ConvertXrayJsonToShareLinks(xrayBytes []byte) (string, error) {
 		return "", fmt.Errorf("no valid outbounds")
 	}
 	shareText := strings.Join(links, "\n")
-	return shareText, nil
+	return encodeShareLinks(shareText, options)
+}
+
+func encodeShareLinks(shareText string, options ShareLinkOptions) (string, error) {
+	switch options.OutputFormat {
+	case ShareLinkOutputBase64:
+		return base64.StdEncoding.EncodeToString([]byte(shareText)), nil
+	case ShareLinkOutputBase64URL:
+		return base64.URLEncoding.EncodeToString([]byte(shareText)), nil
+	case ShareLinkOutputAge:
+		return encryptAgeSubscription(shareText, options.AgeRecipients)
+	default:
+		return shareText, nil
+	}
 }
 
 func shareLink(proxy conf.OutboundDetourConfig) (*url.URL, error) {
@@ -67,6 +139,11 @@ func shareLink(proxy conf.OutboundDetourConfig) (*url.URL, error) {
 		if err != nil {
 			return nil, err
 		}
+	case "http":
+		err := httpLink(proxy, shareUrl)
+		if err != nil {
+			return nil, err
+		}
 	case "trojan":
 		err := trojanLink(proxy, shareUrl)
 		if err != nil {
@@ -77,6 +154,13 @@ func shareLink(proxy conf.OutboundDetourConfig) (*url.URL, error) {
 		if err != nil {
 			return nil, err
 		}
+	case "wireguard":
+		// WireGuard has no transport or security layer to encode.
+		err := wireguardLink(proxy, shareUrl)
+		if err != nil {
+			return nil, err
+		}
+		return shareUrl, nil
 	default:
 		return nil, fmt.Errorf("unsupported outbound protocol %q", proxy.Protocol)
 	}
@@ -202,6 +286,30 @@ func socksLink(proxy conf.OutboundDetourConfig, link *url.URL) error {
 	return nil
 }
 
+// httpLink writes https:// when the proxy itself is reached over TLS.
+func httpLink(proxy conf.OutboundDetourConfig, link *url.URL) error {
+	settings, err := decodeOutboundSettings[conf.HTTPClientConfig](proxy)
+	if err != nil {
+		return err
+	}
+	if settings.Address == nil {
+		return fmt.Errorf("http outbound has no server address")
+	}
+
+	link.Fragment = getOutboundName(proxy)
+	link.Scheme = "http"
+	if proxy.StreamSetting != nil && proxy.StreamSetting.Security == "tls" {
+		link.Scheme = "https"
+	}
+
+	link.Host = fmt.Sprintf("%s:%d", settings.Address, settings.Port)
+	if len(settings.Username) > 0 {
+		link.User = url.UserPassword(settings.Username, settings.Password)
+	}
+
+	return nil
+}
+
 func trojanLink(proxy conf.OutboundDetourConfig, link *url.URL) error {
 	settings, err := decodeOutboundSettings[conf.TrojanClientConfig](proxy)
 	if err != nil {
@@ -280,34 +388,54 @@ func streamSettingsQuery(proxy conf.OutboundDetourConfig, link *url.URL) {
 		}
 
 		// QuicParams (bandwidth + port-hopping)
+		var up, down, ports, obfsType, obfsPassword string
+		var hopInterval *conf.Int32Range
 		if streamSettings.FinalMask != nil && streamSettings.FinalMask.QuicParams != nil {
 			qp := streamSettings.FinalMask.QuicParams
-			if len(qp.BrutalUp) > 0 {
-				query = addQuery(query, "up", string(qp.BrutalUp))
+			up, down = string(qp.BrutalUp), string(qp.BrutalDown)
+			if len(up) > 0 {
+				query = addQuery(query, "up", up)
 			}
-			if len(qp.BrutalDown) > 0 {
-				query = addQuery(query, "down", string(qp.BrutalDown))
+			if len(down) > 0 {
+				query = addQuery(query, "down", down)
 			}
 			if len(qp.UdpHop.PortList.Range) > 0 {
-				query = addQuery(query, "ports", qp.UdpHop.PortList.String())
+				ports = qp.UdpHop.PortList.String()
+				query = addQuery(query, "ports", ports)
 			}
-			if qp.UdpHop.Interval.From != 0 || qp.UdpHop.Interval.To != 0 {
-				query = addQuery(query, "hop-interval", strconv.FormatInt(int64(qp.UdpHop.Interval.From), 10))
+			if interval := qp.UdpHop.Interval; interval.From != 0 || interval.To != 0 {
+				hopInterval = &interval
+				text := strconv.FormatInt(int64(interval.From), 10)
+				if interval.To != interval.From {
+					text += "-" + strconv.FormatInt(int64(interval.To), 10)
+				}
+				query = addQuery(query, "hop-interval", text)
 			}
 		}
 
 		// Salamander
 		if streamSettings.FinalMask != nil && len(streamSettings.FinalMask.Udp) > 0 {
 			mask := streamSettings.FinalMask.Udp[0]
-			if mask.Settings != nil {
+			if mask.Type == "salamander" && mask.Settings != nil {
 				var obfs conf.Salamander
 				err := json.Unmarshal(*mask.Settings, &obfs)
 				if err == nil {
-					query = addQuery(query, "obfs", "salamander")
-					query = addQuery(query, "obfs-password", obfs.Password)
+					obfsType, obfsPassword = "salamander", obfs.Password
+					query = addQuery(query, "obfs", obfsType)
+					query = addQuery(query, "obfs-password", obfsPassword)
 				}
 			}
 		}
+
+		// The parameters above are what other clients read. Anything else
+		// in the mask, such as more UDP masks or QUIC tuning, is kept in fm.
+		if streamSettings.FinalMask != nil {
+			simple, err := buildHy2FinalMask(up, down, ports, hopInterval, obfsType, obfsPassword)
+			fmBytes, fmErr := json.Marshal(streamSettings.FinalMask)
+			if err == nil && fmErr == nil && !jsonEqual(simple, fmBytes) {
+				query = addQuery(query, "fm", string(fmBytes))
+			}
+		}
 		link.RawQuery = query
 		return
 	}
@@ -320,12 +448,13 @@ func streamSettingsQuery(proxy conf.OutboundDetourConfig, link *url.URL) {
 	query = addQuery(query, "security", streamSettings.Security)
 
 	switch network {
-	case "raw":
-		if streamSettings.RAWSettings == nil {
+	case "raw", "tcp":
+		rawSettings := streamRAWSettings(streamSettings)
+		if rawSettings == nil {
 			break
 		}
 
-		headerConfig := streamSettings.RAWSettings.HeaderConfig
+		headerConfig := rawSettings.HeaderConfig
 		if headerConfig == nil {
 			break
 		}
@@ -353,7 +482,7 @@ func streamSettingsQuery(proxy conf.OutboundDetourConfig, link *url.URL) {
 				query = addQuery(query, "host", strings.Join(host, ","))
 			}
 		}
-	case "kcp":
+	case "kcp", "mkcp":
 		if streamSettings.KCPSettings == nil {
 			break
 		}
@@ -376,7 +505,7 @@ func streamSettingsQuery(proxy conf.OutboundDetourConfig, link *url.URL) {
 		if len(headerType) > 0 {
 			query = addQuery(query, "headerType", headerType)
 		}
-	case "ws":
+	case "ws", "websocket":
 		if streamSettings.WSSettings == nil {
 			break
 		}
@@ -388,7 +517,7 @@ func streamSettingsQuery(proxy conf.OutboundDetourConfig, link *url.URL) {
 		if len(host) > 0 {
 			query = addQuery(query, "host", host)
 		}
-	case "grpc":
+	case "grpc", "gun":
 		if streamSettings.GRPCSettings == nil {
 			break
 		}
@@ -418,32 +547,28 @@ func streamSettingsQuery(proxy conf.OutboundDetourConfig, link *url.URL) {
 		if len(path) > 0 {
 			query = addQuery(query, "path", path)
 		}
-	case "xhttp":
-		if streamSettings.XHTTPSettings == nil {
+	case "xhttp", "splithttp":
+		xhttpSettings := streamXHTTPSettings(streamSettings)
+		if xhttpSettings == nil {
 			break
 		}
-		host := streamSettings.XHTTPSettings.Host
+		host := xhttpSettings.Host
 		if len(host) > 0 {
 			query = addQuery(query, "host", host)
 		}
-		path := streamSettings.XHTTPSettings.Path
+		path := xhttpSettings.Path
 		if len(path) > 0 {
 			query = addQuery(query, "path", path)
 		}
-		mode := streamSettings.XHTTPSettings.Mode
+		mode := xhttpSettings.Mode
 		if len(mode) > 0 {
 			query = addQuery(query, "mode", mode)
 		}
-		extra := streamSettings.XHTTPSettings.Extra
-		if extra != nil {
-			var extraConfig conf.SplitHTTPConfig
-			err := json.Unmarshal(extra, &extraConfig)
-			if err == nil {
-				extraBytes, err := json.Marshal(extraConfig)
-				if err == nil {
-					query = addQuery(query, "extra", string(extraBytes))
-				}
-			}
+		// extra is copied as written. Decoding it into SplitHTTPConfig and
+		// encoding it again would write its ranges as objects that Xray
+		// cannot read back.
+		if extra := compactJSON(xhttpSettings.Extra); extra != "" {
+			query = addQuery(query, "extra", extra)
 		}
 	}
 
@@ -491,7 +616,12 @@ func streamSettingsQuery(proxy conf.OutboundDetourConfig, link *url.URL) {
 		if len(sni) > 0 {
 			query = addQuery(query, "sni", sni)
 		}
+		// Password is the client side name of the key; configs written by
+		// hand often use publicKey as on the server.
 		pbk := streamSettings.REALITYSettings.Password
+		if len(pbk) == 0 {
+			pbk = streamSettings.REALITYSettings.PublicKey
+		}
 		if len(pbk) > 0 {
 			query = addQuery(query, "pbk", pbk)
 		}
@@ -520,6 +650,40 @@ func streamSettingsQuery(proxy conf.OutboundDetourConfig, link *url.URL) {
 	link.RawQuery = query
 }
 
+// streamRAWSettings returns rawSettings, or the older tcpSettings spelling.
+// Xray prefers rawSettings when both are set.
+func streamRAWSettings(streamSettings *conf.StreamConfig) *conf.TCPConfig {
+	if streamSettings.RAWSettings != nil {
+		return streamSettings.RAWSettings
+	}
+	return streamSettings.TCPSettings
+}
+
+// streamXHTTPSettings returns xhttpSettings, or the older splithttpSettings
+// spelling.
+func streamXHTTPSettings(streamSettings *conf.StreamConfig) *conf.SplitHTTPConfig {
+	if streamSettings.XHTTPSettings != nil {
+		return streamSettings.XHTTPSettings
+	}
+	return streamSettings.SplitHTTPSettings
+}
+
+// jsonEqual reports whether value encodes to the same JSON as encoded.
+func jsonEqual(value any, encoded []byte) bool {
+	valueBytes, err := json.Marshal(value)
+	return err == nil && bytes.Equal(valueBytes, encoded)
+}
+
+// compactJSON returns raw without insignificant spaces, or "" when raw is
+// empty, null or not JSON.
+func compactJSON(raw json.RawMessage) string {
+	var buffer bytes.Buffer
+	if err := json.Compact(&buffer, raw); err != nil || buffer.String() == "null" {
+		return ""
+	}
+	return buffer.String()
+}
+
 func addQuery(rawQuery string, key, value string) string {
 	v, err := url.ParseQuery(rawQuery)
 	if err != nil {
@@ -0,0 +1,457 @@
+package share
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// SingBoxWarning reports one field, or a whole outbound, that sing-box
+// cannot express. Index is the position in the Xray outbounds.
+type SingBoxWarning struct {
+	Index   int    `json:"index"`
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+}
+
+// singBoxTypes and singBoxTransportTypes invert the importer tables, so both
+// directions agree on every name.
+var (
+	singBoxTypes          = invertStringMap(singBoxProtocols)
+	singBoxTransportTypes = invertStringMap(singBoxTransports)
+)
+
+func invertStringMap(m map[string]string) map[string]string {
+	inverted := make(map[string]string, len(m))
+	for key, value := range m {
+		inverted[value] = key
+	}
+	return inverted
+}
+
+// ConvertXrayJsonToSingBox converts Xray outbounds to sing-box outbounds.
+// Outbounds sing-box cannot express are skipped, and fields it cannot
+// express are dropped; both are reported as warnings.
+func ConvertXrayJsonToSingBox(xrayBytes []byte) (*SingBoxConfig, []SingBoxWarning, error) {
+	var xray conf.Config
+	if err := json.Unmarshal(xrayBytes, &xray); err != nil {
+		return nil, nil, err
+	}
+	if len(xray.OutboundConfigs) == 0 {
+		return nil, nil, fmt.Errorf("no valid outbounds")
+	}
+
+	singBox := &SingBoxConfig{}
+	var warnings []SingBoxWarning
+	tags := make(map[string]int, len(xray.OutboundConfigs))
+	for index, outbound := range xray.OutboundConfigs {
+		tag := getOutboundName(outbound)
+		proxy, notes, err := singBoxOutbound(outbound)
+		for _, note := range notes {
+			warnings = append(warnings, SingBoxWarning{Index: index, Tag: tag, Message: note})
+		}
+		if err != nil {
+			warnings = append(warnings, SingBoxWarning{Index: index, Tag: tag, Message: "skipped: " + err.Error()})
+			continue
+		}
+		proxy.Tag = uniqueOutboundName(tags, tag)
+		singBox.Outbounds = append(singBox.Outbounds, *proxy)
+	}
+	if len(singBox.Outbounds) == 0 {
+		return nil, warnings, fmt.Errorf("no valid outbounds")
+	}
+	return singBox, warnings, nil
+}
+
+// singBoxNotes collects the fields dropped while converting one outbound.
+type singBoxNotes []string
+
+func (notes *singBoxNotes) add(format string, args ...any) {
+	*notes = append(*notes, fmt.Sprintf(format, args...))
+}
+
+func singBoxOutbound(outbound conf.OutboundDetourConfig) (*SingBoxOutbound, []string, error) {
+	var notes singBoxNotes
+	if outbound.Protocol == "wireguard" {
+		return nil, nil, fmt.Errorf("wireguard is a sing-box endpoint, not an outbound")
+	}
+	singBoxType, ok := singBoxTypes[outbound.Protocol]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported outbound protocol %q", outbound.Protocol)
+	}
+	if outbound.MuxSettings != nil && outbound.MuxSettings.Enabled {
+		notes.add("mux has no sing-box equivalent")
+	}
+	if outbound.ProxySettings != nil && len(outbound.ProxySettings.Tag) > 0 {
+		notes.add("proxySettings is not exported")
+	}
+
+	proxy := &SingBoxOutbound{Type: singBoxType}
+	var err error
+	switch outbound.Protocol {
+	case "vless":
+		err = proxy.fromVless(outbound, &notes)
+	case "vmess":
+		err = proxy.fromVmess(outbound, &notes)
+	case "trojan":
+		err = proxy.fromTrojan(outbound, &notes)
+	case "shadowsocks":
+		err = proxy.fromShadowsocks(outbound)
+	case "hysteria":
+		err = proxy.fromHysteria(outbound, &notes)
+	case "socks":
+		err = proxy.fromSocks(outbound, &notes)
+	case "http":
+		err = proxy.fromHTTP(outbound, &notes)
+	}
+	if err != nil {
+		return nil, notes, err
+	}
+	return proxy, notes, nil
+}
+
+func (proxy *SingBoxOutbound) fromVless(outbound conf.OutboundDetourConfig, notes *singBoxNotes) error {
+	settings, err := decodeOutboundSettings[conf.VLessOutboundConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if settings.Encryption != "" && settings.Encryption != "none" {
+		return fmt.Errorf("vless encryption has no sing-box equivalent")
+	}
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.UUID = settings.Id
+	proxy.Flow = settings.Flow
+	// Xray VLESS carries UDP as XUDP.
+	proxy.PacketEncoding = "xudp"
+
+	return proxy.setStreamSettings(outbound.StreamSetting, notes)
+}
+
+func (proxy *SingBoxOutbound) fromVmess(outbound conf.OutboundDetourConfig, notes *singBoxNotes) error {
+	settings, err := decodeOutboundSettings[conf.VMessOutboundConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.UUID = settings.ID
+	proxy.Security = settings.Security
+	if len(settings.Experiments) > 0 {
+		notes.add("vmess experiments %q have no sing-box equivalent", settings.Experiments)
+	}
+
+	return proxy.setStreamSettings(outbound.StreamSetting, notes)
+}
+
+func (proxy *SingBoxOutbound) fromTrojan(outbound conf.OutboundDetourConfig, notes *singBoxNotes) error {
+	settings, err := decodeOutboundSettings[conf.TrojanClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Password = settings.Password
+
+	return proxy.setStreamSettings(outbound.StreamSetting, notes)
+}
+
+func (proxy *SingBoxOutbound) fromShadowsocks(outbound conf.OutboundDetourConfig) error {
+	settings, err := decodeOutboundSettings[conf.ShadowsocksClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Method = settings.Cipher
+	proxy.Password = settings.Password
+
+	proxy.Plugin, proxy.PluginOpts, err = shadowsocksPluginFromStream(outbound.StreamSetting)
+	return err
+}
+
+// fromHysteria is the reverse of SingBoxOutbound.hysteria2StreamSettings.
+func (proxy *SingBoxOutbound) fromHysteria(outbound conf.OutboundDetourConfig, notes *singBoxNotes) error {
+	settings, err := decodeOutboundSettings[conf.HysteriaClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if settings.Version != 2 {
+		return fmt.Errorf("unsupported hysteria version %d", settings.Version)
+	}
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+
+	streamSettings := outbound.StreamSetting
+	if streamSettings == nil {
+		return fmt.Errorf("hysteria2 without tls has no sing-box equivalent")
+	}
+	if streamSettings.HysteriaSettings != nil {
+		proxy.Password = streamSettings.HysteriaSettings.Auth
+	}
+	if finalMask := streamSettings.FinalMask; finalMask != nil {
+		if quicParams := finalMask.QuicParams; quicParams != nil {
+			if proxy.UpMbps, err = bandwidthMbps(quicParams.BrutalUp); err != nil {
+				return err
+			}
+			if proxy.DownMbps, err = bandwidthMbps(quicParams.BrutalDown); err != nil {
+				return err
+			}
+			// sing-box writes port ranges as "2080:3000".
+			for _, portRange := range quicParams.UdpHop.PortList.Range {
+				port := strconv.FormatUint(uint64(portRange.From), 10)
+				if portRange.To != portRange.From {
+					port += ":" + strconv.FormatUint(uint64(portRange.To), 10)
+				}
+				proxy.ServerPorts = append(proxy.ServerPorts, port)
+			}
+			if interval := quicParams.UdpHop.Interval; interval.From > 0 {
+				proxy.HopInterval = fmt.Sprintf("%ds", interval.From)
+				if interval.To != interval.From {
+					notes.add("hysteria hop interval range %d-%d is exported as %ds", interval.From, interval.To, interval.From)
+				}
+			}
+		}
+		for _, mask := range finalMask.Udp {
+			if mask.Type != "salamander" || proxy.Obfs != nil {
+				notes.add("hysteria udp mask %q has no sing-box equivalent", mask.Type)
+				continue
+			}
+			proxy.Obfs = &SingBoxObfs{Type: "salamander"}
+			if mask.Settings != nil {
+				var salamander conf.Salamander
+				if err := json.Unmarshal(*mask.Settings, &salamander); err != nil {
+					return err
+				}
+				proxy.Obfs.Password = salamander.Password
+			}
+		}
+		if len(finalMask.Tcp) > 0 {
+			notes.add("tcp masks have no sing-box equivalent")
+		}
+	}
+
+	if streamSettings.Security != "tls" {
+		return fmt.Errorf("hysteria2 without tls has no sing-box equivalent")
+	}
+	return proxy.setSecurity(streamSettings, notes)
+}
+
+func (proxy *SingBoxOutbound) fromSocks(outbound conf.OutboundDetourConfig, notes *singBoxNotes) error {
+	settings, err := decodeOutboundSettings[conf.SocksClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Version = "5"
+	proxy.Username = settings.Username
+	proxy.Password = settings.Password
+
+	return proxy.setStreamSettings(outbound.StreamSetting, notes)
+}
+
+func (proxy *SingBoxOutbound) fromHTTP(outbound conf.OutboundDetourConfig, notes *singBoxNotes) error {
+	settings, err := decodeOutboundSettings[conf.HTTPClientConfig](outbound)
+	if err != nil {
+		return err
+	}
+	if err := proxy.setServer(settings.Address, settings.Port); err != nil {
+		return err
+	}
+	proxy.Username = settings.Username
+	proxy.Password = settings.Password
+	if len(settings.Headers) > 0 {
+		proxy.Headers = make(map[string]SingBoxListable, len(settings.Headers))
+		for name, value := range settings.Headers {
+			proxy.Headers[name] = SingBoxListable{value}
+		}
+	}
+
+	return proxy.setStreamSettings(outbound.StreamSetting, notes)
+}
+
+func (proxy *SingBoxOutbound) setServer(address *conf.Address, port uint16) error {
+	if address == nil || address.Address == nil {
+		return fmt.Errorf("%s outbound has no server address", proxy.Type)
+	}
+	proxy.Server = address.String()
+	proxy.ServerPort = port
+	return nil
+}
+
+// setStreamSettings is the reverse of SingBoxOutbound.streamSettings.
+func (proxy *SingBoxOutbound) setStreamSettings(streamSettings *conf.StreamConfig, notes *singBoxNotes) error {
+	if streamSettings == nil {
+		return nil
+	}
+
+	network := streamNetwork(streamSettings)
+	switch network {
+	case "raw", "tcp":
+		header, err := rawHTTPHeader(streamSettings)
+		if err != nil {
+			return err
+		}
+		if header != nil {
+			return fmt.Errorf("raw http header has no sing-box equivalent")
+		}
+	case "websocket":
+		network = "ws"
+	case "gun":
+		network = "grpc"
+	}
+
+	if network != "raw" && network != "tcp" {
+		transportType, ok := singBoxTransportTypes[network]
+		if !ok {
+			return fmt.Errorf("unsupported network for sing-box: %s", network)
+		}
+		proxy.Transport = &SingBoxTransport{Type: transportType}
+	}
+	switch network {
+	case "ws":
+		if wsSettings := streamSettings.WSSettings; wsSettings != nil {
+			proxy.Transport.Path, proxy.Transport.MaxEarlyData = splitEarlyDataPath(wsSettings.Path)
+			if proxy.Transport.MaxEarlyData > 0 {
+				proxy.Transport.EarlyDataHeaderName = singBoxEarlyDataHeader
+			}
+			proxy.Transport.Headers = singBoxHeaders(wsSettings.Host, wsSettings.Headers)
+			if wsSettings.HeartbeatPeriod > 0 {
+				notes.add("ws heartbeatPeriod has no sing-box equivalent")
+			}
+		}
+	case "httpupgrade":
+		if httpUpgradeSettings := streamSettings.HTTPUPGRADESettings; httpUpgradeSettings != nil {
+			proxy.Transport.Path = httpUpgradeSettings.Path
+			if len(httpUpgradeSettings.Host) > 0 {
+				proxy.Transport.Host = SingBoxListable{httpUpgradeSettings.Host}
+			}
+			proxy.Transport.Headers = singBoxHeaders("", httpUpgradeSettings.Headers)
+		}
+	case "grpc":
+		if grpcSettings := streamSettings.GRPCSettings; grpcSettings != nil {
+			proxy.Transport.ServiceName = grpcSettings.ServiceName
+			if grpcSettings.MultiMode {
+				notes.add("grpc multiMode has no sing-box equivalent")
+			}
+			if len(grpcSettings.Authority) > 0 {
+				notes.add("grpc authority has no sing-box equivalent")
+			}
+		}
+	}
+
+	return proxy.setSecurity(streamSettings, notes)
+}
+
+// setSecurity is the reverse of SingBoxTLS.parseSecurity.
+func (proxy *SingBoxOutbound) setSecurity(streamSettings *conf.StreamConfig, notes *singBoxNotes) error {
+	switch streamSettings.Security {
+	case "", "none":
+		return nil
+	case "tls":
+		tls := &SingBoxTLS{Enabled: true}
+		if tlsSettings := streamSettings.TLSSettings; tlsSettings != nil {
+			tls.ServerName = tlsSettings.ServerName
+			tls.Insecure = tlsSettings.AllowInsecure
+			if tlsSettings.ALPN != nil {
+				tls.ALPN = SingBoxListable(*tlsSettings.ALPN)
+			}
+			if len(tlsSettings.Fingerprint) > 0 {
+				tls.UTLS = &SingBoxUTLS{Enabled: true, Fingerprint: tlsSettings.Fingerprint}
+			}
+			if len(tlsSettings.ECHConfigList) > 0 {
+				config, err := encodeSingBoxECHConfig(tlsSettings.ECHConfigList)
+				if err != nil {
+					return err
+				}
+				tls.ECH = &SingBoxECH{Enabled: true, Config: config}
+			}
+			if len(tlsSettings.PinnedPeerCertSha256) > 0 {
+				notes.add("tls pinnedPeerCertSha256 has no sing-box equivalent")
+			}
+			if len(tlsSettings.VerifyPeerCertByName) > 0 {
+				notes.add("tls verifyPeerCertByName has no sing-box equivalent")
+			}
+		}
+		proxy.TLS = tls
+	case "reality":
+		tls := &SingBoxTLS{Enabled: true}
+		// sing-box requires uTLS for REALITY and defaults it to chrome.
+		tls.UTLS = &SingBoxUTLS{Enabled: true, Fingerprint: "chrome"}
+		tls.Reality = &SingBoxReality{Enabled: true}
+		if realitySettings := streamSettings.REALITYSettings; realitySettings != nil {
+			tls.ServerName = realitySettings.ServerName
+			if len(realitySettings.Fingerprint) > 0 {
+				tls.UTLS.Fingerprint = realitySettings.Fingerprint
+			}
+			tls.Reality.PublicKey = realitySettings.PublicKey
+			if len(tls.Reality.PublicKey) == 0 {
+				tls.Reality.PublicKey = realitySettings.Password
+			}
+			tls.Reality.ShortID = realitySettings.ShortId
+			if len(realitySettings.SpiderX) > 0 {
+				notes.add("reality spiderX has no sing-box equivalent")
+			}
+			if len(realitySettings.Mldsa65Verify) > 0 {
+				notes.add("reality mldsa65Verify has no sing-box equivalent")
+			}
+		}
+		proxy.TLS = tls
+	default:
+		return fmt.Errorf("unsupported security for sing-box: %s", streamSettings.Security)
+	}
+	return nil
+}
+
+// splitEarlyDataPath removes the "ed" query parameter Xray reads from a
+// WebSocket path and returns it as sing-box max_early_data.
+func splitEarlyDataPath(path string) (string, uint32) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return path, 0
+	}
+	query := u.Query()
+	earlyData, err := strconv.ParseUint(query.Get("ed"), 10, 32)
+	if err != nil {
+		return path, 0
+	}
+	query.Del("ed")
+	u.RawQuery = query.Encode()
+	return u.String(), uint32(earlyData)
+}
+
+// singBoxHeaders folds the Xray host field into the sing-box header map.
+func singBoxHeaders(host string, headers map[string]string) map[string]SingBoxListable {
+	if len(host) == 0 && len(headers) == 0 {
+		return nil
+	}
+	singBoxHeaders := make(map[string]SingBoxListable, len(headers)+1)
+	for name, value := range headers {
+		singBoxHeaders[name] = SingBoxListable{value}
+	}
+	if len(host) > 0 {
+		singBoxHeaders["Host"] = SingBoxListable{host}
+	}
+	return singBoxHeaders
+}
+
+// bandwidthMbps converts an Xray bandwidth to the whole Mbps sing-box
+// expects, using the same binary megabit as conf.Bandwidth.
+func bandwidthMbps(bandwidth conf.Bandwidth) (int, error) {
+	bps, err := bandwidth.Bps()
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(float64(bps) * 8 / (1 << 20))), nil
+}
@@ -2,12 +2,13 @@ package share
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/xtls/xray-core/infra/conf"
 )
 
 // buildHy2FinalMask builds Hysteria2 QUIC hop / bandwidth / salamander mask (shared by URI and Clash).
-func buildHy2FinalMask(up, down, ports string, hopInterval *int32, obfsType, obfsPassword string) (*conf.FinalMask, error) {
+func buildHy2FinalMask(up, down, ports string, hopInterval *conf.Int32Range, obfsType, obfsPassword string) (*conf.FinalMask, error) {
 	var quicParams *conf.QuicParamsConfig
 	if up != "" || down != "" || ports != "" {
 		quicParams = &conf.QuicParamsConfig{}
@@ -32,8 +33,7 @@ func buildHy2FinalMask(up, down, ports string, hopInterval *int32, obfsType, obf
 			}
 			udpHop.PortList = portList
 			if hopInterval != nil {
-				i := *hopInterval
-				udpHop.Interval = conf.Int32Range{Left: i, Right: i, From: i, To: i}
+				udpHop.Interval = *hopInterval
 			}
 			quicParams.UdpHop = udpHop
 		}
@@ -56,3 +56,22 @@ func buildHy2FinalMask(up, down, ports string, hopInterval *int32, obfsType, obf
 	}
 	return &conf.FinalMask{QuicParams: quicParams, Udp: udpMasks}, nil
 }
+
+// hopIntervalSeconds is a fixed port hopping interval.
+func hopIntervalSeconds(seconds int32) *conf.Int32Range {
+	return &conf.Int32Range{Left: seconds, Right: seconds, From: seconds, To: seconds}
+}
+
+// parseHopInterval reads the hop-interval of a share link: seconds, or a
+// "min-max" range of seconds.
+func parseHopInterval(text string) (*conf.Int32Range, error) {
+	textJSON, err := json.Marshal(text)
+	if err != nil {
+		return nil, err
+	}
+	var interval conf.Int32Range
+	if err := json.Unmarshal(textJSON, &interval); err != nil {
+		return nil, fmt.Errorf("invalid hop-interval %q", text)
+	}
+	return &interval, nil
+}
@@ -0,0 +1,167 @@
+package share
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// OutboundFingerprint is a stable identity of the server an outbound
+// reaches: its protocol, address, port and credentials. Names, transports
+// and TLS settings are left out, so renaming a server or moving it to
+// another transport keeps its fingerprint. Outbounds without a server, such
+// as freedom, are identified by protocol and tag.
+func OutboundFingerprint(outbound conf.OutboundDetourConfig) string {
+	identity, _ := outboundIdentity(outbound)
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:16])
+}
+
+// outboundIdentitySettings reads the fields of every proxy protocol that
+// name the server and the account, in the flat form and in the vnext and
+// servers lists.
+type outboundIdentitySettings struct {
+	Address   *conf.Address              `json:"address"`
+	Port      uint16                     `json:"port"`
+	ID        string                     `json:"id"`
+	Password  string                     `json:"password"`
+	Method    string                     `json:"method"`
+	User      string                     `json:"user"`
+	Pass      string                     `json:"pass"`
+	SecretKey string                     `json:"secretKey"`
+	Users     []outboundIdentitySettings `json:"users"`
+	Vnext     []outboundIdentitySettings `json:"vnext"`
+	Servers   []outboundIdentitySettings `json:"servers"`
+	Peers     []struct {
+		Endpoint  string `json:"endpoint"`
+		PublicKey string `json:"publicKey"`
+	} `json:"peers"`
+}
+
+// outboundIdentity also reports whether the outbound names a server.
+func outboundIdentity(outbound conf.OutboundDetourConfig) (string, bool) {
+	var settings outboundIdentitySettings
+	if outbound.Settings != nil {
+		// A partial decode still names the server well enough.
+		_ = json.Unmarshal(*outbound.Settings, &settings)
+	}
+	server := settings
+	switch {
+	case len(settings.Vnext) != 0:
+		server = settings.Vnext[0]
+	case len(settings.Servers) != 0:
+		server = settings.Servers[0]
+	}
+	account := server
+	if len(server.Users) != 0 {
+		account = server.Users[0]
+	}
+
+	address, port := "", strconv.Itoa(int(server.Port))
+	if server.Address != nil && server.Address.Address != nil {
+		address = server.Address.String()
+	}
+	parts := []string{account.ID, account.Password, account.Method, account.User, account.Pass, settings.SecretKey}
+	if len(settings.Peers) != 0 {
+		if host, peerPort, err := net.SplitHostPort(settings.Peers[0].Endpoint); err == nil {
+			address, port = host, peerPort
+		}
+		parts = append(parts, settings.Peers[0].PublicKey)
+	}
+	if outbound.StreamSetting != nil && outbound.StreamSetting.HysteriaSettings != nil {
+		parts = append(parts, outbound.StreamSetting.HysteriaSettings.Auth)
+	}
+	if address == "" {
+		return outbound.Protocol + "\x00" + outbound.Tag, false
+	}
+	return strings.Join(append([]string{outbound.Protocol, strings.ToLower(address), port}, parts...), "\x00"), true
+}
+
+// OutboundDiff compares two conversions of one subscription. Outbounds are
+// matched by OutboundFingerprint; several outbounds with one fingerprint
+// are matched in order. Keys holds the key of every current outbound, for
+// the UI to attach its own state to.
+type OutboundDiff struct {
+	Keys      []string
+	Added     []OutboundChange
+	Removed   []OutboundChange
+	Changed   []OutboundChange
+	Unchanged int
+}
+
+// OutboundChange names one outbound of a diff. Index is its position in the
+// current outbounds and PreviousIndex in the previous ones, -1 where it is
+// absent. Key is the fingerprint, with "-2", "-3"... for repeats.
+type OutboundChange struct {
+	Key           string
+	Index         int
+	PreviousIndex int
+	Name          string
+}
+
+// DiffOutbounds reports which outbounds of current were added, removed or
+// changed since previous. An outbound changed when anything besides its
+// fingerprint did, its name or transport for example.
+func DiffOutbounds(previous, current []conf.OutboundDetourConfig) *OutboundDiff {
+	previousKeys := outboundKeys(previous)
+	previousIndex := make(map[string]int, len(previous))
+	for index, key := range previousKeys {
+		previousIndex[key] = index
+	}
+
+	diff := &OutboundDiff{Keys: outboundKeys(current)}
+	seen := make(map[string]bool, len(current))
+	for index, key := range diff.Keys {
+		seen[key] = true
+		change := OutboundChange{Key: key, Index: index, PreviousIndex: -1, Name: getOutboundName(current[index])}
+		old, found := previousIndex[key]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, change)
+		case !outboundsEqual(previous[old], current[index]):
+			change.PreviousIndex = old
+			diff.Changed = append(diff.Changed, change)
+		default:
+			diff.Unchanged++
+		}
+	}
+	for index, key := range previousKeys {
+		if !seen[key] {
+			diff.Removed = append(diff.Removed, OutboundChange{
+				Key:           key,
+				Index:         -1,
+				PreviousIndex: index,
+				Name:          getOutboundName(previous[index]),
+			})
+		}
+	}
+	return diff
+}
+
+func outboundKeys(outbounds []conf.OutboundDetourConfig) []string {
+	keys := make([]string, len(outbounds))
+	count := make(map[string]int, len(outbounds))
+	for index, outbound := range outbounds {
+		fingerprint := OutboundFingerprint(outbound)
+		count[fingerprint]++
+		keys[index] = fingerprint
+		if count[fingerprint] > 1 {
+			keys[index] += "-" + strconv.Itoa(count[fingerprint])
+		}
+	}
+	return keys
+}
+
+func outboundsEqual(a, b conf.OutboundDetourConfig) bool {
+	// Marshal through pointers so that Int32Range fields keep their string
+	// form.
+	encodedA, errA := json.Marshal(&a)
+	encodedB, errB := json.Marshal(&b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
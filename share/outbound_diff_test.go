@@ -0,0 +1,81 @@
+package share
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func outboundsForTest(t *testing.T, outbounds string) []conf.OutboundDetourConfig {
+	t.Helper()
+	var config conf.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"outbounds":`+outbounds+`}`), &config))
+	return config.OutboundConfigs
+}
+
+func TestOutboundFingerprint(t *testing.T) {
+	outbounds := outboundsForTest(t, `[
+		{"protocol":"vless","sendThrough":"A","settings":{"address":"Example.com","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","encryption":"none"}},
+		{"protocol":"vless","sendThrough":"A renamed","settings":{"address":"example.com","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","encryption":"none"},
+		 "streamSettings":{"network":"ws","security":"tls"}},
+		{"protocol":"vless","settings":{"vnext":[{"address":"example.com","port":443,"users":[{"id":"b831381d-6324-4d53-ad4f-8cda48b30811"}]}]}},
+		{"protocol":"vless","settings":{"address":"example.com","port":8443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811"}},
+		{"protocol":"vless","settings":{"address":"example.com","port":443,"id":"2418d087-648d-4990-86e8-19dca1d006d3"}},
+		{"protocol":"trojan","settings":{"address":"example.com","port":443,"password":"b831381d-6324-4d53-ad4f-8cda48b30811"}},
+		{"protocol":"hysteria","settings":{"version":2,"address":"example.com","port":443},"streamSettings":{"network":"hysteria","hysteriaSettings":{"version":2,"auth":"one"}}},
+		{"protocol":"hysteria","settings":{"version":2,"address":"example.com","port":443},"streamSettings":{"network":"hysteria","hysteriaSettings":{"version":2,"auth":"two"}}},
+		{"protocol":"wireguard","settings":{"secretKey":"c2VjcmV0","peers":[{"endpoint":"wg.example:51820","publicKey":"cGVlcg=="}]}},
+		{"protocol":"freedom","tag":"direct"},
+		{"protocol":"freedom","tag":"other"}
+	]`)
+	fingerprints := make([]string, len(outbounds))
+	for index, outbound := range outbounds {
+		fingerprints[index] = OutboundFingerprint(outbound)
+		assert.Len(t, fingerprints[index], 32)
+	}
+
+	// Name, case of the host, transport and settings form do not matter.
+	assert.Equal(t, fingerprints[0], fingerprints[1])
+	assert.Equal(t, fingerprints[0], fingerprints[2])
+	// Port, credentials and protocol do.
+	for index := 3; index < len(fingerprints); index++ {
+		for other := 0; other < index; other++ {
+			if other <= 2 && index <= 2 {
+				continue
+			}
+			assert.NotEqual(t, fingerprints[other], fingerprints[index], "%d and %d", other, index)
+		}
+	}
+}
+
+func TestDiffOutbounds(t *testing.T) {
+	previous := outboundsForTest(t, `[
+		{"protocol":"trojan","sendThrough":"A","settings":{"address":"a.example","port":443,"password":"a"}},
+		{"protocol":"trojan","sendThrough":"B","settings":{"address":"b.example","port":443,"password":"b"}},
+		{"protocol":"trojan","sendThrough":"C","settings":{"address":"c.example","port":443,"password":"c"}},
+		{"protocol":"trojan","sendThrough":"C copy","settings":{"address":"c.example","port":443,"password":"c"}}
+	]`)
+	current := outboundsForTest(t, `[
+		{"protocol":"trojan","sendThrough":"C","settings":{"address":"c.example","port":443,"password":"c"}},
+		{"protocol":"trojan","sendThrough":"A renamed","settings":{"address":"a.example","port":443,"password":"a"}},
+		{"protocol":"trojan","sendThrough":"D","settings":{"address":"d.example","port":443,"password":"d"}}
+	]`)
+
+	diff := DiffOutbounds(previous, current)
+	keyA, keyB, keyC, keyD := OutboundFingerprint(previous[0]), OutboundFingerprint(previous[1]), OutboundFingerprint(previous[2]), OutboundFingerprint(current[2])
+	assert.Equal(t, []string{keyC, keyA, keyD}, diff.Keys)
+	assert.Equal(t, []OutboundChange{{Key: keyD, Index: 2, PreviousIndex: -1, Name: "D"}}, diff.Added)
+	assert.Equal(t, []OutboundChange{{Key: keyA, Index: 1, PreviousIndex: 0, Name: "A renamed"}}, diff.Changed)
+	assert.Equal(t, []OutboundChange{
+		{Key: keyB, Index: -1, PreviousIndex: 1, Name: "B"},
+		{Key: keyC + "-2", Index: -1, PreviousIndex: 3, Name: "C copy"},
+	}, diff.Removed)
+	assert.Equal(t, 1, diff.Unchanged)
+
+	everything := DiffOutbounds(nil, current)
+	assert.Len(t, everything.Added, 3)
+	assert.Empty(t, everything.Removed)
+}
@@ -3,10 +3,12 @@ package share
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/xtls/xray-core/infra/conf"
 )
@@ -45,36 +47,67 @@ func decodeBase64Text(text string) (string, error) {
 
 // ConvertShareLinksToXrayJson parses:
 //   - a single Xray JSON object (starts with '{')
-//   - plain v2rayN-style lines (vless/vmess/ss/socks/trojan/hy2…)
+//   - a sing-box JSON config (outbounds with "type" instead of "protocol")
+//   - a SIP008 shadowsocks JSON document ("servers" instead of "outbounds")
+//   - plain v2rayN-style lines (vless/vmess/ss/socks/http/trojan/hy2/wireguard…)
+//   - a wg-quick WireGuard configuration ([Interface] and [Peer])
 //   - one base64 blob that decodes to Xray JSON, share lines, or Clash YAML
 //   - Clash / Clash.Meta YAML (proxies:)
+//   - Surge, Loon, Shadowrocket or Quantumult X proxy lines, alone or in a
+//     full profile
+//
+// ssconf:// keys need a fetch; see ConvertShareLinksToXrayJsonWithClient.
 func ConvertShareLinksToXrayJson(links string) (*conf.Config, error) {
-	config, err := convertShareLinksToXrayJson(links, true)
+	return convertShareLinks(links, nil)
+}
+
+func convertShareLinks(links string, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	config, err := convertShareLinksToXrayJson(links, true, diagnostics)
 	if err != nil {
 		return nil, err
 	}
-	return filterBuildableOutbounds(config)
+	return filterBuildableOutbounds(config, diagnostics)
 }
 
-func convertShareLinksToXrayJson(links string, allowBase64 bool) (*conf.Config, error) {
-	text := strings.TrimSpace(FixWindowsReturn(links))
+func convertShareLinksToXrayJson(links string, allowBase64 bool, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	text := FixWindowsReturn(links)
+	if diagnostics != nil {
+		leading := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+		diagnostics.lineOffset = strings.Count(text[:leading], "\n")
+	}
+	text = strings.TrimSpace(text)
 	if text == "" {
 		return nil, fmt.Errorf("unsupported share format")
 	}
 	if strings.HasPrefix(text, "{") {
+		if isSingBoxConfig(text) {
+			return tryToParseSingBoxJSON(text, diagnostics)
+		}
+		if isSIP008Config(text) {
+			return tryToParseSIP008JSON(text, diagnostics)
+		}
 		return parseXrayJSONConfig(text)
 	}
 	if hasShareSchemeLine(text) {
-		return parsePlainShareLines(text)
+		return parsePlainShareLines(text, diagnostics)
+	}
+	if HasSSConfLink(text) {
+		return nil, ErrSSConfNotFetched
+	}
+	if hasWireGuardINI(text) {
+		return parseWireGuardINI(text)
 	}
 	if allowBase64 {
 		decoded, err := decodeBase64Text(text)
 		if err == nil {
-			return convertShareLinksToXrayJson(decoded, false)
+			return convertShareLinksToXrayJson(decoded, false, diagnostics)
 		}
 	}
 	if hasTopLevelClashProxiesKey(text) {
-		return tryToParseClashYaml(text)
+		return tryToParseClashYaml(text, diagnostics)
+	}
+	if hasProxyLine(text) {
+		return parseProxyLines(text, diagnostics)
 	}
 	return nil, fmt.Errorf("unsupported share format")
 }
@@ -92,7 +125,7 @@ func parseXrayJSONConfig(text string) (*conf.Config, error) {
 
 var shareSchemes = []string{
 	"vless://", "vmess://", "socks://", "ss://", "trojan://",
-	"hysteria2://", "hy2://",
+	"hysteria2://", "hy2://", "wireguard://", "wg://",
 }
 
 func hasShareSchemeLine(text string) bool {
@@ -105,11 +138,29 @@ func hasShareSchemeLine(text string) bool {
 				return false
 			}
 		}
+		if isHTTPProxyLink(line) {
+			found = true
+			return false
+		}
 		return true
 	})
 	return found
 }
 
+// isHTTPProxyLink tells an http(s) proxy link from the web and subscription
+// URLs that profiles also carry, such as a Quantumult X [server_remote]
+// line: a proxy link has credentials or a port, and no path.
+func isHTTPProxyLink(line string) bool {
+	if !strings.HasPrefix(line, "http://") && !strings.HasPrefix(line, "https://") {
+		return false
+	}
+	u, err := url.Parse(line)
+	if err != nil || u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return false
+	}
+	return u.User != nil || u.Port() != ""
+}
+
 func hasTopLevelClashProxiesKey(text string) bool {
 	found := false
 	forEachLine(text, func(raw string) bool {
@@ -140,26 +191,38 @@ func forEachLine(text string, visit func(string) bool) {
 	}
 }
 
-func parsePlainShareLines(text string) (*conf.Config, error) {
+func parsePlainShareLines(text string, diagnostics *shareDiagnostics) (*conf.Config, error) {
 	outbounds := make([]conf.OutboundDetourConfig, 0)
+	var skipped []error
+	lineNumber := 0
 	forEachLine(text, func(raw string) bool {
+		lineNumber++
 		line := strings.TrimSpace(raw)
 		if line == "" {
 			return true
 		}
+		origin := diagnostics.lineOrigin(lineNumber, redactShareLink(line))
 		u, err := url.Parse(line)
 		if err != nil {
-			return true
-		}
-		sl := xrayShareLink{link: u, rawText: line}
-		ob, err := sl.outbound()
-		if err != nil {
-			return true
+			// url.Error repeats the whole line, credentials included.
+			err = fmt.Errorf("invalid share link")
+		} else {
+			sl := xrayShareLink{link: u, rawText: line}
+			var ob *conf.OutboundDetourConfig
+			if ob, err = sl.outbound(); err == nil {
+				outbounds = append(outbounds, *ob)
+				diagnostics.keep(origin)
+				return true
+			}
 		}
-		outbounds = append(outbounds, *ob)
+		skipped = append(skipped, fmt.Errorf("line %d: %w", origin.Line, err))
+		diagnostics.drop(origin, ShareDiagnosticStageParse, err)
 		return true
 	})
 	if len(outbounds) == 0 {
+		if len(skipped) > 0 {
+			return nil, fmt.Errorf("no valid outbound found: %w", errors.Join(skipped...))
+		}
 		return nil, fmt.Errorf("no valid outbound found")
 	}
 	return &conf.Config{OutboundConfigs: outbounds}, nil
@@ -184,8 +247,12 @@ func (proxy xrayShareLink) outbound() (*conf.OutboundDetourConfig, error) {
 		return proxy.trojanOutbound()
 	case "hysteria2", "hy2":
 		return proxy.hysteriaOutbound()
+	case "wireguard", "wg":
+		return proxy.wireguardOutbound()
+	case "http", "https":
+		return proxy.httpOutbound()
 	default:
-		return nil, fmt.Errorf("unsupported link: %s", proxy.rawText)
+		return nil, fmt.Errorf("unsupported link scheme: %s", proxy.link.Scheme)
 	}
 }
 
@@ -215,6 +282,15 @@ func (proxy xrayShareLink) shadowsocksOutbound() (*conf.OutboundDetourConfig, er
 	}
 	outbound.Settings = &settingsRawMessage
 
+	if plugin := proxy.link.Query().Get("plugin"); plugin != "" {
+		streamSettings, err := shadowsocksPluginStreamSettings(plugin)
+		if err != nil {
+			return nil, err
+		}
+		outbound.StreamSetting = streamSettings
+		return outbound, nil
+	}
+
 	streamSettings, err := proxy.streamSettings(proxy.link)
 	if err != nil {
 		return nil, err
@@ -353,7 +429,7 @@ func (proxy xrayShareLink) socksOutbound() (*conf.OutboundDetourConfig, error) {
 		}
 		pwConfig := strings.SplitN(passwordText, ":", 2)
 		if len(pwConfig) != 2 {
-			return nil, fmt.Errorf("unsupported socks link user:password: %s", passwordText)
+			return nil, fmt.Errorf("unsupported socks link user info")
 		}
 		settings.Username = pwConfig[0]
 		settings.Password = pwConfig[1]
@@ -373,6 +449,68 @@ func (proxy xrayShareLink) socksOutbound() (*conf.OutboundDetourConfig, error) {
 	return outbound, nil
 }
 
+// httpOutbound parses an HTTP proxy URL. https:// means TLS to the proxy
+// itself, and the port defaults to that of the scheme. A path is rejected
+// so ordinary web URLs are not taken as proxies.
+func (proxy xrayShareLink) httpOutbound() (*conf.OutboundDetourConfig, error) {
+	if proxy.link.Path != "" && proxy.link.Path != "/" {
+		return nil, fmt.Errorf("unsupported http proxy link path")
+	}
+
+	outbound := &conf.OutboundDetourConfig{}
+	outbound.Protocol = "http"
+	setOutboundName(outbound, proxy.link.Fragment)
+
+	settings := &conf.HTTPClientConfig{}
+	settings.Address = parseAddress(proxy.link.Hostname())
+	settings.Port = 80
+	if proxy.link.Scheme == "https" {
+		settings.Port = 443
+	}
+	if portText := proxy.link.Port(); portText != "" {
+		port, err := strconv.ParseUint(portText, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid http proxy link port")
+		}
+		settings.Port = uint16(port)
+	}
+
+	if proxy.link.User != nil {
+		username := proxy.link.User.Username()
+		password, hasPassword := proxy.link.User.Password()
+		if !hasPassword {
+			// v2rayN style: base64 of user:pass, as in socks links.
+			if decoded, err := decodeBase64Text(username); err == nil {
+				if user, pass, ok := strings.Cut(decoded, ":"); ok {
+					username, password = user, pass
+				}
+			}
+		}
+		settings.Username = username
+		settings.Password = password
+	}
+
+	settingsRawMessage, err := convertJsonToRawMessage(settings)
+	if err != nil {
+		return nil, err
+	}
+	outbound.Settings = &settingsRawMessage
+
+	streamSettings, err := proxy.streamSettings(proxy.link)
+	if err != nil {
+		return nil, err
+	}
+	if streamSettings == nil && proxy.link.Scheme == "https" {
+		streamSettings = &conf.StreamConfig{}
+		streamSettings.Network = new(conf.TransportProtocol("raw"))
+		if err := proxy.parseSecurityFromURL(proxy.link, streamSettings); err != nil {
+			return nil, err
+		}
+	}
+	outbound.StreamSetting = streamSettings
+	return outbound, nil
+}
+
 func (proxy xrayShareLink) trojanOutbound() (*conf.OutboundDetourConfig, error) {
 	outbound := &conf.OutboundDetourConfig{}
 	outbound.Protocol = "trojan"
@@ -439,13 +577,13 @@ func (proxy xrayShareLink) hysteriaOutbound() (*conf.OutboundDetourConfig, error
 	streamSettings.HysteriaSettings = hysteriaSettings
 
 	query := proxy.link.Query()
-	var hopPtr *int32
+	var hopPtr *conf.Int32Range
 	if hopStr := query.Get("hop-interval"); hopStr != "" {
-		interval, pErr := strconv.ParseInt(hopStr, 10, 32)
+		interval, pErr := parseHopInterval(hopStr)
 		if pErr != nil {
 			return nil, pErr
 		}
-		hopPtr = new(int32(interval))
+		hopPtr = interval
 	}
 	finalMask, mErr := buildHy2FinalMask(
 		query.Get("up"), query.Get("down"), query.Get("ports"),
@@ -454,6 +592,13 @@ func (proxy xrayShareLink) hysteriaOutbound() (*conf.OutboundDetourConfig, error
 	if mErr != nil {
 		return nil, mErr
 	}
+	// fm is the complete mask when the parameters above cannot express it.
+	if fm := query.Get("fm"); fm != "" {
+		finalMask = nil
+		if err := json.Unmarshal([]byte(fm), &finalMask); err != nil {
+			return nil, err
+		}
+	}
 	streamSettings.FinalMask = finalMask
 
 	if err := proxy.parseSecurityFromURL(proxy.link, streamSettings); err != nil {
@@ -0,0 +1,607 @@
+package share
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// Proxy lines are the one-line proxy definitions of iOS clients:
+//
+//	Surge, Shadowrocket: Name = vmess, host, 443, username=uuid, ws=true, tls=true
+//	Loon:                Name = vmess, host, 443, auto, "uuid", transport=ws, over-tls=true
+//	Quantumult X:        vmess=host:443, method=none, password=uuid, obfs=wss, tag=Name
+//
+// https://manual.nssurge.com/policy/proxy.html
+// https://nsloon.app/docs/Node/
+// https://github.com/crossutility/Quantumult-X/blob/master/sample.conf
+
+// surgeProxyTypes maps Surge and Loon proxy types to Xray protocols. Types
+// mapped to "" are recognized but have no Xray equivalent.
+var surgeProxyTypes = map[string]string{
+	"ss":          "shadowsocks",
+	"shadowsocks": "shadowsocks",
+	"vmess":       "vmess",
+	"vless":       "vless",
+	"trojan":      "trojan",
+	"http":        "http",
+	"https":       "http",
+	"socks5":      "socks",
+	"socks5-tls":  "socks",
+	"hysteria2":   "hysteria",
+
+	"snell":        "",
+	"tuic":         "",
+	"tuic-v5":      "",
+	"wireguard":    "",
+	"ssr":          "",
+	"shadowsocksr": "",
+}
+
+// quantumultXProxyTypes maps Quantumult X server keys to Xray protocols.
+var quantumultXProxyTypes = map[string]string{
+	"shadowsocks": "shadowsocks",
+	"vmess":       "vmess",
+	"vless":       "vless",
+	"trojan":      "trojan",
+	"http":        "http",
+	"socks5":      "socks",
+}
+
+// proxyLineFields is the normalized content of one proxy line, whatever the
+// client grammar.
+type proxyLineFields struct {
+	Name     string
+	Protocol string
+	Server   string
+	Port     uint16
+
+	Method   string // shadowsocks cipher or VMess security
+	ID       string // VMess and VLESS
+	Flow     string
+	Username string
+	Password string
+	Down     string // hysteria2 download bandwidth, "N mbps"
+
+	Transport shareTransportFields
+	Headers   map[string]string // WebSocket headers other than Host
+	Obfs      string            // shadowsocks simple-obfs mode
+
+	TLS                  bool
+	ServerName           string
+	Insecure             bool
+	PinnedPeerCertSha256 string
+	RealityPublicKey     string
+	RealityShortID       string
+}
+
+// proxyLineItem is one comma separated item. Key is empty for positional
+// items.
+type proxyLineItem struct {
+	Key   string
+	Value string
+}
+
+// splitProxyLine splits a line at commas outside double quotes, and each
+// item at its first '='. Quotes around a value are removed.
+func splitProxyLine(line string) []proxyLineItem {
+	var parts []string
+	var part strings.Builder
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			part.WriteRune(r)
+		case r == ',' && !quoted:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	parts = append(parts, part.String())
+
+	items := make([]proxyLineItem, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		var item proxyLineItem
+		if key, value, ok := strings.Cut(part, "="); ok && !strings.HasPrefix(part, `"`) {
+			item.Key = strings.ToLower(strings.TrimSpace(key))
+			item.Value = unquoteProxyLineValue(strings.TrimSpace(value))
+		} else {
+			item.Value = unquoteProxyLineValue(part)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func unquoteProxyLineValue(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// isSurgeProxyLine reports whether line reads "Name = type, server, port".
+func isSurgeProxyLine(line string) bool {
+	name, rest, ok := strings.Cut(line, "=")
+	if !ok || strings.TrimSpace(name) == "" || strings.Contains(name, ",") {
+		return false
+	}
+	proxyType, _, ok := strings.Cut(rest, ",")
+	if !ok {
+		return false
+	}
+	_, known := surgeProxyTypes[strings.ToLower(strings.TrimSpace(proxyType))]
+	return known
+}
+
+// isQuantumultXProxyLine reports whether line reads "type=server:port, ...".
+func isQuantumultXProxyLine(line string) bool {
+	first, _, ok := strings.Cut(line, ",")
+	if !ok {
+		return false
+	}
+	key, value, ok := strings.Cut(first, "=")
+	if !ok {
+		return false
+	}
+	if _, known := quantumultXProxyTypes[strings.ToLower(strings.TrimSpace(key))]; !known {
+		return false
+	}
+	_, _, err := net.SplitHostPort(strings.TrimSpace(value))
+	return err == nil
+}
+
+func isProxyLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return isQuantumultXProxyLine(line) || isSurgeProxyLine(line)
+}
+
+func hasProxyLine(text string) bool {
+	found := false
+	forEachLine(text, func(raw string) bool {
+		if isProxyLine(raw) {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// parseProxyLines reads every Surge, Loon, Shadowrocket or Quantumult X
+// proxy line of text. Section headers, comments and other settings of a
+// full client profile are ignored.
+func parseProxyLines(text string, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	var outbounds []conf.OutboundDetourConfig
+	var skipped []error
+	lineNumber := 0
+	forEachLine(text, func(raw string) bool {
+		lineNumber++
+		line := strings.TrimSpace(raw)
+		if !isProxyLine(line) {
+			return true
+		}
+		origin := diagnostics.lineOrigin(lineNumber, redactProxyLine(line))
+		outbound, err := parseProxyLine(line)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("line %d: %w", origin.Line, err))
+			diagnostics.drop(origin, ShareDiagnosticStageParse, err)
+			return true
+		}
+		outbounds = append(outbounds, *outbound)
+		diagnostics.keep(origin)
+		return true
+	})
+	if len(outbounds) == 0 {
+		if len(skipped) > 0 {
+			return nil, fmt.Errorf("no valid outbound found: %w", errors.Join(skipped...))
+		}
+		return nil, fmt.Errorf("no valid outbound found")
+	}
+	return &conf.Config{OutboundConfigs: outbounds}, nil
+}
+
+func parseProxyLine(line string) (*conf.OutboundDetourConfig, error) {
+	var fields *proxyLineFields
+	var err error
+	if isQuantumultXProxyLine(line) {
+		fields, err = parseQuantumultXProxyLine(line)
+	} else {
+		fields, err = parseSurgeProxyLine(line)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fields.outbound()
+}
+
+// parseSurgeProxyLine reads the Surge grammar, which Shadowrocket shares,
+// and the Loon grammar. Both start with "Name = type, server, port"; Loon
+// passes credentials as positional items where Surge uses keys.
+func parseSurgeProxyLine(line string) (*proxyLineFields, error) {
+	name, rest, _ := strings.Cut(line, "=")
+	items := splitProxyLine(rest)
+	if len(items) < 3 {
+		return nil, fmt.Errorf("proxy line needs a type, server and port")
+	}
+	proxyType := strings.ToLower(items[0].Value)
+	fields := &proxyLineFields{Name: strings.TrimSpace(name)}
+	fields.Protocol = surgeProxyTypes[proxyType]
+	if fields.Protocol == "" {
+		return nil, fmt.Errorf("unsupported proxy type: %s", items[0].Value)
+	}
+	fields.Server = items[1].Value
+	port, err := strconv.ParseUint(items[2].Value, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy port %q", items[2].Value)
+	}
+	fields.Port = uint16(port)
+
+	var positional []string
+	options := make(map[string]string)
+	for _, item := range items[3:] {
+		if item.Key == "" {
+			positional = append(positional, item.Value)
+		} else {
+			options[item.Key] = item.Value
+		}
+	}
+	for _, key := range []string{"underlying-proxy", "shadow-tls-password", "client-cert"} {
+		if _, ok := options[key]; ok {
+			return nil, fmt.Errorf("proxy option %s has no Xray equivalent", key)
+		}
+	}
+	positionalAt := func(index int) string {
+		if index < len(positional) {
+			return positional[index]
+		}
+		return ""
+	}
+	option := func(keys ...string) string {
+		for _, key := range keys {
+			if value := options[key]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	switch fields.Protocol {
+	case "shadowsocks":
+		fields.Method = firstNonEmpty(option("encrypt-method"), positionalAt(0))
+		fields.Password = firstNonEmpty(option("password"), positionalAt(1))
+		fields.Obfs = option("obfs", "obfs-name")
+		fields.Transport.Host = option("obfs-host")
+		fields.Transport.Path = option("obfs-uri")
+	case "vmess":
+		// Loon: vmess, server, port, security, "uuid"
+		fields.Method = firstNonEmpty(option("encrypt-method"), positionalAt(0))
+		fields.ID = firstNonEmpty(option("username"), positionalAt(1))
+	case "vless":
+		fields.ID = firstNonEmpty(option("username"), positionalAt(0))
+		fields.Flow = option("flow")
+		fields.RealityPublicKey = option("public-key")
+		fields.RealityShortID = option("short-id")
+	case "trojan", "hysteria":
+		fields.Password = firstNonEmpty(option("password"), positionalAt(0))
+		fields.TLS = true
+	case "http", "socks":
+		fields.Username = firstNonEmpty(option("username"), positionalAt(0))
+		fields.Password = firstNonEmpty(option("password"), positionalAt(1))
+	}
+	if proxyType == "https" || proxyType == "socks5-tls" {
+		fields.TLS = true
+	}
+	if fields.Protocol == "hysteria" {
+		if down := option("download-bandwidth"); down != "" {
+			fields.Down = down + " mbps"
+		}
+	}
+
+	// Surge: ws=true, ws-path, ws-headers. Loon: transport=ws, path, host.
+	fields.Transport.Network = "raw"
+	if fields.Protocol != "shadowsocks" {
+		switch transport := strings.ToLower(option("transport")); {
+		case isTrue(options["ws"]) || transport == "ws":
+			fields.Transport.Network = "ws"
+			fields.Transport.Path = option("ws-path", "path")
+			fields.Transport.Host = option("host")
+			headers, err := parseSurgeWSHeaders(options["ws-headers"])
+			if err != nil {
+				return nil, err
+			}
+			if host, ok := headers["Host"]; ok {
+				fields.Transport.Host = host
+				delete(headers, "Host")
+			}
+			if len(headers) > 0 {
+				fields.Headers = headers
+			}
+		case transport == "http":
+			fields.Transport.HeaderType = "http"
+			fields.Transport.Path = option("path")
+			fields.Transport.Host = option("host")
+		case transport == "" || transport == "tcp":
+		default:
+			return nil, fmt.Errorf("unsupported proxy transport: %s", transport)
+		}
+	}
+
+	if isTrue(options["tls"]) || isTrue(options["over-tls"]) {
+		fields.TLS = true
+	}
+	fields.ServerName = option("sni", "tls-name")
+	fields.Insecure = isTrue(options["skip-cert-verify"])
+	fields.PinnedPeerCertSha256 = option("server-cert-fingerprint-sha256")
+	return fields, nil
+}
+
+// parseSurgeWSHeaders reads ws-headers=Host:example.com|User-Agent:"a b".
+func parseSurgeWSHeaders(text string) (map[string]string, error) {
+	headers := make(map[string]string)
+	if text == "" {
+		return headers, nil
+	}
+	for _, header := range strings.Split(text, "|") {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid ws-headers entry")
+		}
+		name = strings.TrimSpace(name)
+		if strings.EqualFold(name, "Host") {
+			name = "Host"
+		}
+		headers[name] = unquoteProxyLineValue(strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+func parseQuantumultXProxyLine(line string) (*proxyLineFields, error) {
+	items := splitProxyLine(line)
+	fields := &proxyLineFields{}
+	fields.Protocol = quantumultXProxyTypes[items[0].Key]
+	host, port, err := net.SplitHostPort(items[0].Value)
+	if err != nil {
+		return nil, err
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy port %q", port)
+	}
+	fields.Server = host
+	fields.Port = uint16(portNumber)
+
+	options := make(map[string]string)
+	for _, item := range items[1:] {
+		if item.Key != "" {
+			options[item.Key] = item.Value
+		}
+	}
+	fields.Name = options["tag"]
+
+	switch fields.Protocol {
+	case "shadowsocks":
+		fields.Method = options["method"]
+		fields.Password = options["password"]
+	case "vmess":
+		fields.Method = options["method"]
+		if fields.Method == "chacha20-ietf-poly1305" {
+			fields.Method = "chacha20-poly1305"
+		}
+		fields.ID = options["password"]
+	case "vless":
+		if method := options["method"]; method != "" && method != "none" {
+			return nil, fmt.Errorf("unsupported vless method: %s", method)
+		}
+		fields.ID = options["password"]
+		fields.Flow = options["vless-flow"]
+		fields.RealityPublicKey = options["reality-base64-pubkey"]
+		fields.RealityShortID = options["reality-hex-shortid"]
+	case "trojan":
+		fields.Password = options["password"]
+	case "http", "socks":
+		fields.Username = options["username"]
+		fields.Password = options["password"]
+	}
+
+	fields.Transport.Network = "raw"
+	fields.Transport.Host = options["obfs-host"]
+	fields.Transport.Path = options["obfs-uri"]
+	switch obfs := options["obfs"]; obfs {
+	case "":
+	case "ws", "wss":
+		fields.Transport.Network = "ws"
+		fields.TLS = obfs == "wss"
+	case "over-tls":
+		fields.TLS = true
+	case "http", "tls":
+		if fields.Protocol == "shadowsocks" {
+			fields.Obfs = obfs
+		} else if obfs == "http" {
+			fields.Transport.HeaderType = "http"
+		} else {
+			return nil, fmt.Errorf("unsupported %s obfs: %s", fields.Protocol, obfs)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported obfs: %s", obfs)
+	}
+
+	if isTrue(options["over-tls"]) {
+		fields.TLS = true
+	}
+	fields.ServerName = options["tls-host"]
+	fields.Insecure = options["tls-verification"] == "false"
+	fields.PinnedPeerCertSha256 = options["tls-cert-sha256"]
+	return fields, nil
+}
+
+func (fields proxyLineFields) outbound() (*conf.OutboundDetourConfig, error) {
+	if fields.Server == "" || fields.Port == 0 {
+		return nil, fmt.Errorf("missing server address")
+	}
+	outbound := &conf.OutboundDetourConfig{}
+	outbound.Protocol = fields.Protocol
+	setOutboundName(outbound, fields.Name)
+
+	address := parseAddress(fields.Server)
+	var settings any
+	switch fields.Protocol {
+	case "shadowsocks":
+		if fields.Method == "" {
+			return nil, fmt.Errorf("missing shadowsocks cipher")
+		}
+		settings = conf.ShadowsocksClientConfig{
+			Address:  address,
+			Port:     fields.Port,
+			Cipher:   fields.Method,
+			Password: fields.Password,
+		}
+	case "vmess":
+		settings = conf.VMessOutboundConfig{
+			Address:  address,
+			Port:     fields.Port,
+			ID:       fields.ID,
+			Security: fields.Method,
+		}
+	case "vless":
+		settings = conf.VLessOutboundConfig{
+			Address:    address,
+			Port:       fields.Port,
+			Id:         fields.ID,
+			Flow:       fields.Flow,
+			Encryption: "none",
+		}
+	case "trojan":
+		settings = conf.TrojanClientConfig{
+			Address:  address,
+			Port:     fields.Port,
+			Password: fields.Password,
+		}
+	case "http":
+		settings = conf.HTTPClientConfig{
+			Address:  address,
+			Port:     fields.Port,
+			Username: fields.Username,
+			Password: fields.Password,
+		}
+	case "socks":
+		settings = conf.SocksClientConfig{
+			Address:  address,
+			Port:     fields.Port,
+			Username: fields.Username,
+			Password: fields.Password,
+		}
+	case "hysteria":
+		settings = conf.HysteriaClientConfig{
+			Version: 2,
+			Address: address,
+			Port:    fields.Port,
+		}
+	}
+	settingsRawMessage, err := convertJsonToRawMessage(settings)
+	if err != nil {
+		return nil, err
+	}
+	outbound.Settings = &settingsRawMessage
+
+	streamSettings, err := fields.streamSettings()
+	if err != nil {
+		return nil, err
+	}
+	outbound.StreamSetting = streamSettings
+	return outbound, nil
+}
+
+func (fields proxyLineFields) streamSettings() (*conf.StreamConfig, error) {
+	var streamSettings *conf.StreamConfig
+	var err error
+	switch {
+	case fields.Protocol == "hysteria":
+		streamSettings = &conf.StreamConfig{}
+		streamSettings.Network = new(conf.TransportProtocol("hysteria"))
+		streamSettings.HysteriaSettings = &conf.HysteriaConfig{Version: 2, Auth: fields.Password}
+		streamSettings.FinalMask, err = buildHy2FinalMask("", fields.Down, "", nil, "", "")
+	case fields.Obfs != "":
+		if fields.TLS {
+			return nil, fmt.Errorf("shadowsocks obfs cannot be combined with tls")
+		}
+		streamSettings, err = simpleObfsStreamSettings(fields.Obfs, fields.Transport.Host, fields.Transport.Path)
+	case fields.Protocol == "shadowsocks" && fields.Transport.Network == "ws":
+		// Quantumult X writes v2ray-plugin as obfs=ws or obfs=wss.
+		streamSettings, err = v2rayPluginStreamSettings(ClashProxyPluginOpts{
+			Mode:           "websocket",
+			Tls:            fields.TLS,
+			Host:           fields.Transport.Host,
+			Path:           fields.Transport.Path,
+			SkipCertVerify: fields.Insecure && fields.PinnedPeerCertSha256 == "",
+		})
+		if err == nil && fields.TLS {
+			if fields.ServerName != "" {
+				streamSettings.TLSSettings.ServerName = fields.ServerName
+			}
+			streamSettings.TLSSettings.PinnedPeerCertSha256 = fields.PinnedPeerCertSha256
+		}
+		return streamSettings, err
+	case fields.Protocol == "shadowsocks":
+		return nil, nil
+	default:
+		streamSettings, err = buildStreamFromTransportFields(fields.Transport)
+		if err == nil && streamSettings.WSSettings != nil {
+			streamSettings.WSSettings.Headers = fields.Headers
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fields.Protocol == "shadowsocks" {
+		return streamSettings, nil
+	}
+
+	switch {
+	case fields.RealityPublicKey != "":
+		streamSettings.Security = "reality"
+		streamSettings.REALITYSettings = &conf.REALITYConfig{
+			ServerName: fields.ServerName,
+			PublicKey:  fields.RealityPublicKey,
+			ShortId:    fields.RealityShortID,
+		}
+	case fields.TLS:
+		if fields.Insecure && fields.PinnedPeerCertSha256 == "" {
+			// Xray removed allowInsecure in favour of certificate pinning,
+			// which a pinned certificate already provides.
+			return nil, fmt.Errorf("tls insecure has no Xray equivalent")
+		}
+		tlsSettings := &conf.TLSConfig{}
+		tlsSettings.ServerName = fields.ServerName
+		if tlsSettings.ServerName == "" && streamSettings.WSSettings != nil {
+			tlsSettings.ServerName = streamSettings.WSSettings.Host
+		}
+		tlsSettings.PinnedPeerCertSha256 = fields.PinnedPeerCertSha256
+		streamSettings.Security = "tls"
+		streamSettings.TLSSettings = tlsSettings
+	default:
+		streamSettings.Security = "none"
+	}
+	return streamSettings, nil
+}
+
+func isTrue(value string) bool {
+	return strings.EqualFold(value, "true") || value == "1"
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,263 @@
+package share
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const surgeProfile = `[General]
+loglevel = notify
+dns-server = system, 8.8.8.8
+
+[Proxy]
+SS = ss, ss.example.com, 8388, encrypt-method=aes-128-gcm, password="pa,ss", obfs=http, obfs-host=bing.com
+VMess = vmess, vmess.example.com, 443, username=b831381d-6324-4d53-ad4f-8cda48b30811, ws=true, ws-path=/ws, ws-headers=Host:cdn.example.com|User-Agent:"Mozilla 5.0", tls=true, vmess-aead=true
+Trojan = trojan, trojan.example.com, 443, password=secret, sni=trojan.example.org
+HTTPS = https, proxy.example.com, 443, user, pass
+Hy2 = hysteria2, hy2.example.com, 443, password=auth, download-bandwidth=100
+Snell = snell, snell.example.com, 443, psk=x, version=4
+
+[Proxy Group]
+Proxy = select, SS, VMess, Trojan
+`
+
+const loonProxyLines = `SS = Shadowsocks,ss.example.com,8388,chacha20-ietf-poly1305,"pass",obfs-name=http,obfs-host=bing.com,obfs-uri=/
+VMess = vmess,vmess.example.com,443,auto,"b831381d-6324-4d53-ad4f-8cda48b30811",transport=ws,path=/ws,host=cdn.example.com,over-tls=true,sni=vmess.example.org
+VLESS = VLESS,vless.example.com,443,"b831381d-6324-4d53-ad4f-8cda48b30811",transport=tcp,flow=xtls-rprx-vision,public-key=jNXHt1yRo0vDuchQlIP6Z0ZvjT3KtzVI-T4E7RoLJS0,short-id=6ba85179e30d4fc2,over-tls=true,sni=www.microsoft.com
+Socks = socks5,socks.example.com,1080,user,"pass",over-tls=true,sni=socks.example.org`
+
+const quantumultXProfile = `[server_local]
+shadowsocks=ss.example.com:443, method=aes-128-gcm, password=pass, obfs=wss, obfs-host=ss.example.org, obfs-uri=/ws, tag=SS
+vmess=vmess.example.com:443, method=chacha20-ietf-poly1305, password=b831381d-6324-4d53-ad4f-8cda48b30811, obfs=over-tls, tls-host=vmess.example.org, tag=VMess
+vless=[2001:db8::1]:443, method=none, password=b831381d-6324-4d53-ad4f-8cda48b30811, obfs=over-tls, tls-host=www.microsoft.com, vless-flow=xtls-rprx-vision, reality-base64-pubkey=jNXHt1yRo0vDuchQlIP6Z0ZvjT3KtzVI-T4E7RoLJS0, reality-hex-shortid=6ba85179e30d4fc2, tag=VLESS
+trojan=trojan.example.com:443, password=secret, over-tls=true, tls-host=trojan.example.org, tag=Trojan
+http=proxy.example.com:8080, username=user, password=pass, tag=HTTP
+
+[filter_local]
+host-suffix, example.com, direct
+`
+
+func proxyLineOutboundsByName(t *testing.T, text string) map[string]conf.OutboundDetourConfig {
+	t.Helper()
+	config, err := ConvertShareLinksToXrayJson(text)
+	require.NoError(t, err)
+	outbounds := make(map[string]conf.OutboundDetourConfig, len(config.OutboundConfigs))
+	for _, outbound := range config.OutboundConfigs {
+		outbounds[getOutboundName(outbound)] = outbound
+	}
+	require.Len(t, outbounds, len(config.OutboundConfigs))
+	return outbounds
+}
+
+func TestSplitProxyLine(t *testing.T) {
+	items := splitProxyLine(` ss, "a,b" , password="c=d,e", Key = v `)
+	assert.Equal(t, []proxyLineItem{
+		{Value: "ss"},
+		{Value: "a,b"},
+		{Key: "password", Value: "c=d,e"},
+		{Key: "key", Value: "v"},
+	}, items)
+}
+
+func TestIsProxyLine(t *testing.T) {
+	assert.True(t, isProxyLine("A = vmess, h, 443, username=id"))
+	assert.True(t, isProxyLine("A = Shadowsocks,h,443,aes-128-gcm,\"p\""))
+	assert.True(t, isProxyLine("trojan=h:443, password=p, tag=A"))
+	assert.False(t, isProxyLine("dns-server = system, 8.8.8.8"))
+	assert.False(t, isProxyLine("Proxy = select, A, B"))
+	assert.False(t, isProxyLine("DOMAIN-SUFFIX,example.com,Proxy"))
+	assert.False(t, isProxyLine("https://sub.example.com/list, tag=Sub"))
+}
+
+func TestConvertShareLinksToXrayJson_Surge(t *testing.T) {
+	outbounds := proxyLineOutboundsByName(t, surgeProfile)
+	require.Len(t, outbounds, 5)
+
+	ss := outbounds["SS"]
+	ssSettings, err := decodeOutboundSettings[conf.ShadowsocksClientConfig](ss)
+	require.NoError(t, err)
+	assert.Equal(t, "aes-128-gcm", ssSettings.Cipher)
+	assert.Equal(t, "pa,ss", ssSettings.Password)
+	require.NotNil(t, ss.StreamSetting.RAWSettings)
+	assert.Contains(t, string(ss.StreamSetting.RAWSettings.HeaderConfig), "bing.com")
+
+	vmess := outbounds["VMess"]
+	vmessSettings, err := decodeOutboundSettings[conf.VMessOutboundConfig](vmess)
+	require.NoError(t, err)
+	assert.Equal(t, "b831381d-6324-4d53-ad4f-8cda48b30811", vmessSettings.ID)
+	assert.Equal(t, "ws", streamNetwork(vmess.StreamSetting))
+	assert.Equal(t, "/ws", vmess.StreamSetting.WSSettings.Path)
+	assert.Equal(t, "cdn.example.com", vmess.StreamSetting.WSSettings.Host)
+	assert.Equal(t, map[string]string{"User-Agent": "Mozilla 5.0"}, vmess.StreamSetting.WSSettings.Headers)
+	assert.Equal(t, "tls", vmess.StreamSetting.Security)
+	assert.Equal(t, "cdn.example.com", vmess.StreamSetting.TLSSettings.ServerName)
+
+	trojan := outbounds["Trojan"]
+	assert.Equal(t, "tls", trojan.StreamSetting.Security)
+	assert.Equal(t, "trojan.example.org", trojan.StreamSetting.TLSSettings.ServerName)
+
+	https := outbounds["HTTPS"]
+	httpSettings, err := decodeOutboundSettings[conf.HTTPClientConfig](https)
+	require.NoError(t, err)
+	assert.Equal(t, "user", httpSettings.Username)
+	assert.Equal(t, "pass", httpSettings.Password)
+	assert.Equal(t, "tls", https.StreamSetting.Security)
+
+	hy2 := outbounds["Hy2"]
+	assert.Equal(t, "hysteria", hy2.Protocol)
+	assert.Equal(t, "auth", hy2.StreamSetting.HysteriaSettings.Auth)
+	down, err := bandwidthMbps(hy2.StreamSetting.FinalMask.QuicParams.BrutalDown)
+	require.NoError(t, err)
+	assert.Equal(t, 100, down)
+}
+
+func TestConvertShareLinksToXrayJson_Loon(t *testing.T) {
+	outbounds := proxyLineOutboundsByName(t, loonProxyLines)
+	require.Len(t, outbounds, 4)
+
+	ssSettings, err := decodeOutboundSettings[conf.ShadowsocksClientConfig](outbounds["SS"])
+	require.NoError(t, err)
+	assert.Equal(t, "chacha20-ietf-poly1305", ssSettings.Cipher)
+	assert.Equal(t, "pass", ssSettings.Password)
+
+	vmess := outbounds["VMess"]
+	vmessSettings, err := decodeOutboundSettings[conf.VMessOutboundConfig](vmess)
+	require.NoError(t, err)
+	assert.Equal(t, "auto", vmessSettings.Security)
+	assert.Equal(t, "cdn.example.com", vmess.StreamSetting.WSSettings.Host)
+	assert.Equal(t, "vmess.example.org", vmess.StreamSetting.TLSSettings.ServerName)
+
+	vless := outbounds["VLESS"]
+	vlessSettings, err := decodeOutboundSettings[conf.VLessOutboundConfig](vless)
+	require.NoError(t, err)
+	assert.Equal(t, "xtls-rprx-vision", vlessSettings.Flow)
+	assert.Equal(t, "reality", vless.StreamSetting.Security)
+	assert.Equal(t, "6ba85179e30d4fc2", vless.StreamSetting.REALITYSettings.ShortId)
+	assert.Equal(t, "www.microsoft.com", vless.StreamSetting.REALITYSettings.ServerName)
+
+	socks := outbounds["Socks"]
+	assert.Equal(t, "socks", socks.Protocol)
+	assert.Equal(t, "tls", socks.StreamSetting.Security)
+}
+
+func TestConvertShareLinksToXrayJson_QuantumultX(t *testing.T) {
+	outbounds := proxyLineOutboundsByName(t, quantumultXProfile)
+	require.Len(t, outbounds, 5)
+
+	ss := outbounds["SS"]
+	assert.Equal(t, "/ws", ss.StreamSetting.WSSettings.Path)
+	assert.Equal(t, "tls", ss.StreamSetting.Security)
+	assert.Equal(t, "ss.example.org", ss.StreamSetting.TLSSettings.ServerName)
+
+	vmess := outbounds["VMess"]
+	vmessSettings, err := decodeOutboundSettings[conf.VMessOutboundConfig](vmess)
+	require.NoError(t, err)
+	assert.Equal(t, "chacha20-poly1305", vmessSettings.Security)
+	assert.Equal(t, "raw", streamNetwork(vmess.StreamSetting))
+	assert.Equal(t, "vmess.example.org", vmess.StreamSetting.TLSSettings.ServerName)
+
+	vless := outbounds["VLESS"]
+	vlessSettings, err := decodeOutboundSettings[conf.VLessOutboundConfig](vless)
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]", vlessSettings.Address.String())
+	assert.Equal(t, "reality", vless.StreamSetting.Security)
+
+	trojan := outbounds["Trojan"]
+	assert.Equal(t, "trojan.example.org", trojan.StreamSetting.TLSSettings.ServerName)
+
+	http := outbounds["HTTP"]
+	assert.Equal(t, "none", http.StreamSetting.Security)
+}
+
+func TestConvertShareLinksToXrayJson_QuantumultXServerRemote(t *testing.T) {
+	profile := "[server_remote]\nhttps://sub.example.com/quanx/token, tag=Remote, update-interval=86400\n\n" + quantumultXProfile
+	outbounds := proxyLineOutboundsByName(t, profile)
+	assert.Len(t, outbounds, 5)
+}
+
+func TestConvertShareLinksToXrayJson_ProxyLineErrors(t *testing.T) {
+	_, err := ConvertShareLinksToXrayJson("A = snell, h, 443, psk=x\nB = ss, h, 443, encrypt-method=aes-128-gcm, password=p, obfs=tls")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 1: unsupported proxy type: snell")
+	assert.Contains(t, err.Error(), "line 2: unsupported simple-obfs mode: tls has no Xray equivalent")
+
+	_, err = ConvertShareLinksToXrayJson("A = vmess, h, 443, username=id, underlying-proxy=B")
+	assert.ErrorContains(t, err, "proxy option underlying-proxy has no Xray equivalent")
+
+	_, err = ConvertShareLinksToXrayJson("A = trojan, h, 443, password=p, skip-cert-verify=true\n" +
+		"shadowsocks=h:443, method=aes-128-gcm, password=p, obfs=wss, tls-verification=false, tag=B")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 1: tls insecure has no Xray equivalent")
+	assert.Contains(t, err.Error(), "line 2: tls insecure has no Xray equivalent")
+}
+
+func TestConvertShareLinksToXrayJson_ProxyLineInsecureWithPinnedCert(t *testing.T) {
+	const pin = "e4b3ab4f8a87d9b4a94fd0ed6e1c5f3ab2ae7c2b5d08df3ed5fb6b9a4a1c1d3e"
+	outbounds := proxyLineOutboundsByName(t, "A = trojan, h, 443, password=p, skip-cert-verify=true, server-cert-fingerprint-sha256="+pin+"\n"+
+		"shadowsocks=h:443, method=aes-128-gcm, password=p, obfs=wss, tls-verification=false, tls-cert-sha256="+pin+", tag=B")
+	for _, name := range []string{"A", "B"} {
+		tlsSettings := outbounds[name].StreamSetting.TLSSettings
+		require.NotNil(t, tlsSettings, name)
+		assert.False(t, tlsSettings.AllowInsecure, name)
+		assert.Equal(t, pin, tlsSettings.PinnedPeerCertSha256, name)
+	}
+}
+
+func TestConvertXrayJsonToProxyLines_RoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		format ProxyLineFormat
+		input  string
+		count  int
+	}{
+		{ProxyLineFormatSurge, surgeProfile, 5},
+		{ProxyLineFormatLoon, loonProxyLines, 4},
+		{ProxyLineFormatQuantumultX, quantumultXProfile, 5},
+	} {
+		t.Run(string(test.format), func(t *testing.T) {
+			imported, err := ConvertShareLinksToXrayJson(test.input)
+			require.NoError(t, err)
+			xrayBytes, err := json.Marshal(imported)
+			require.NoError(t, err)
+
+			lines, err := ConvertXrayJsonToProxyLines(xrayBytes, test.format)
+			require.NoError(t, err)
+			assert.Len(t, strings.Split(lines, "\n"), test.count)
+
+			reimported, err := ConvertShareLinksToXrayJson(lines)
+			require.NoError(t, err)
+			assert.Equal(t, imported.OutboundConfigs, reimported.OutboundConfigs)
+		})
+	}
+}
+
+func TestConvertXrayJsonToProxyLines_Lines(t *testing.T) {
+	xrayJSON := `{"outbounds":[
+		{"protocol":"vless","sendThrough":"VLESS","settings":{"address":"vless.example.com","port":443,"id":"b831381d-6324-4d53-ad4f-8cda48b30811","encryption":"none"},
+		 "streamSettings":{"network":"ws","wsSettings":{"path":"/ws","host":"cdn.example.com"},"security":"tls","tlsSettings":{"serverName":"vless.example.org"}}},
+		{"protocol":"trojan","sendThrough":"Trojan","settings":{"address":"trojan.example.com","port":443,"password":"p,w"},
+		 "streamSettings":{"security":"tls"}},
+		{"protocol":"freedom"}
+	]}`
+
+	surge, err := ConvertXrayJsonToProxyLines([]byte(xrayJSON), ProxyLineFormatSurge)
+	require.NoError(t, err)
+	assert.Equal(t, `Trojan = trojan, trojan.example.com, 443, password="p,w"`, surge)
+
+	loon, err := ConvertXrayJsonToProxyLines([]byte(xrayJSON), ProxyLineFormatLoon)
+	require.NoError(t, err)
+	assert.Equal(t, `VLESS = VLESS,vless.example.com,443,"b831381d-6324-4d53-ad4f-8cda48b30811",transport=ws,path=/ws,host=cdn.example.com,over-tls=true,sni=vless.example.org
+Trojan = trojan,trojan.example.com,443,"p,w",transport=tcp`, loon)
+
+	quantumultX, err := ConvertXrayJsonToProxyLines([]byte(xrayJSON), ProxyLineFormatQuantumultX)
+	require.NoError(t, err)
+	assert.Equal(t, `vless=vless.example.com:443, method=none, password=b831381d-6324-4d53-ad4f-8cda48b30811, obfs=wss, obfs-host=cdn.example.com, obfs-uri=/ws, tls-host=vless.example.org, tag=VLESS
+trojan=trojan.example.com:443, password="p,w", over-tls=true, tag=Trojan`, quantumultX)
+
+	_, err = ConvertXrayJsonToProxyLines([]byte(xrayJSON), "clash")
+	assert.ErrorContains(t, err, `unsupported proxy line format: "clash"`)
+}
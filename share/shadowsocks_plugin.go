@@ -0,0 +1,197 @@
+package share
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// https://shadowsocks.org/doc/sip003.html
+//
+// parseSIP003Plugin splits a SIP002 plugin parameter such as
+// "v2ray-plugin;mode=websocket;tls;host=example.com" into the plugin name and
+// its options. Options without a value, like "tls", map to "". A backslash
+// escapes ';', '=', and itself.
+func parseSIP003Plugin(plugin string) (string, map[string]string, error) {
+	var fields []string
+	var field strings.Builder
+	escaped := false
+	for _, r := range plugin {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if escaped {
+		return "", nil, fmt.Errorf("invalid shadowsocks plugin options: trailing backslash")
+	}
+	fields = append(fields, field.String())
+
+	name := strings.TrimSpace(fields[0])
+	if name == "" {
+		return "", nil, fmt.Errorf("missing shadowsocks plugin name")
+	}
+	options := make(map[string]string, len(fields)-1)
+	for _, option := range fields[1:] {
+		key, value, _ := strings.Cut(option, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			options[key] = value
+		}
+	}
+	return name, options, nil
+}
+
+// shadowsocksPluginStreamSettings translates a SIP003 plugin into the
+// equivalent Xray transport.
+func shadowsocksPluginStreamSettings(plugin string) (*conf.StreamConfig, error) {
+	name, options, err := parseSIP003Plugin(plugin)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "v2ray-plugin":
+		mode := options["mode"]
+		if mode == "" {
+			mode = "websocket"
+		}
+		_, tls := options["tls"]
+		return v2rayPluginStreamSettings(ClashProxyPluginOpts{
+			Mode: mode,
+			Tls:  tls,
+			Host: options["host"],
+			Path: options["path"],
+		})
+	case "obfs-local", "simple-obfs":
+		return simpleObfsStreamSettings(
+			options["obfs"],
+			options["obfs-host"],
+			options["obfs-uri"],
+		)
+	default:
+		return nil, fmt.Errorf("unsupported shadowsocks plugin: %s", name)
+	}
+}
+
+// shadowsocksPluginFromStream is the reverse of
+// shadowsocksPluginStreamSettings. It returns the SIP003 plugin name and its
+// options, or empty strings when the stream needs no plugin.
+func shadowsocksPluginFromStream(streamSettings *conf.StreamConfig) (string, string, error) {
+	if streamSettings == nil {
+		return "", "", nil
+	}
+	network := streamNetwork(streamSettings)
+	var options []string
+	addOption := func(key, value string) {
+		options = append(options, key+"="+escapeSIP003PluginOption(value))
+	}
+	switch network {
+	case "raw", "tcp":
+		header, err := rawHTTPHeader(streamSettings)
+		if err != nil || header == nil {
+			return "", "", err
+		}
+		addOption("obfs", "http")
+		if header.Request != nil {
+			if header.Request.Headers != nil && len(header.Request.Headers.Host) > 0 {
+				addOption("obfs-host", header.Request.Headers.Host[0])
+			}
+			if len(header.Request.Path) > 0 {
+				addOption("obfs-uri", header.Request.Path[0])
+			}
+		}
+		return "obfs-local", strings.Join(options, ";"), nil
+	case "ws", "websocket":
+		addOption("mode", "websocket")
+		if wsSettings := streamSettings.WSSettings; wsSettings != nil {
+			if len(wsSettings.Host) > 0 {
+				addOption("host", wsSettings.Host)
+			}
+			if len(wsSettings.Path) > 0 {
+				addOption("path", wsSettings.Path)
+			}
+		}
+		switch streamSettings.Security {
+		case "", "none":
+		case "tls":
+			options = append(options, "tls")
+		default:
+			return "", "", fmt.Errorf("unsupported shadowsocks plugin security: %s", streamSettings.Security)
+		}
+		return "v2ray-plugin", strings.Join(options, ";"), nil
+	default:
+		return "", "", fmt.Errorf("unsupported shadowsocks plugin network: %s", network)
+	}
+}
+
+// escapeSIP003PluginOption is the reverse of the unescaping in
+// parseSIP003Plugin.
+func escapeSIP003PluginOption(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, "=", `\=`)
+	return replacer.Replace(value)
+}
+
+// v2rayPluginStreamSettings maps v2ray-plugin options, shared by SIP003
+// links and Clash plugin-opts, to a WebSocket transport.
+func v2rayPluginStreamSettings(opts ClashProxyPluginOpts) (*conf.StreamConfig, error) {
+	if opts.Mode != "websocket" {
+		return nil, fmt.Errorf("unsupport ss plugin-opts mode: %s", opts.Mode)
+	}
+	streamSetting := &conf.StreamConfig{}
+	streamSetting.Network = new(conf.TransportProtocol("websocket"))
+
+	wsSettings := &conf.WebSocketConfig{}
+	if len(opts.Host) > 0 {
+		wsSettings.Host = opts.Host
+	}
+	if len(opts.Path) > 0 {
+		wsSettings.Path = opts.Path
+	}
+	streamSetting.WSSettings = wsSettings
+
+	if opts.Tls {
+		if opts.SkipCertVerify {
+			// Xray removed allowInsecure in favour of certificate pinning.
+			return nil, fmt.Errorf("tls insecure has no Xray equivalent")
+		}
+		tlsSettings := &conf.TLSConfig{}
+		tlsSettings.ServerName = opts.Host
+		tlsSettings.Fingerprint = opts.Fingerprint
+
+		if opts.EchOpts != nil {
+			if opts.EchOpts.Enable {
+				tlsSettings.ECHConfigList = opts.EchOpts.Config
+			}
+		}
+
+		streamSetting.Security = "tls"
+		streamSetting.TLSSettings = tlsSettings
+	}
+	return streamSetting, nil
+}
+
+// simpleObfsStreamSettings maps simple-obfs HTTP mode to the raw transport
+// HTTP header. Xray has no equivalent of the TLS mode.
+func simpleObfsStreamSettings(mode, host, path string) (*conf.StreamConfig, error) {
+	switch mode {
+	case "http":
+	case "tls":
+		return nil, fmt.Errorf("unsupported simple-obfs mode: tls has no Xray equivalent")
+	default:
+		return nil, fmt.Errorf("unsupported simple-obfs mode: %q", mode)
+	}
+	return buildStreamFromTransportFields(shareTransportFields{
+		Network:    "raw",
+		HeaderType: "http",
+		Host:       host,
+		Path:       path,
+	})
+}
@@ -0,0 +1,297 @@
+package share
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshSignatureHeader       = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureFooter       = "-----END SSH SIGNATURE-----"
+	sshSignatureMagic        = "SSHSIG"
+	minisignUntrustedComment = "untrusted comment:"
+	minisignTrustedComment   = "trusted comment: "
+
+	// DefaultSignatureNamespace is the namespace of "ssh-keygen -Y sign -n
+	// file", the one meant for signing files.
+	DefaultSignatureNamespace = "file"
+)
+
+var (
+	ErrSignatureMissing    = errors.New("subscription is not signed")
+	ErrSignatureMalformed  = errors.New("malformed subscription signature")
+	ErrSignatureKeyMissing = errors.New("missing trusted signature key")
+	ErrSignatureKeyInvalid = errors.New("invalid or unsupported trusted signature key")
+	ErrSignatureUntrusted  = errors.New("subscription is signed by an untrusted key")
+	ErrSignatureInvalid    = errors.New("subscription signature does not verify")
+)
+
+// SignatureOptions verifies who produced a subscription. It accepts SSH
+// signatures ("ssh-keygen -Y sign") and minisign signatures.
+type SignatureOptions struct {
+	// TrustedKeys are SSH public keys in authorized_keys form, or minisign
+	// public keys with or without their comment line.
+	TrustedKeys []string
+	// Signature is a detached signature of the whole text. When empty, the
+	// text must end with the signature block, which signs everything before
+	// the block's first line.
+	Signature string
+	// Namespace of SSH signatures; DefaultSignatureNamespace when empty.
+	Namespace string
+}
+
+// ConvertShareLinksToXrayJsonWithSignature verifies the subscription
+// signature and converts the signed text.
+func ConvertShareLinksToXrayJsonWithSignature(links string, options SignatureOptions) (*conf.Config, error) {
+	signed, err := VerifySubscriptionSignature(links, options)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertShareLinksToXrayJson(signed)
+}
+
+// VerifySubscriptionSignature checks the signature of text against the
+// trusted keys and returns the signed text, without an embedded signature
+// block.
+func VerifySubscriptionSignature(text string, options SignatureOptions) (string, error) {
+	if len(options.TrustedKeys) == 0 {
+		return "", ErrSignatureKeyMissing
+	}
+	sshKeys, minisignKeys, err := parseTrustedSignatureKeys(options.TrustedKeys)
+	if err != nil {
+		return "", err
+	}
+	namespace := options.Namespace
+	if namespace == "" {
+		namespace = DefaultSignatureNamespace
+	}
+
+	message, signature := text, options.Signature
+	if strings.TrimSpace(signature) == "" {
+		var found bool
+		message, signature, found = cutSignatureBlock(text)
+		if !found {
+			return "", ErrSignatureMissing
+		}
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(signature), sshSignatureHeader) {
+		err = verifySSHSignature([]byte(message), signature, namespace, sshKeys)
+	} else {
+		err = verifyMinisignSignature([]byte(message), signature, minisignKeys)
+	}
+	if err != nil {
+		return "", err
+	}
+	return message, nil
+}
+
+// cutSignatureBlock splits text at the signature block that ends it.
+func cutSignatureBlock(text string) (message, signature string, found bool) {
+	trimmed := strings.TrimRight(text, " \t\r\n")
+	if strings.HasSuffix(trimmed, sshSignatureFooter) {
+		index := strings.LastIndex(trimmed, sshSignatureHeader)
+		if index >= 0 && (index == 0 || trimmed[index-1] == '\n') {
+			return text[:index], trimmed[index:], true
+		}
+		return "", "", false
+	}
+
+	// A minisign block is four lines, the first one its untrusted comment.
+	index := len(trimmed)
+	for range 4 {
+		index = strings.LastIndexByte(trimmed[:index], '\n')
+		if index < 0 {
+			index = 0
+			break
+		}
+	}
+	if index > 0 {
+		index++
+	}
+	if !strings.HasPrefix(trimmed[index:], minisignUntrustedComment) {
+		return "", "", false
+	}
+	return text[:index], trimmed[index:], true
+}
+
+type minisignPublicKey struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+func parseTrustedSignatureKeys(trustedKeys []string) ([]ssh.PublicKey, []minisignPublicKey, error) {
+	var sshKeys []ssh.PublicKey
+	var minisignKeys []minisignPublicKey
+	for _, trustedKey := range trustedKeys {
+		if key, ok := parseMinisignPublicKey(trustedKey); ok {
+			minisignKeys = append(minisignKeys, key)
+			continue
+		}
+		key, _, _, rest, err := ssh.ParseAuthorizedKey([]byte(trustedKey))
+		if err != nil || len(bytes.TrimSpace(rest)) != 0 {
+			return nil, nil, ErrSignatureKeyInvalid
+		}
+		if _, ok := key.(*ssh.Certificate); ok {
+			return nil, nil, ErrSignatureKeyInvalid
+		}
+		sshKeys = append(sshKeys, key)
+	}
+	return sshKeys, minisignKeys, nil
+}
+
+// parseMinisignPublicKey reads the base64 line of a minisign public key:
+// "Ed", the key ID and the Ed25519 key.
+func parseMinisignPublicKey(trustedKey string) (minisignPublicKey, bool) {
+	lines := signatureLines(trustedKey)
+	if len(lines) == 2 && strings.HasPrefix(lines[0], minisignUntrustedComment) {
+		lines = lines[1:]
+	}
+	if len(lines) != 1 {
+		return minisignPublicKey{}, false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(decoded) != 2+8+ed25519.PublicKeySize || string(decoded[:2]) != "Ed" {
+		return minisignPublicKey{}, false
+	}
+	var key minisignPublicKey
+	copy(key.keyID[:], decoded[2:10])
+	key.key = ed25519.PublicKey(decoded[10:])
+	return key, true
+}
+
+// verifyMinisignSignature checks both signatures of a minisign block: the
+// one over the message, and the global one over it and the trusted comment.
+func verifyMinisignSignature(message []byte, block string, trustedKeys []minisignPublicKey) error {
+	lines := signatureLines(block)
+	if len(lines) != 4 ||
+		!strings.HasPrefix(lines[0], minisignUntrustedComment) ||
+		!strings.HasPrefix(lines[2], minisignTrustedComment) {
+		return ErrSignatureMalformed
+	}
+	signature, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(signature) != 2+8+ed25519.SignatureSize {
+		return ErrSignatureMalformed
+	}
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return ErrSignatureMalformed
+	}
+
+	switch string(signature[:2]) {
+	case "Ed":
+	case "ED":
+		prehashed := blake2b.Sum512(message)
+		message = prehashed[:]
+	default:
+		return ErrSignatureMalformed
+	}
+	var keyID [8]byte
+	copy(keyID[:], signature[2:10])
+	for _, key := range trustedKeys {
+		if key.keyID != keyID {
+			continue
+		}
+		global := append(bytes.Clone(signature[10:]), strings.TrimPrefix(lines[2], minisignTrustedComment)...)
+		if !ed25519.Verify(key.key, message, signature[10:]) || !ed25519.Verify(key.key, global, globalSignature) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	}
+	return ErrSignatureUntrusted
+}
+
+// verifySSHSignature checks an armored SSHSIG blob, the format of
+// "ssh-keygen -Y sign".
+func verifySSHSignature(message []byte, block, namespace string, trustedKeys []ssh.PublicKey) error {
+	lines := signatureLines(block)
+	if len(lines) < 3 || lines[0] != sshSignatureHeader || lines[len(lines)-1] != sshSignatureFooter {
+		return ErrSignatureMalformed
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:len(lines)-1], ""))
+	if err != nil || !bytes.HasPrefix(blob, []byte(sshSignatureMagic)) {
+		return ErrSignatureMalformed
+	}
+	var signed struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := ssh.Unmarshal(blob[len(sshSignatureMagic):], &signed); err != nil || signed.Version != 1 {
+		return ErrSignatureMalformed
+	}
+	publicKey, err := ssh.ParsePublicKey(signed.PublicKey)
+	if err != nil {
+		return ErrSignatureMalformed
+	}
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(signed.Signature, &signature); err != nil {
+		return ErrSignatureMalformed
+	}
+
+	var digest hash.Hash
+	switch signed.HashAlgorithm {
+	case "sha256":
+		digest = sha256.New()
+	case "sha512":
+		digest = sha512.New()
+	default:
+		return ErrSignatureMalformed
+	}
+	digest.Write(message)
+
+	trusted := false
+	for _, key := range trustedKeys {
+		if bytes.Equal(key.Marshal(), publicKey.Marshal()) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return ErrSignatureUntrusted
+	}
+	// SSHSIG forbids SHA-1 RSA signatures, and a signature from another
+	// namespace was made for something else.
+	if signed.Namespace != namespace || signature.Format == ssh.KeyAlgoRSA {
+		return ErrSignatureInvalid
+	}
+	data := append([]byte(sshSignatureMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{signed.Namespace, signed.Reserved, signed.HashAlgorithm, digest.Sum(nil)})...)
+	if err := publicKey.Verify(data, &signature); err != nil {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// signatureLines returns the non-empty lines of text. Only base64 lines
+// are trimmed: a minisign trusted comment is signed as is.
+func signatureLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(FixWindowsReturn(strings.TrimSpace(text)), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.Contains(line, "comment:") {
+			line = strings.TrimSpace(line)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,170 @@
+package share
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Made with "ssh-keygen -Y sign -n file" and "-n libxray" over
+// signatureTestText.
+const (
+	signatureTestText       = "trojan://secret@example.com:443?security=tls#Signed\n"
+	signatureTestEd25519Key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHPC6Aa89zCRIZr19kxkRhpSxSG6V6YcU5t9ij9Oa/0e provider"
+	signatureTestOtherKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEylJdvOhZibNNyv969JAJtYwSQu/f3NJiEByv8/1nDK other"
+	signatureTestEd25519Sig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgc8LoBrz3MJEhmvX2TGRGGlLFIb
+pXphxTm32KP05r/R4AAAAEZmlsZQAAAAAAAAAGc2hhNTEyAAAAUwAAAAtzc2gtZWQyNTUx
+OQAAAEAHSaPeLKFokzTNArVS6jdiYrKtJiZls7FdTZERYpayEJqH9EFotmRexFIPo22qCD
+zbrAfZKOTizFncwwfHve8A
+-----END SSH SIGNATURE-----
+`
+	signatureTestECDSAKey = "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBAi1lTC0ZVIbOtQrr/2PTxlCc8BG9bwBwUum/VRJNsILvixWtH0dp4LYRxoZ0FQk7cIZ9q7BnZDiDkb4qakfGME= ecdsa"
+	signatureTestECDSASig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAAGgAAAATZWNkc2Etc2hhMi1uaXN0cDI1NgAAAAhuaXN0cDI1NgAAAE
+EECLWVMLRlUhs61Cuv/Y9PGUJzwEb1vAHBS6b9VEk2wgu+LFa0fR2ngthHGhnQVCTtwhn2
+rsGdkOIORvipqR8YwQAAAAdsaWJ4cmF5AAAAAAAAAAZzaGE1MTIAAABkAAAAE2VjZHNhLX
+NoYTItbmlzdHAyNTYAAABJAAAAIC4bmmu81mbiNVnZnvwmR0zx90GAkDPYTvPpCmpaWS9o
+AAAAIQDbPVQREnKkVsyn4b1Be4q1tPLOm1BpDGUvC1ul4kxTLg==
+-----END SSH SIGNATURE-----
+`
+)
+
+func TestVerifySubscriptionSignature_SSHEmbedded(t *testing.T) {
+	signed, err := VerifySubscriptionSignature(signatureTestText+signatureTestEd25519Sig, SignatureOptions{
+		TrustedKeys: []string{signatureTestOtherKey, signatureTestEd25519Key},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, signatureTestText, signed)
+
+	config, err := ConvertShareLinksToXrayJsonWithSignature(signatureTestText+signatureTestEd25519Sig, SignatureOptions{
+		TrustedKeys: []string{signatureTestEd25519Key},
+	})
+	require.NoError(t, err)
+	assert.Len(t, config.OutboundConfigs, 1)
+}
+
+func TestVerifySubscriptionSignature_SSHDetached(t *testing.T) {
+	signed, err := VerifySubscriptionSignature(signatureTestText, SignatureOptions{
+		TrustedKeys: []string{signatureTestECDSAKey},
+		Signature:   signatureTestECDSASig,
+		Namespace:   "libxray",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, signatureTestText, signed)
+
+	// The ECDSA signature was made for another namespace.
+	_, err = VerifySubscriptionSignature(signatureTestText, SignatureOptions{
+		TrustedKeys: []string{signatureTestECDSAKey},
+		Signature:   signatureTestECDSASig,
+	})
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+}
+
+func TestVerifySubscriptionSignature_SSHErrors(t *testing.T) {
+	tampered := strings.Replace(signatureTestText, "example.com", "attacker.example", 1)
+	for name, test := range map[string]struct {
+		text    string
+		options SignatureOptions
+		want    error
+	}{
+		"no trusted key": {
+			text: signatureTestText + signatureTestEd25519Sig,
+			want: ErrSignatureKeyMissing,
+		},
+		"invalid trusted key": {
+			text:    signatureTestText + signatureTestEd25519Sig,
+			options: SignatureOptions{TrustedKeys: []string{"ssh-ed25519 invalid"}},
+			want:    ErrSignatureKeyInvalid,
+		},
+		"unsigned": {
+			text:    signatureTestText,
+			options: SignatureOptions{TrustedKeys: []string{signatureTestEd25519Key}},
+			want:    ErrSignatureMissing,
+		},
+		"untrusted": {
+			text:    signatureTestText + signatureTestEd25519Sig,
+			options: SignatureOptions{TrustedKeys: []string{signatureTestOtherKey}},
+			want:    ErrSignatureUntrusted,
+		},
+		"tampered": {
+			text:    tampered + signatureTestEd25519Sig,
+			options: SignatureOptions{TrustedKeys: []string{signatureTestEd25519Key}},
+			want:    ErrSignatureInvalid,
+		},
+		"malformed": {
+			text:    signatureTestText + "-----BEGIN SSH SIGNATURE-----\nU1NIU0lH\n-----END SSH SIGNATURE-----\n",
+			options: SignatureOptions{TrustedKeys: []string{signatureTestEd25519Key}},
+			want:    ErrSignatureMalformed,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := VerifySubscriptionSignature(test.text, test.options)
+			assert.ErrorIs(t, err, test.want)
+
+			_, _, err = ConvertShareLinksToXrayJsonWithDiagnostics(test.text, test.options, AgeDecryptOptions{}, nil)
+			if test.want != ErrSignatureKeyMissing {
+				assert.ErrorIs(t, err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifySubscriptionSignature_Minisign(t *testing.T) {
+	publicKey, secretKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	trustedKey := "untrusted comment: minisign public key 0807060504030201\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), publicKey...))
+
+	for _, algorithm := range []string{"Ed", "ED"} {
+		t.Run(algorithm, func(t *testing.T) {
+			block := minisignForTest(secretKey, keyID, algorithm, signatureTestText, "timestamp:1792368000")
+			signed, err := VerifySubscriptionSignature(signatureTestText+block, SignatureOptions{TrustedKeys: []string{trustedKey}})
+			require.NoError(t, err)
+			assert.Equal(t, signatureTestText, signed)
+
+			signed, err = VerifySubscriptionSignature(signatureTestText, SignatureOptions{
+				TrustedKeys: []string{trustedKey},
+				Signature:   block,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, signatureTestText, signed)
+		})
+	}
+
+	block := minisignForTest(secretKey, keyID, "ED", signatureTestText, "timestamp:1792368000")
+	forgedComment := strings.Replace(block, "timestamp:1792368000", "timestamp:1892368000", 1)
+	_, err = VerifySubscriptionSignature(signatureTestText+forgedComment, SignatureOptions{TrustedKeys: []string{trustedKey}})
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+
+	_, err = VerifySubscriptionSignature(signatureTestText+"changed\n"+block, SignatureOptions{TrustedKeys: []string{trustedKey}})
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+
+	otherID := minisignForTest(secretKey, []byte{8, 7, 6, 5, 4, 3, 2, 1}, "ED", signatureTestText, "")
+	_, err = VerifySubscriptionSignature(signatureTestText+otherID, SignatureOptions{TrustedKeys: []string{trustedKey}})
+	assert.ErrorIs(t, err, ErrSignatureUntrusted)
+
+	_, err = VerifySubscriptionSignature(signatureTestText+block, SignatureOptions{TrustedKeys: []string{signatureTestEd25519Key}})
+	assert.ErrorIs(t, err, ErrSignatureUntrusted)
+}
+
+func minisignForTest(secretKey ed25519.PrivateKey, keyID []byte, algorithm, message, trustedComment string) string {
+	signed := []byte(message)
+	if algorithm == "ED" {
+		prehashed := blake2b.Sum512(signed)
+		signed = prehashed[:]
+	}
+	signature := ed25519.Sign(secretKey, signed)
+	globalSignature := ed25519.Sign(secretKey, append(append([]byte{}, signature...), trustedComment...))
+	return "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyID...), signature...)) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSignature) + "\n"
+}
@@ -0,0 +1,536 @@
+package share
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// https://sing-box.sagernet.org/configuration/outbound/
+
+type SingBoxConfig struct {
+	Outbounds []SingBoxOutbound `json:"outbounds"`
+}
+
+type SingBoxOutbound struct {
+	Type       string `json:"type"`
+	Tag        string `json:"tag,omitempty"`
+	Server     string `json:"server,omitempty"`
+	ServerPort uint16 `json:"server_port,omitempty"`
+	Detour     string `json:"detour,omitempty"`
+
+	UUID           string `json:"uuid,omitempty"`
+	Flow           string `json:"flow,omitempty"`
+	Security       string `json:"security,omitempty"`
+	AlterID        int    `json:"alter_id,omitempty"`
+	PacketEncoding string `json:"packet_encoding,omitempty"`
+
+	Method     string             `json:"method,omitempty"`
+	Password   string             `json:"password,omitempty"`
+	Plugin     string             `json:"plugin,omitempty"`
+	PluginOpts string             `json:"plugin_opts,omitempty"`
+	UDPOverTCP *SingBoxUDPOverTCP `json:"udp_over_tcp,omitempty"`
+
+	Version  string                     `json:"version,omitempty"`
+	Username string                     `json:"username,omitempty"`
+	Path     string                     `json:"path,omitempty"`
+	Headers  map[string]SingBoxListable `json:"headers,omitempty"`
+
+	ServerPorts SingBoxListable `json:"server_ports,omitempty"`
+	HopInterval string          `json:"hop_interval,omitempty"`
+	UpMbps      int             `json:"up_mbps,omitempty"`
+	DownMbps    int             `json:"down_mbps,omitempty"`
+	Obfs        *SingBoxObfs    `json:"obfs,omitempty"`
+
+	TLS       *SingBoxTLS       `json:"tls,omitempty"`
+	Transport *SingBoxTransport `json:"transport,omitempty"`
+	Multiplex *SingBoxMultiplex `json:"multiplex,omitempty"`
+}
+
+type SingBoxTLS struct {
+	Enabled    bool            `json:"enabled"`
+	ServerName string          `json:"server_name,omitempty"`
+	Insecure   bool            `json:"insecure,omitempty"`
+	ALPN       SingBoxListable `json:"alpn,omitempty"`
+	UTLS       *SingBoxUTLS    `json:"utls,omitempty"`
+	Reality    *SingBoxReality `json:"reality,omitempty"`
+	ECH        *SingBoxECH     `json:"ech,omitempty"`
+}
+
+type SingBoxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+type SingBoxReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key,omitempty"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+type SingBoxECH struct {
+	Enabled bool            `json:"enabled"`
+	Config  SingBoxListable `json:"config,omitempty"`
+}
+
+type SingBoxTransport struct {
+	Type                string                     `json:"type"`
+	Host                SingBoxListable            `json:"host,omitempty"`
+	Path                string                     `json:"path,omitempty"`
+	Headers             map[string]SingBoxListable `json:"headers,omitempty"`
+	ServiceName         string                     `json:"service_name,omitempty"`
+	MaxEarlyData        uint32                     `json:"max_early_data,omitempty"`
+	EarlyDataHeaderName string                     `json:"early_data_header_name,omitempty"`
+}
+
+type SingBoxObfs struct {
+	Type     string `json:"type,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type SingBoxMultiplex struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SingBoxUDPOverTCP accepts both the boolean and the object form.
+type SingBoxUDPOverTCP struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (u *SingBoxUDPOverTCP) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &u.Enabled); err == nil {
+		return nil
+	}
+	type plain SingBoxUDPOverTCP
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+// SingBoxListable is a sing-box list option, which may also be written as a
+// single string.
+type SingBoxListable []string
+
+func (l *SingBoxListable) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*l = nil
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*l = SingBoxListable{value}
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+func (l SingBoxListable) first() string {
+	if len(l) == 0 {
+		return ""
+	}
+	return l[0]
+}
+
+// singBoxProtocols maps sing-box outbound types to Xray protocols.
+var singBoxProtocols = map[string]string{
+	"vless":       "vless",
+	"vmess":       "vmess",
+	"trojan":      "trojan",
+	"shadowsocks": "shadowsocks",
+	"hysteria2":   "hysteria",
+	"socks":       "socks",
+	"http":        "http",
+}
+
+// singBoxTransports maps sing-box V2Ray transport types to Xray networks.
+// The http (h2) and quic transports have no Xray counterpart.
+var singBoxTransports = map[string]string{
+	"ws":          "ws",
+	"grpc":        "grpc",
+	"httpupgrade": "httpupgrade",
+}
+
+// singBoxEarlyDataHeader is the only early data header Xray WebSocket sends.
+const singBoxEarlyDataHeader = "Sec-WebSocket-Protocol"
+
+// isSingBoxConfig reports whether text is a sing-box document: its outbounds
+// carry "type" where Xray outbounds carry "protocol".
+func isSingBoxConfig(text string) bool {
+	var probe struct {
+		Outbounds []struct {
+			Type     string `json:"type"`
+			Protocol string `json:"protocol"`
+		} `json:"outbounds"`
+	}
+	if err := json.Unmarshal([]byte(text), &probe); err != nil {
+		return false
+	}
+	found := false
+	for _, outbound := range probe.Outbounds {
+		if outbound.Protocol != "" {
+			return false
+		}
+		if outbound.Type != "" {
+			found = true
+		}
+	}
+	return found
+}
+
+func tryToParseSingBoxJSON(text string, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	var singBox SingBoxConfig
+	if err := json.Unmarshal([]byte(text), &singBox); err != nil {
+		return nil, err
+	}
+	config, skipped := singBox.toXrayConfig(diagnostics)
+	if len(config.OutboundConfigs) == 0 {
+		if len(skipped) > 0 {
+			return nil, fmt.Errorf("no valid outbound found: %w", errors.Join(skipped...))
+		}
+		return nil, fmt.Errorf("no valid outbound found")
+	}
+	return config, nil
+}
+
+// toXrayConfig converts every proxy outbound and returns one error per
+// skipped entry, naming its index and tag.
+func (singBox SingBoxConfig) toXrayConfig(diagnostics *shareDiagnostics) (*conf.Config, []error) {
+	outbounds := make([]conf.OutboundDetourConfig, 0, len(singBox.Outbounds))
+	var skipped []error
+	for index, proxy := range singBox.Outbounds {
+		origin := indexOrigin(index, redactEntry(proxy.Tag, proxy.Type, proxy.Server, proxy.ServerPort))
+		outbound, err := proxy.outbound()
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("outbound %d (%q): %w", index, proxy.Tag, err))
+			diagnostics.drop(origin, ShareDiagnosticStageParse, err)
+			continue
+		}
+		outbounds = append(outbounds, *outbound)
+		diagnostics.keep(origin)
+	}
+	return &conf.Config{OutboundConfigs: outbounds}, skipped
+}
+
+func (proxy SingBoxOutbound) outbound() (*conf.OutboundDetourConfig, error) {
+	protocol, ok := singBoxProtocols[proxy.Type]
+	if !ok {
+		switch proxy.Type {
+		case "direct", "block", "dns", "selector", "urltest":
+			return nil, fmt.Errorf("sing-box %s outbound is not a proxy", proxy.Type)
+		}
+		return nil, fmt.Errorf("unsupported sing-box outbound type: %s", proxy.Type)
+	}
+	if proxy.Detour != "" {
+		return nil, fmt.Errorf("sing-box detour is not supported")
+	}
+	if proxy.Multiplex != nil && proxy.Multiplex.Enabled {
+		return nil, fmt.Errorf("sing-box multiplex has no Xray equivalent")
+	}
+
+	outbound := &conf.OutboundDetourConfig{}
+	outbound.Protocol = protocol
+	setOutboundName(outbound, proxy.Tag)
+
+	var settings any
+	switch proxy.Type {
+	case "vless":
+		settings = conf.VLessOutboundConfig{
+			Address:    parseAddress(proxy.Server),
+			Port:       proxy.ServerPort,
+			Id:         proxy.UUID,
+			Flow:       proxy.Flow,
+			Encryption: "none",
+		}
+	case "vmess":
+		if proxy.AlterID != 0 {
+			return nil, fmt.Errorf("vmess alter_id %d is not supported, only AEAD", proxy.AlterID)
+		}
+		settings = conf.VMessOutboundConfig{
+			Address:  parseAddress(proxy.Server),
+			Port:     proxy.ServerPort,
+			ID:       proxy.UUID,
+			Security: proxy.Security,
+		}
+	case "trojan":
+		settings = conf.TrojanClientConfig{
+			Address:  parseAddress(proxy.Server),
+			Port:     proxy.ServerPort,
+			Password: proxy.Password,
+		}
+	case "shadowsocks":
+		if proxy.UDPOverTCP != nil && proxy.UDPOverTCP.Enabled {
+			return nil, fmt.Errorf("shadowsocks udp_over_tcp has no Xray equivalent")
+		}
+		settings = conf.ShadowsocksClientConfig{
+			Address:  parseAddress(proxy.Server),
+			Port:     proxy.ServerPort,
+			Cipher:   proxy.Method,
+			Password: proxy.Password,
+		}
+	case "hysteria2":
+		settings = conf.HysteriaClientConfig{
+			Version: 2,
+			Address: parseAddress(proxy.Server),
+			Port:    proxy.ServerPort,
+		}
+	case "socks":
+		if proxy.Version != "" && proxy.Version != "5" {
+			return nil, fmt.Errorf("unsupported socks version: %s", proxy.Version)
+		}
+		settings = conf.SocksClientConfig{
+			Address:  parseAddress(proxy.Server),
+			Port:     proxy.ServerPort,
+			Username: proxy.Username,
+			Password: proxy.Password,
+		}
+	case "http":
+		if proxy.Path != "" {
+			return nil, fmt.Errorf("http proxy path is not supported")
+		}
+		httpSettings := conf.HTTPClientConfig{
+			Address:  parseAddress(proxy.Server),
+			Port:     proxy.ServerPort,
+			Username: proxy.Username,
+			Password: proxy.Password,
+		}
+		if len(proxy.Headers) > 0 {
+			httpSettings.Headers = make(map[string]string, len(proxy.Headers))
+			for name, values := range proxy.Headers {
+				httpSettings.Headers[name] = strings.Join(values, ", ")
+			}
+		}
+		settings = httpSettings
+	}
+
+	settingsRawMessage, err := convertJsonToRawMessage(settings)
+	if err != nil {
+		return nil, err
+	}
+	outbound.Settings = &settingsRawMessage
+
+	streamSettings, err := proxy.streamSettings()
+	if err != nil {
+		return nil, err
+	}
+	outbound.StreamSetting = streamSettings
+	return outbound, nil
+}
+
+func (proxy SingBoxOutbound) streamSettings() (*conf.StreamConfig, error) {
+	if proxy.Type == "shadowsocks" {
+		if proxy.Plugin == "" {
+			return nil, nil
+		}
+		if proxy.Transport != nil || (proxy.TLS != nil && proxy.TLS.Enabled) {
+			return nil, fmt.Errorf("shadowsocks supports plugin only, not tls or transport")
+		}
+		plugin := proxy.Plugin
+		if proxy.PluginOpts != "" {
+			plugin += ";" + proxy.PluginOpts
+		}
+		return shadowsocksPluginStreamSettings(plugin)
+	}
+	if proxy.Type == "hysteria2" {
+		return proxy.hysteria2StreamSettings()
+	}
+
+	fields := shareTransportFields{Network: "raw"}
+	var headers map[string]string
+	if transport := proxy.Transport; transport != nil {
+		network, ok := singBoxTransports[transport.Type]
+		if !ok {
+			return nil, fmt.Errorf("unsupported sing-box transport: %s", transport.Type)
+		}
+		fields.Network = network
+		fields.Path = transport.Path
+		fields.Host = transport.Host.first()
+		fields.GrpcServiceName = transport.ServiceName
+
+		for name, values := range transport.Headers {
+			if strings.EqualFold(name, "Host") {
+				if fields.Host == "" {
+					fields.Host = values.first()
+				}
+				continue
+			}
+			if headers == nil {
+				headers = make(map[string]string)
+			}
+			headers[name] = strings.Join(values, ", ")
+		}
+
+		if transport.MaxEarlyData > 0 {
+			if network != "ws" {
+				return nil, fmt.Errorf("sing-box early data is supported on ws only")
+			}
+			if transport.EarlyDataHeaderName != singBoxEarlyDataHeader {
+				return nil, fmt.Errorf(
+					"ws early data in the path has no Xray equivalent, only %s",
+					singBoxEarlyDataHeader,
+				)
+			}
+			separator := "?"
+			if strings.Contains(fields.Path, "?") {
+				separator = "&"
+			}
+			fields.Path += separator + "ed=" + strconv.FormatUint(uint64(transport.MaxEarlyData), 10)
+		}
+	}
+
+	streamSettings, err := buildStreamFromTransportFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case streamSettings.WSSettings != nil:
+		streamSettings.WSSettings.Headers = headers
+	case streamSettings.HTTPUPGRADESettings != nil:
+		streamSettings.HTTPUPGRADESettings.Headers = headers
+	}
+
+	if err := proxy.TLS.parseSecurity(streamSettings); err != nil {
+		return nil, err
+	}
+	return streamSettings, nil
+}
+
+func (proxy SingBoxOutbound) hysteria2StreamSettings() (*conf.StreamConfig, error) {
+	if proxy.Transport != nil {
+		return nil, fmt.Errorf("hysteria2 does not support transport")
+	}
+	streamSettings := &conf.StreamConfig{}
+	streamSettings.Network = new(conf.TransportProtocol("hysteria"))
+	streamSettings.HysteriaSettings = &conf.HysteriaConfig{
+		Version: 2,
+		Auth:    proxy.Password,
+	}
+
+	var up, down string
+	if proxy.UpMbps > 0 {
+		up = strconv.Itoa(proxy.UpMbps) + " mbps"
+	}
+	if proxy.DownMbps > 0 {
+		down = strconv.Itoa(proxy.DownMbps) + " mbps"
+	}
+	// sing-box writes port ranges as "2080:3000".
+	ports := make([]string, 0, len(proxy.ServerPorts))
+	for _, port := range proxy.ServerPorts {
+		ports = append(ports, strings.ReplaceAll(port, ":", "-"))
+	}
+	var hopPtr *conf.Int32Range
+	if proxy.HopInterval != "" {
+		interval, err := time.ParseDuration(proxy.HopInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hysteria2 hop_interval: %w", err)
+		}
+		hopPtr = hopIntervalSeconds(int32(interval / time.Second))
+	}
+	var obfsType, obfsPassword string
+	if proxy.Obfs != nil {
+		if proxy.Obfs.Type != "salamander" {
+			return nil, fmt.Errorf("unsupported hysteria2 obfs: %s", proxy.Obfs.Type)
+		}
+		obfsType, obfsPassword = proxy.Obfs.Type, proxy.Obfs.Password
+	}
+	finalMask, err := buildHy2FinalMask(up, down, strings.Join(ports, ","), hopPtr, obfsType, obfsPassword)
+	if err != nil {
+		return nil, err
+	}
+	streamSettings.FinalMask = finalMask
+
+	tls := SingBoxTLS{}
+	if proxy.TLS != nil {
+		tls = *proxy.TLS
+	}
+	// Hysteria2 always runs over TLS.
+	tls.Enabled = true
+	if err := tls.parseSecurity(streamSettings); err != nil {
+		return nil, err
+	}
+	return streamSettings, nil
+}
+
+func (tls *SingBoxTLS) parseSecurity(streamSettings *conf.StreamConfig) error {
+	if tls == nil || !tls.Enabled {
+		streamSettings.Security = "none"
+		return nil
+	}
+
+	var fingerprint string
+	if tls.UTLS != nil && tls.UTLS.Enabled {
+		fingerprint = tls.UTLS.Fingerprint
+		if fingerprint == "" {
+			fingerprint = "chrome"
+		}
+	}
+
+	if tls.Reality != nil && tls.Reality.Enabled {
+		if fingerprint == "" {
+			// sing-box requires uTLS for REALITY and defaults it to chrome.
+			fingerprint = "chrome"
+		}
+		streamSettings.Security = "reality"
+		streamSettings.REALITYSettings = &conf.REALITYConfig{
+			Fingerprint: fingerprint,
+			ServerName:  tls.ServerName,
+			Password:    tls.Reality.PublicKey,
+			PublicKey:   tls.Reality.PublicKey,
+			ShortId:     tls.Reality.ShortID,
+		}
+		return nil
+	}
+
+	if tls.Insecure {
+		// Xray removed allowInsecure in favour of certificate pinning.
+		return fmt.Errorf("tls insecure has no Xray equivalent")
+	}
+	tlsSettings := &conf.TLSConfig{}
+	tlsSettings.ServerName = tls.ServerName
+	tlsSettings.Fingerprint = fingerprint
+	if len(tls.ALPN) > 0 {
+		tlsSettings.ALPN = new(conf.StringList(tls.ALPN))
+	}
+	if tls.ECH != nil && tls.ECH.Enabled {
+		echConfigList, err := decodeSingBoxECHConfig(tls.ECH.Config)
+		if err != nil {
+			return err
+		}
+		tlsSettings.ECHConfigList = echConfigList
+	}
+	streamSettings.Security = "tls"
+	streamSettings.TLSSettings = tlsSettings
+	return nil
+}
+
+// decodeSingBoxECHConfig turns the PEM "ECH CONFIGS" block sing-box stores
+// line by line into the base64 ECHConfigList Xray expects.
+func decodeSingBoxECHConfig(lines []string) (string, error) {
+	if len(lines) == 0 {
+		return "", fmt.Errorf("sing-box ech without config has no Xray equivalent")
+	}
+	block, _ := pem.Decode([]byte(strings.Join(lines, "\n")))
+	if block == nil || block.Type != "ECH CONFIGS" {
+		return "", fmt.Errorf("invalid sing-box ech config")
+	}
+	return base64.StdEncoding.EncodeToString(block.Bytes), nil
+}
+
+// encodeSingBoxECHConfig is the reverse of decodeSingBoxECHConfig.
+func encodeSingBoxECHConfig(echConfigList string) ([]string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(echConfigList)
+	if err != nil {
+		return nil, fmt.Errorf("ech config %q is not a base64 ECHConfigList", echConfigList)
+	}
+	block := pem.EncodeToMemory(&pem.Block{Type: "ECH CONFIGS", Bytes: decoded})
+	return strings.Split(strings.TrimSpace(string(block)), "\n"), nil
+}
@@ -0,0 +1,324 @@
+package share
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const singBoxOutboundsJSON = `{
+  "log": {"level": "info"},
+  "outbounds": [
+    {"type": "selector", "tag": "select", "outbounds": ["vless-reality", "vmess-ws"]},
+    {
+      "type": "vless", "tag": "vless-reality",
+      "server": "reality.example.com", "server_port": 443,
+      "uuid": "bf000d23-0752-40b4-affe-68f7707a9661", "flow": "xtls-rprx-vision",
+      "tls": {
+        "enabled": true, "server_name": "www.microsoft.com",
+        "utls": {"enabled": true, "fingerprint": "firefox"},
+        "reality": {"enabled": true, "public_key": "jNXHt1yRo0vDuchQlIP6Z0ZvjT3KtzVI-T4E7RoLJS0", "short_id": "0123abcd"}
+      }
+    },
+    {
+      "type": "vmess", "tag": "vmess-ws",
+      "server": "ws.example.com", "server_port": 443,
+      "uuid": "bf000d23-0752-40b4-affe-68f7707a9661", "security": "auto",
+      "tls": {"enabled": true, "server_name": "ws.example.com", "alpn": "http/1.1"},
+      "transport": {
+        "type": "ws", "path": "/ray",
+        "headers": {"Host": "cdn.example.com", "User-Agent": "curl"},
+        "max_early_data": 2048, "early_data_header_name": "Sec-WebSocket-Protocol"
+      }
+    },
+    {
+      "type": "trojan", "tag": "trojan-grpc",
+      "server": "grpc.example.com", "server_port": 443, "password": "secret",
+      "tls": {"enabled": true, "server_name": "grpc.example.com"},
+      "transport": {"type": "grpc", "service_name": "TunService"}
+    },
+    {
+      "type": "shadowsocks", "tag": "ss-obfs",
+      "server": "ss.example.com", "server_port": 8388,
+      "method": "aes-128-gcm", "password": "pass",
+      "plugin": "obfs-local", "plugin_opts": "obfs=http;obfs-host=bing.com"
+    },
+    {
+      "type": "hysteria2", "tag": "hy2",
+      "server": "hy2.example.com", "server_port": 443,
+      "server_ports": ["20000:30000", "40000"], "hop_interval": "30s",
+      "up_mbps": 100, "down_mbps": 200,
+      "obfs": {"type": "salamander", "password": "obfs-pass"},
+      "password": "auth",
+      "tls": {"enabled": true, "server_name": "hy2.example.com"}
+    },
+    {"type": "socks", "tag": "socks", "server": "127.0.0.1", "server_port": 1080, "version": "5", "username": "u", "password": "p"},
+    {
+      "type": "http", "tag": "https-proxy",
+      "server": "proxy.example.com", "server_port": 443, "username": "u", "password": "p",
+      "tls": {"enabled": true}
+    },
+    {"type": "tuic", "tag": "tuic", "server": "tuic.example.com", "server_port": 443},
+    {"type": "vmess", "tag": "vmess-mux", "server": "mux.example.com", "server_port": 443, "uuid": "bf000d23-0752-40b4-affe-68f7707a9661", "multiplex": {"enabled": true}},
+    {"type": "direct", "tag": "direct"}
+  ]
+}`
+
+func singBoxOutboundsByName(t *testing.T, config *conf.Config) map[string]conf.OutboundDetourConfig {
+	t.Helper()
+	outbounds := make(map[string]conf.OutboundDetourConfig, len(config.OutboundConfigs))
+	for _, outbound := range config.OutboundConfigs {
+		outbounds[getOutboundName(outbound)] = outbound
+	}
+	return outbounds
+}
+
+func TestIsSingBoxConfig(t *testing.T) {
+	assert.True(t, isSingBoxConfig(singBoxOutboundsJSON))
+	assert.False(t, isSingBoxConfig(`{"outbounds":[{"protocol":"freedom"}]}`))
+	assert.False(t, isSingBoxConfig(`{"outbounds":[{"type":"direct"},{"protocol":"freedom"}]}`))
+	assert.False(t, isSingBoxConfig(`{"outbounds":[]}`))
+	assert.False(t, isSingBoxConfig(`{"outbounds":`))
+}
+
+func TestConvertShareLinksToXrayJson_SingBox(t *testing.T) {
+	config, err := ConvertShareLinksToXrayJson(singBoxOutboundsJSON)
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 7)
+	outbounds := singBoxOutboundsByName(t, config)
+
+	vless := outbounds["vless-reality"]
+	assert.Equal(t, "vless", vless.Protocol)
+	var vlessSettings conf.VLessOutboundConfig
+	require.NoError(t, json.Unmarshal(*vless.Settings, &vlessSettings))
+	assert.Equal(t, "xtls-rprx-vision", vlessSettings.Flow)
+	assert.Equal(t, "none", vlessSettings.Encryption)
+	require.NotNil(t, vless.StreamSetting)
+	assert.Equal(t, "reality", vless.StreamSetting.Security)
+	require.NotNil(t, vless.StreamSetting.REALITYSettings)
+	assert.Equal(t, "firefox", vless.StreamSetting.REALITYSettings.Fingerprint)
+	assert.Equal(t, "www.microsoft.com", vless.StreamSetting.REALITYSettings.ServerName)
+	assert.Equal(t, "0123abcd", vless.StreamSetting.REALITYSettings.ShortId)
+
+	vmess := outbounds["vmess-ws"]
+	require.NotNil(t, vmess.StreamSetting)
+	require.NotNil(t, vmess.StreamSetting.WSSettings)
+	assert.Equal(t, "/ray?ed=2048", vmess.StreamSetting.WSSettings.Path)
+	assert.Equal(t, "cdn.example.com", vmess.StreamSetting.WSSettings.Host)
+	assert.Equal(t, map[string]string{"User-Agent": "curl"}, vmess.StreamSetting.WSSettings.Headers)
+	assert.Equal(t, "tls", vmess.StreamSetting.Security)
+	require.NotNil(t, vmess.StreamSetting.TLSSettings)
+	require.NotNil(t, vmess.StreamSetting.TLSSettings.ALPN)
+	assert.Equal(t, conf.StringList{"http/1.1"}, *vmess.StreamSetting.TLSSettings.ALPN)
+
+	trojan := outbounds["trojan-grpc"]
+	require.NotNil(t, trojan.StreamSetting)
+	require.NotNil(t, trojan.StreamSetting.GRPCSettings)
+	assert.Equal(t, "TunService", trojan.StreamSetting.GRPCSettings.ServiceName)
+	assert.Equal(t, "grpc.example.com", trojan.StreamSetting.TLSSettings.ServerName)
+
+	ss := outbounds["ss-obfs"]
+	require.NotNil(t, ss.StreamSetting)
+	require.NotNil(t, ss.StreamSetting.RAWSettings)
+	assert.Contains(t, string(ss.StreamSetting.RAWSettings.HeaderConfig), "bing.com")
+
+	hy2 := outbounds["hy2"]
+	require.NotNil(t, hy2.StreamSetting)
+	assert.Equal(t, "tls", hy2.StreamSetting.Security)
+	require.NotNil(t, hy2.StreamSetting.HysteriaSettings)
+	assert.Equal(t, "auth", hy2.StreamSetting.HysteriaSettings.Auth)
+	require.NotNil(t, hy2.StreamSetting.FinalMask)
+	quicParams := hy2.StreamSetting.FinalMask.QuicParams
+	require.NotNil(t, quicParams)
+	assert.Equal(t, conf.Bandwidth("100 mbps"), quicParams.BrutalUp)
+	assert.Equal(t, conf.Bandwidth("200 mbps"), quicParams.BrutalDown)
+	assert.Equal(t, "20000-30000,40000", quicParams.UdpHop.PortList.String())
+	assert.Equal(t, int32(30), quicParams.UdpHop.Interval.From)
+	require.Len(t, hy2.StreamSetting.FinalMask.Udp, 1)
+	assert.Equal(t, "salamander", hy2.StreamSetting.FinalMask.Udp[0].Type)
+
+	socks := outbounds["socks"]
+	assert.Equal(t, "socks", socks.Protocol)
+	assert.Equal(t, "none", socks.StreamSetting.Security)
+
+	httpProxy := outbounds["https-proxy"]
+	assert.Equal(t, "http", httpProxy.Protocol)
+	assert.Equal(t, "tls", httpProxy.StreamSetting.Security)
+}
+
+func TestSingBoxConfig_SkippedEntriesHaveReasons(t *testing.T) {
+	var singBox SingBoxConfig
+	require.NoError(t, json.Unmarshal([]byte(singBoxOutboundsJSON), &singBox))
+	config, skipped := singBox.toXrayConfig(nil)
+	assert.Len(t, config.OutboundConfigs, 7)
+	require.Len(t, skipped, 4)
+	assert.ErrorContains(t, skipped[0], `outbound 0 ("select"): sing-box selector outbound is not a proxy`)
+	assert.ErrorContains(t, skipped[1], "unsupported sing-box outbound type: tuic")
+	assert.ErrorContains(t, skipped[2], "multiplex has no Xray equivalent")
+	assert.ErrorContains(t, skipped[3], "sing-box direct outbound is not a proxy")
+}
+
+func TestSingBoxOutbound_UnsupportedFields(t *testing.T) {
+	cases := map[string]string{
+		"h2 transport":     `{"type":"vless","server":"h","server_port":443,"uuid":"u","transport":{"type":"http"}}`,
+		"path early data":  `{"type":"vless","server":"h","server_port":443,"uuid":"u","transport":{"type":"ws","max_early_data":2048}}`,
+		"vmess alter_id":   `{"type":"vmess","server":"h","server_port":443,"uuid":"u","alter_id":64}`,
+		"ss udp_over_tcp":  `{"type":"shadowsocks","server":"h","server_port":443,"method":"aes-128-gcm","password":"p","udp_over_tcp":true}`,
+		"detour":           `{"type":"trojan","server":"h","server_port":443,"password":"p","detour":"other"}`,
+		"hy2 obfs":         `{"type":"hysteria2","server":"h","server_port":443,"password":"p","obfs":{"type":"other"}}`,
+		"tls insecure":     `{"type":"trojan","server":"h","server_port":443,"password":"p","tls":{"enabled":true,"insecure":true}}`,
+		"ech without keys": `{"type":"trojan","server":"h","server_port":443,"password":"p","tls":{"enabled":true,"ech":{"enabled":true}}}`,
+	}
+	for name, text := range cases {
+		t.Run(name, func(t *testing.T) {
+			var proxy SingBoxOutbound
+			require.NoError(t, json.Unmarshal([]byte(text), &proxy))
+			_, err := proxy.outbound()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSingBoxOutbound_ECHConfig(t *testing.T) {
+	echConfigList := []byte{0x00, 0x04, 0xfe, 0x0d, 0x00, 0x00}
+	block := pem.EncodeToMemory(&pem.Block{Type: "ECH CONFIGS", Bytes: echConfigList})
+	lines := strings.Split(strings.TrimSpace(string(block)), "\n")
+
+	proxy := SingBoxOutbound{
+		Type:     "trojan",
+		Server:   "example.com",
+		Password: "p",
+		TLS: &SingBoxTLS{
+			Enabled: true,
+			ECH:     &SingBoxECH{Enabled: true, Config: lines},
+		},
+	}
+	outbound, err := proxy.outbound()
+	require.NoError(t, err)
+	require.NotNil(t, outbound.StreamSetting.TLSSettings)
+	assert.Equal(t,
+		base64.StdEncoding.EncodeToString(echConfigList),
+		outbound.StreamSetting.TLSSettings.ECHConfigList,
+	)
+}
+
+func TestConvertShareLinksToXrayJson_SingBoxNoValidOutbound(t *testing.T) {
+	_, err := ConvertShareLinksToXrayJson(`{"outbounds":[{"type":"tuic","tag":"a"},{"type":"direct"}]}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported sing-box outbound type: tuic")
+	assert.Contains(t, err.Error(), "sing-box direct outbound is not a proxy")
+}
+
+func TestConvertXrayJsonToSingBox_RoundTrip(t *testing.T) {
+	imported, err := ConvertShareLinksToXrayJson(singBoxOutboundsJSON)
+	require.NoError(t, err)
+	xrayBytes, err := json.Marshal(imported)
+	require.NoError(t, err)
+
+	singBox, warnings, err := ConvertXrayJsonToSingBox(xrayBytes)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	require.Len(t, singBox.Outbounds, 7)
+
+	var original SingBoxConfig
+	require.NoError(t, json.Unmarshal([]byte(singBoxOutboundsJSON), &original))
+	exported := make(map[string]SingBoxOutbound, len(singBox.Outbounds))
+	for _, proxy := range singBox.Outbounds {
+		exported[proxy.Tag] = proxy
+	}
+	for _, proxy := range original.Outbounds {
+		if _, err := proxy.outbound(); err != nil {
+			continue
+		}
+		got, ok := exported[proxy.Tag]
+		require.True(t, ok, proxy.Tag)
+		if proxy.Type == "vless" {
+			proxy.PacketEncoding = "xudp"
+		}
+		if proxy.Type == "hysteria2" {
+			proxy.TLS.Enabled = true
+		}
+		assert.Equal(t, proxy, got, proxy.Tag)
+	}
+
+	singBoxBytes, err := json.Marshal(singBox)
+	require.NoError(t, err)
+	reimported, err := ConvertShareLinksToXrayJson(string(singBoxBytes))
+	require.NoError(t, err)
+	assert.Equal(t, imported.OutboundConfigs, reimported.OutboundConfigs)
+}
+
+func TestConvertXrayJsonToSingBox_Warnings(t *testing.T) {
+	xrayJSON := `{"outbounds":[
+		{"protocol":"freedom","tag":"direct"},
+		{"protocol":"wireguard","tag":"wg","settings":{"secretKey":"k","peers":[{"endpoint":"wg.example.com:51820","publicKey":"p"}]}},
+		{"protocol":"vmess","tag":"mux","mux":{"enabled":true},"settings":{"address":"vmess.example.com","port":443,"id":"bf000d23-0752-40b4-affe-68f7707a9661"},
+		 "streamSettings":{"network":"grpc","security":"reality","grpcSettings":{"serviceName":"s","multiMode":true},
+		 "realitySettings":{"serverName":"www.microsoft.com","publicKey":"jNXHt1yRo0vDuchQlIP6Z0ZvjT3KtzVI-T4E7RoLJS0","spiderX":"/"}}},
+		{"protocol":"trojan","tag":"kcp","settings":{"address":"kcp.example.com","port":443,"password":"p"},"streamSettings":{"network":"kcp"}}
+	]}`
+	singBox, warnings, err := ConvertXrayJsonToSingBox([]byte(xrayJSON))
+	require.NoError(t, err)
+	require.Len(t, singBox.Outbounds, 1)
+
+	proxy := singBox.Outbounds[0]
+	assert.Equal(t, "mux", proxy.Tag)
+	require.NotNil(t, proxy.TLS)
+	require.NotNil(t, proxy.TLS.UTLS)
+	assert.Equal(t, "chrome", proxy.TLS.UTLS.Fingerprint)
+	assert.Equal(t, "s", proxy.Transport.ServiceName)
+
+	messages := make([]string, 0, len(warnings))
+	for _, warning := range warnings {
+		messages = append(messages, warning.Message)
+	}
+	assert.Equal(t, []string{
+		`skipped: unsupported outbound protocol "freedom"`,
+		"skipped: wireguard is a sing-box endpoint, not an outbound",
+		"mux has no sing-box equivalent",
+		"grpc multiMode has no sing-box equivalent",
+		"reality spiderX has no sing-box equivalent",
+		"skipped: unsupported network for sing-box: kcp",
+	}, messages)
+	assert.Equal(t, 3, warnings[len(warnings)-1].Index)
+	assert.Equal(t, "kcp", warnings[len(warnings)-1].Tag)
+}
+
+func TestConvertXrayJsonToSingBox_ECHAndShadowsocksPlugin(t *testing.T) {
+	echConfigList := base64.StdEncoding.EncodeToString([]byte{0x00, 0x04, 0xfe, 0x0d, 0x00, 0x00})
+	xrayJSON := `{"outbounds":[
+		{"protocol":"trojan","settings":{"address":"ech.example.com","port":443,"password":"p"},
+		 "streamSettings":{"network":"ws","security":"tls","wsSettings":{"path":"/ws?ed=2048","host":"cdn.example.com"},
+		 "tlsSettings":{"serverName":"ech.example.com","echConfigList":"` + echConfigList + `"}}},
+		{"protocol":"shadowsocks","settings":{"address":"ss.example.com","port":8388,"method":"aes-128-gcm","password":"p"},
+		 "streamSettings":{"network":"ws","security":"tls","wsSettings":{"path":"/a;b","host":"ss.example.com"}}}
+	]}`
+	singBox, warnings, err := ConvertXrayJsonToSingBox([]byte(xrayJSON))
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	require.Len(t, singBox.Outbounds, 2)
+
+	trojan := singBox.Outbounds[0]
+	require.NotNil(t, trojan.Transport)
+	assert.Equal(t, "/ws", trojan.Transport.Path)
+	assert.Equal(t, uint32(2048), trojan.Transport.MaxEarlyData)
+	assert.Equal(t, singBoxEarlyDataHeader, trojan.Transport.EarlyDataHeaderName)
+	assert.Equal(t, SingBoxListable{"cdn.example.com"}, trojan.Transport.Headers["Host"])
+	require.NotNil(t, trojan.TLS.ECH)
+	decoded, err := decodeSingBoxECHConfig(trojan.TLS.ECH.Config)
+	require.NoError(t, err)
+	assert.Equal(t, echConfigList, decoded)
+
+	ss := singBox.Outbounds[1]
+	assert.Equal(t, "v2ray-plugin", ss.Plugin)
+	streamSettings, err := shadowsocksPluginStreamSettings(ss.Plugin + ";" + ss.PluginOpts)
+	require.NoError(t, err)
+	assert.Equal(t, "/a;b", streamSettings.WSSettings.Path)
+	assert.Equal(t, "tls", streamSettings.Security)
+}
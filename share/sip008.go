@@ -0,0 +1,159 @@
+package share
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// https://shadowsocks.org/doc/sip008.html
+
+type SIP008Config struct {
+	Version        int            `json:"version"`
+	Servers        []SIP008Server `json:"servers"`
+	BytesUsed      *uint64        `json:"bytes_used,omitempty"`
+	BytesRemaining *uint64        `json:"bytes_remaining,omitempty"`
+}
+
+type SIP008Server struct {
+	ID         string `json:"id,omitempty"`
+	Remarks    string `json:"remarks,omitempty"`
+	Server     string `json:"server"`
+	ServerPort uint16 `json:"server_port"`
+	Password   string `json:"password"`
+	Method     string `json:"method"`
+	Plugin     string `json:"plugin,omitempty"`
+	PluginOpts string `json:"plugin_opts,omitempty"`
+}
+
+// isSIP008Config reports whether text is a SIP008 document: a "servers"
+// list in place of Xray and sing-box "outbounds".
+func isSIP008Config(text string) bool {
+	var probe struct {
+		Servers   []json.RawMessage `json:"servers"`
+		Outbounds []json.RawMessage `json:"outbounds"`
+	}
+	if err := json.Unmarshal([]byte(text), &probe); err != nil {
+		return false
+	}
+	return len(probe.Servers) > 0 && probe.Outbounds == nil
+}
+
+func tryToParseSIP008JSON(text string, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	var sip008 SIP008Config
+	if err := json.Unmarshal([]byte(text), &sip008); err != nil {
+		return nil, err
+	}
+	if sip008.Version != 0 && sip008.Version != 1 {
+		return nil, fmt.Errorf("unsupported SIP008 version %d", sip008.Version)
+	}
+	outbounds := make([]conf.OutboundDetourConfig, 0, len(sip008.Servers))
+	var skipped []error
+	for index, server := range sip008.Servers {
+		origin := indexOrigin(index, redactEntry(server.Remarks, "shadowsocks", server.Server, server.ServerPort))
+		outbound, err := server.outbound()
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("server %d (%q): %w", index, server.Remarks, err))
+			diagnostics.drop(origin, ShareDiagnosticStageParse, err)
+			continue
+		}
+		outbounds = append(outbounds, *outbound)
+		diagnostics.keep(origin)
+	}
+	if len(outbounds) == 0 {
+		if len(skipped) > 0 {
+			return nil, fmt.Errorf("no valid outbound found: %w", errors.Join(skipped...))
+		}
+		return nil, fmt.Errorf("no valid outbound found")
+	}
+	return &conf.Config{OutboundConfigs: outbounds}, nil
+}
+
+func (server SIP008Server) outbound() (*conf.OutboundDetourConfig, error) {
+	if server.Server == "" || server.ServerPort == 0 {
+		return nil, fmt.Errorf("missing server address")
+	}
+	if server.Method == "" {
+		return nil, fmt.Errorf("missing shadowsocks cipher")
+	}
+
+	outbound := &conf.OutboundDetourConfig{}
+	outbound.Protocol = "shadowsocks"
+	setOutboundName(outbound, server.Remarks)
+
+	settings := &conf.ShadowsocksClientConfig{}
+	settings.Address = parseAddress(server.Server)
+	settings.Port = server.ServerPort
+	settings.Cipher = server.Method
+	settings.Password = server.Password
+
+	settingsRawMessage, err := convertJsonToRawMessage(settings)
+	if err != nil {
+		return nil, err
+	}
+	outbound.Settings = &settingsRawMessage
+
+	if server.Plugin != "" {
+		plugin := server.Plugin
+		if server.PluginOpts != "" {
+			plugin += ";" + server.PluginOpts
+		}
+		streamSettings, err := shadowsocksPluginStreamSettings(plugin)
+		if err != nil {
+			return nil, err
+		}
+		outbound.StreamSetting = streamSettings
+	}
+	return outbound, nil
+}
+
+// ConvertXrayJsonToSIP008 converts the shadowsocks outbounds of an Xray
+// config to a SIP008 document. Other protocols, and shadowsocks transports
+// no SIP003 plugin can express, are skipped.
+func ConvertXrayJsonToSIP008(xrayBytes []byte) (*SIP008Config, error) {
+	var xray conf.Config
+	if err := json.Unmarshal(xrayBytes, &xray); err != nil {
+		return nil, err
+	}
+
+	sip008 := &SIP008Config{Version: 1}
+	for _, outbound := range xray.OutboundConfigs {
+		server, err := sip008Server(outbound)
+		if err != nil {
+			continue
+		}
+		sip008.Servers = append(sip008.Servers, *server)
+	}
+	if len(sip008.Servers) == 0 {
+		return nil, fmt.Errorf("no valid shadowsocks outbounds")
+	}
+	return sip008, nil
+}
+
+func sip008Server(outbound conf.OutboundDetourConfig) (*SIP008Server, error) {
+	if outbound.Protocol != "shadowsocks" {
+		return nil, fmt.Errorf("SIP008 supports shadowsocks only, not %s", outbound.Protocol)
+	}
+	settings, err := decodeOutboundSettings[conf.ShadowsocksClientConfig](outbound)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Address == nil || settings.Address.Address == nil {
+		return nil, fmt.Errorf("shadowsocks outbound has no server address")
+	}
+
+	server := &SIP008Server{
+		Remarks:    getOutboundName(outbound),
+		Server:     settings.Address.String(),
+		ServerPort: settings.Port,
+		Password:   settings.Password,
+		Method:     settings.Cipher,
+	}
+	server.Plugin, server.PluginOpts, err = shadowsocksPluginFromStream(outbound.StreamSetting)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
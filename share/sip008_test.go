@@ -0,0 +1,120 @@
+package share
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const sip008JSON = `{
+  "version": 1,
+  "servers": [
+    {
+      "id": "27b8a625-4f4b-4428-9f0f-8a2317db7c79",
+      "remarks": "Plain", "server": "ss1.example.com", "server_port": 8388,
+      "password": "pass", "method": "chacha20-ietf-poly1305"
+    },
+    {
+      "remarks": "Obfs", "server": "ss2.example.com", "server_port": 8389,
+      "password": "pass", "method": "aes-128-gcm",
+      "plugin": "obfs-local", "plugin_opts": "obfs=http;obfs-host=bing.com"
+    },
+    {
+      "remarks": "WS", "server": "ss3.example.com", "server_port": 443,
+      "password": "pass", "method": "aes-256-gcm",
+      "plugin": "v2ray-plugin", "plugin_opts": "mode=websocket;host=ss3.example.com;path=/ws;tls"
+    },
+    {
+      "remarks": "Kcptun", "server": "ss4.example.com", "server_port": 8390,
+      "password": "pass", "method": "aes-256-gcm", "plugin": "kcptun"
+    }
+  ],
+  "bytes_used": 274877906944,
+  "bytes_remaining": 824633720832
+}`
+
+func TestIsSIP008Config(t *testing.T) {
+	assert.True(t, isSIP008Config(sip008JSON))
+	assert.False(t, isSIP008Config(`{"servers":[]}`))
+	assert.False(t, isSIP008Config(`{"servers":[{}],"outbounds":[]}`))
+	assert.False(t, isSIP008Config(`{"outbounds":[{"protocol":"freedom"}]}`))
+}
+
+func TestConvertShareLinksToXrayJson_SIP008(t *testing.T) {
+	config, err := ConvertShareLinksToXrayJson(sip008JSON)
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 3)
+
+	plain := config.OutboundConfigs[0]
+	assert.Equal(t, "shadowsocks", plain.Protocol)
+	assert.Equal(t, "Plain", getOutboundName(plain))
+	assert.Nil(t, plain.StreamSetting)
+	var settings conf.ShadowsocksClientConfig
+	require.NoError(t, json.Unmarshal(*plain.Settings, &settings))
+	assert.Equal(t, "ss1.example.com", settings.Address.String())
+	assert.Equal(t, uint16(8388), settings.Port)
+	assert.Equal(t, "chacha20-ietf-poly1305", settings.Cipher)
+	assert.Equal(t, "pass", settings.Password)
+
+	obfs := config.OutboundConfigs[1]
+	require.NotNil(t, obfs.StreamSetting)
+	require.NotNil(t, obfs.StreamSetting.RAWSettings)
+	assert.Contains(t, string(obfs.StreamSetting.RAWSettings.HeaderConfig), "bing.com")
+
+	ws := config.OutboundConfigs[2]
+	require.NotNil(t, ws.StreamSetting)
+	require.NotNil(t, ws.StreamSetting.WSSettings)
+	assert.Equal(t, "/ws", ws.StreamSetting.WSSettings.Path)
+	assert.Equal(t, "tls", ws.StreamSetting.Security)
+}
+
+func TestConvertShareLinksToXrayJson_SIP008Base64(t *testing.T) {
+	config, err := ConvertShareLinksToXrayJson(base64.StdEncoding.EncodeToString([]byte(sip008JSON)))
+	require.NoError(t, err)
+	assert.Len(t, config.OutboundConfigs, 3)
+}
+
+func TestConvertShareLinksToXrayJson_SIP008NoValidServer(t *testing.T) {
+	_, err := ConvertShareLinksToXrayJson(`{"version":1,"servers":[{"remarks":"a","server":"h","server_port":1,"method":"aes-128-gcm","plugin":"kcptun"}]}`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `server 0 ("a"): unsupported shadowsocks plugin: kcptun`)
+
+	_, err = ConvertShareLinksToXrayJson(`{"version":2,"servers":[{"server":"h","server_port":1,"method":"aes-128-gcm"}]}`)
+	assert.ErrorContains(t, err, "unsupported SIP008 version 2")
+}
+
+func TestConvertXrayJsonToSIP008_RoundTrip(t *testing.T) {
+	imported, err := ConvertShareLinksToXrayJson(sip008JSON)
+	require.NoError(t, err)
+	imported.OutboundConfigs = append(imported.OutboundConfigs, conf.OutboundDetourConfig{Protocol: "freedom"})
+	xrayBytes, err := json.Marshal(imported)
+	require.NoError(t, err)
+
+	sip008, err := ConvertXrayJsonToSIP008(xrayBytes)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sip008.Version)
+	require.Len(t, sip008.Servers, 3)
+
+	var original SIP008Config
+	require.NoError(t, json.Unmarshal([]byte(sip008JSON), &original))
+	for index, server := range sip008.Servers {
+		want := original.Servers[index]
+		want.ID = ""
+		assert.Equal(t, want, server)
+	}
+
+	sip008Bytes, err := json.Marshal(sip008)
+	require.NoError(t, err)
+	reimported, err := ConvertShareLinksToXrayJson(string(sip008Bytes))
+	require.NoError(t, err)
+	assert.Equal(t, imported.OutboundConfigs[:3], reimported.OutboundConfigs)
+}
+
+func TestConvertXrayJsonToSIP008_NoShadowsocks(t *testing.T) {
+	_, err := ConvertXrayJsonToSIP008([]byte(`{"outbounds":[{"protocol":"freedom"}]}`))
+	assert.ErrorContains(t, err, "no valid shadowsocks outbounds")
+}
@@ -0,0 +1,220 @@
+package share
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// https://developers.google.com/outline/docs/guides/service-providers/dynamic-access-keys
+//
+// An Outline dynamic access key ssconf://host/path is fetched from
+// https://host/path. The document is an ss:// link, a single server JSON
+// object, or a SIP008 document.
+
+const (
+	ssconfScheme       = "ssconf://"
+	maxSSConfBytes     = 1024 * 1024
+	defaultSSConfFetch = 10 * time.Second
+)
+
+// ErrSSConfNotFetched is returned by ConvertShareLinksToXrayJson for input
+// that only contains ssconf:// links, which need a network fetch; use
+// ConvertShareLinksToXrayJsonWithClient for those.
+var ErrSSConfNotFetched = errors.New("ssconf:// links need a network fetch that this conversion does not make")
+
+// HasSSConfLink reports whether any line of links is an ssconf:// key.
+func HasSSConfLink(links string) bool {
+	found := false
+	forEachLine(FixWindowsReturn(links), func(raw string) bool {
+		if strings.HasPrefix(strings.TrimSpace(raw), ssconfScheme) {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// ConvertShareLinksToXrayJsonWithClient is ConvertShareLinksToXrayJson that
+// also fetches every ssconf:// line with client and parses the fetched
+// document, including the lines of a base64 subscription. A nil client means
+// a direct client with a 10 second timeout.
+func ConvertShareLinksToXrayJsonWithClient(links string, client *http.Client) (*conf.Config, error) {
+	return convertShareLinksWithClient(links, client, nil)
+}
+
+func convertShareLinksWithClient(links string, client *http.Client, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	if !HasSSConfLink(links) {
+		decoded, err := decodeBase64Text(strings.TrimSpace(FixWindowsReturn(links)))
+		if err != nil || !HasSSConfLink(decoded) {
+			return convertShareLinks(links, diagnostics)
+		}
+		links = decoded
+	}
+	if client == nil {
+		client = &http.Client{Timeout: defaultSSConfFetch}
+	}
+
+	var outbounds []conf.OutboundDetourConfig
+	var skipped []error
+	var rest []string
+	lineNumber := 0
+	forEachLine(FixWindowsReturn(links), func(raw string) bool {
+		lineNumber++
+		line := strings.TrimSpace(raw)
+		if !strings.HasPrefix(line, ssconfScheme) {
+			rest = append(rest, raw)
+			return true
+		}
+		// Keep the line numbers of the other lines.
+		rest = append(rest, "")
+		config, err := convertSSConf(client, line, lineNumber, diagnostics)
+		if err != nil {
+			skipped = append(skipped, err)
+			return true
+		}
+		outbounds = append(outbounds, config.OutboundConfigs...)
+		return true
+	})
+	if text := strings.Join(rest, "\n"); strings.TrimSpace(text) != "" {
+		config, err := convertShareLinks(text, diagnostics)
+		if err != nil {
+			skipped = append(skipped, err)
+		} else {
+			outbounds = append(outbounds, config.OutboundConfigs...)
+		}
+	}
+	if len(outbounds) == 0 {
+		return nil, fmt.Errorf("no valid outbound found: %w", errors.Join(skipped...))
+	}
+	return &conf.Config{OutboundConfigs: outbounds}, nil
+}
+
+// convertSSConf fetches and parses the key on line lineNumber. Diagnostics
+// of the fetched document are reported on that line.
+func convertSSConf(client *http.Client, link string, lineNumber int, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	origin := ShareDiagnostic{Line: lineNumber, Text: redactSSConfLink(link)}
+	document, err := FetchSSConf(client, link)
+	if err != nil {
+		diagnostics.drop(origin, ShareDiagnosticStageFetch, err)
+		return nil, err
+	}
+	var documentDiagnostics *shareDiagnostics
+	if diagnostics != nil {
+		documentDiagnostics = &shareDiagnostics{}
+	}
+	config, err := parseSSConfDocument(document, documentDiagnostics)
+	if documentDiagnostics != nil {
+		for _, entry := range documentDiagnostics.entries {
+			entry.Line = lineNumber
+			diagnostics.entries = append(diagnostics.entries, entry)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("ssconf %s: %w", redactSSConfLink(link), err)
+		if documentDiagnostics == nil || len(documentDiagnostics.entries) == 0 {
+			diagnostics.drop(origin, ShareDiagnosticStageParse, err)
+		}
+		return nil, err
+	}
+	// The key fragment names the access key, like the ss:// fragment.
+	if u, err := url.Parse(link); err == nil && u.Fragment != "" {
+		for index := range config.OutboundConfigs {
+			outbound := &config.OutboundConfigs[index]
+			if outbound.SendThrough == nil || *outbound.SendThrough == "" {
+				setOutboundName(outbound, u.Fragment)
+			}
+		}
+	}
+	return config, nil
+}
+
+// FetchSSConf downloads the document an ssconf:// key points to.
+func FetchSSConf(client *http.Client, link string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Scheme != "ssconf" || u.Host == "" {
+		return "", fmt.Errorf("invalid ssconf link")
+	}
+	u.Scheme = "https"
+	u.Fragment = ""
+	if client == nil {
+		client = &http.Client{Timeout: defaultSSConfFetch}
+	}
+
+	response, err := client.Get(u.String())
+	if err != nil {
+		// url.Error repeats the full URL, secret path included.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("ssconf %s: %w", redactSSConfLink(link), err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSSConfBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("ssconf %s: %w", redactSSConfLink(link), err)
+	}
+	if len(body) > maxSSConfBytes {
+		return "", fmt.Errorf("ssconf %s: document is larger than %d bytes", redactSSConfLink(link), maxSSConfBytes)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", fmt.Errorf("ssconf %s: HTTP %d%s", redactSSConfLink(link), response.StatusCode, ssconfErrorMessage(body))
+	}
+	return string(body), nil
+}
+
+// parseSSConfDocument accepts the single server object Outline serves in
+// addition to everything ConvertShareLinksToXrayJson reads.
+func parseSSConfDocument(document string, diagnostics *shareDiagnostics) (*conf.Config, error) {
+	text := strings.TrimSpace(document)
+	if strings.HasPrefix(text, "{") && !isSIP008Config(text) {
+		var server struct {
+			SIP008Server
+			Prefix string `json:"prefix"`
+		}
+		if err := json.Unmarshal([]byte(text), &server); err == nil && server.Server != "" {
+			if server.Prefix != "" {
+				return nil, fmt.Errorf("outline prefix has no Xray equivalent")
+			}
+			outbound, err := server.outbound()
+			if err != nil {
+				return nil, err
+			}
+			return &conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{*outbound}}, nil
+		}
+	}
+	return convertShareLinks(text, diagnostics)
+}
+
+// ssconfErrorMessage extracts the message of an Outline error document,
+// {"error": {"message": "..."}}.
+func ssconfErrorMessage(body []byte) string {
+	var document struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil || document.Error.Message == "" {
+		return ""
+	}
+	return ": " + document.Error.Message
+}
+
+// redactSSConfLink keeps only the host of a key, because the path usually
+// carries the access secret.
+func redactSSConfLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ssconfScheme + "…"
+	}
+	return ssconfScheme + u.Host + "/…"
+}
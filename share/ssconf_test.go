@@ -0,0 +1,116 @@
+package share
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func ssconfServerForTest(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/link":
+			_, _ = response.Write([]byte("ss://YWVzLTEyOC1nY206cGFzcw@ss.example.com:8388#Link\n"))
+		case "/outline":
+			_, _ = response.Write([]byte(`{"server":"outline.example.com","server_port":443,"password":"pass","method":"chacha20-ietf-poly1305"}`))
+		case "/sip008":
+			_, _ = response.Write([]byte(sip008JSON))
+		case "/prefix":
+			_, _ = response.Write([]byte(`{"server":"h","server_port":443,"password":"p","method":"aes-128-gcm","prefix":"\u0016\u0003\u0001"}`))
+		case "/expired":
+			response.WriteHeader(http.StatusForbidden)
+			_, _ = response.Write([]byte(`{"error":{"message":"key expired"}}`))
+		default:
+			response.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, "ssconf://" + server.Listener.Addr().String()
+}
+
+func TestConvertShareLinksToXrayJsonWithClient_SSConf(t *testing.T) {
+	server, base := ssconfServerForTest(t)
+
+	config, err := ConvertShareLinksToXrayJsonWithClient(base+"/link", server.Client())
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 1)
+	assert.Equal(t, "Link", getOutboundName(config.OutboundConfigs[0]))
+
+	config, err = ConvertShareLinksToXrayJsonWithClient(base+"/outline#My%20Key", server.Client())
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 1)
+	outline := config.OutboundConfigs[0]
+	assert.Equal(t, "My Key", getOutboundName(outline))
+	var settings conf.ShadowsocksClientConfig
+	require.NoError(t, json.Unmarshal(*outline.Settings, &settings))
+	assert.Equal(t, "outline.example.com", settings.Address.String())
+	assert.Equal(t, "chacha20-ietf-poly1305", settings.Cipher)
+
+	config, err = ConvertShareLinksToXrayJsonWithClient(base+"/sip008", server.Client())
+	require.NoError(t, err)
+	assert.Len(t, config.OutboundConfigs, 3)
+}
+
+func TestConvertShareLinksToXrayJsonWithClient_SSConfMixedLines(t *testing.T) {
+	server, base := ssconfServerForTest(t)
+	links := strings.Join([]string{
+		base + "/link",
+		"trojan://secret@trojan.example.com:443?security=tls&sni=trojan.example.com#Trojan",
+		base + "/expired",
+	}, "\n")
+	config, err := ConvertShareLinksToXrayJsonWithClient(links, server.Client())
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 2)
+	assert.Equal(t, "Link", getOutboundName(config.OutboundConfigs[0]))
+	assert.Equal(t, "Trojan", getOutboundName(config.OutboundConfigs[1]))
+}
+
+func TestConvertShareLinksToXrayJsonWithClient_SSConfBase64(t *testing.T) {
+	server, base := ssconfServerForTest(t)
+	links := base64.StdEncoding.EncodeToString([]byte(base + "/link\n" +
+		"trojan://secret@trojan.example.com:443?security=tls&sni=trojan.example.com#Trojan\n"))
+	config, err := ConvertShareLinksToXrayJsonWithClient(links, server.Client())
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 2)
+	assert.Equal(t, "Link", getOutboundName(config.OutboundConfigs[0]))
+
+	// Without a client the key is skipped like any unknown line.
+	config, err = ConvertShareLinksToXrayJson(links)
+	require.NoError(t, err)
+	require.Len(t, config.OutboundConfigs, 1)
+	assert.Equal(t, "Trojan", getOutboundName(config.OutboundConfigs[0]))
+}
+
+func TestConvertShareLinksToXrayJsonWithClient_SSConfErrors(t *testing.T) {
+	server, base := ssconfServerForTest(t)
+
+	_, err := ConvertShareLinksToXrayJsonWithClient(base+"/expired/secret", server.Client())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "HTTP 404")
+	assert.NotContains(t, err.Error(), "secret")
+
+	_, err = ConvertShareLinksToXrayJsonWithClient(base+"/expired", server.Client())
+	assert.ErrorContains(t, err, "HTTP 403: key expired")
+
+	_, err = ConvertShareLinksToXrayJsonWithClient(base+"/prefix", server.Client())
+	assert.ErrorContains(t, err, "outline prefix has no Xray equivalent")
+
+	// The default client does not trust the test certificate.
+	_, err = ConvertShareLinksToXrayJsonWithClient(base+"/link", nil)
+	assert.Error(t, err)
+}
+
+func TestConvertShareLinksToXrayJson_SSConfNeedsFetch(t *testing.T) {
+	_, err := ConvertShareLinksToXrayJson("ssconf://example.com/key")
+	assert.ErrorIs(t, err, ErrSSConfNotFetched)
+	assert.True(t, HasSSConfLink("\r\n  ssconf://example.com/key\r\n"))
+	assert.False(t, HasSSConfLink("ss://YWVzLTEyOC1nY206cGFzcw@ss.example.com:8388"))
+}
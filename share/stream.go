@@ -58,16 +58,16 @@ func (proxy xrayShareLink) parseSecurityFromURL(link *url.URL, streamSettings *c
 	realitySettings.Mldsa65Verify = query.Get("pqv")
 	realitySettings.SpiderX = query.Get("spx")
 
-	if security := query.Get("security"); security == "" {
-		streamSettings.Security = "none"
-	} else {
+	// Trojan, Hysteria2 and HTTPS default to TLS, but an explicit
+	// security=none is kept.
+	if security := query.Get("security"); security != "" {
 		streamSettings.Security = security
-	}
-
-	switch proxy.link.Scheme {
-	case "trojan", "hysteria2", "hy2":
-		if streamSettings.Security == "none" {
+	} else {
+		switch proxy.link.Scheme {
+		case "trojan", "hysteria2", "hy2", "https":
 			streamSettings.Security = "tls"
+		default:
+			streamSettings.Security = "none"
 		}
 	}
 
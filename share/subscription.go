@@ -0,0 +1,216 @@
+package share
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subscription URLs carry the account token in their path or query, so
+// errors only name the scheme and host.
+
+const (
+	maxSubscriptionBytes     = 16 * 1024 * 1024
+	defaultSubscriptionFetch = 10 * time.Second
+	// DefaultSubscriptionUserAgent is sent when no User-Agent is given.
+	DefaultSubscriptionUserAgent = "libXray"
+)
+
+// Subscription is a downloaded subscription and what its response headers
+// say about it.
+type Subscription struct {
+	// Text is the body as served: share links, base64, Clash YAML, sing-box
+	// JSON, or an age armored file of one of them.
+	Text     string
+	UserInfo *SubscriptionUserInfo
+	// UpdateInterval is profile-update-interval, in hours.
+	UpdateInterval int
+	// Name is the file name of content-disposition.
+	Name string
+
+	// ETag, LastModified and ContentHash are the SubscriptionCache of the
+	// next fetch. ContentHash is the hex SHA-256 of Text.
+	ETag         string
+	LastModified string
+	ContentHash  string
+	// NotModified is set when the server answered 304, or the body hashes
+	// to the cached ContentHash. Text is then empty.
+	NotModified bool
+}
+
+// SubscriptionCache is what a previous fetch returned. ETag and
+// LastModified become If-None-Match and If-Modified-Since.
+type SubscriptionCache struct {
+	ETag         string
+	LastModified string
+	ContentHash  string
+}
+
+// SubscriptionUserInfo is the subscription-userinfo header: traffic in
+// bytes, and Expire in Unix seconds. Zero means absent.
+type SubscriptionUserInfo struct {
+	Upload   int64
+	Download int64
+	Total    int64
+	Expire   int64
+}
+
+// FetchSubscription downloads link with an HTTP GET. A nil client means a
+// direct client with a 10 second timeout; an empty userAgent means
+// DefaultSubscriptionUserAgent.
+func FetchSubscription(client *http.Client, link, userAgent string) (*Subscription, error) {
+	return FetchSubscriptionWithCache(client, link, userAgent, SubscriptionCache{})
+}
+
+// FetchSubscriptionWithCache is FetchSubscription that asks the server for
+// changes since cache, and reports NotModified when there are none.
+func FetchSubscriptionWithCache(client *http.Client, link, userAgent string, cache SubscriptionCache) (*Subscription, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid subscription url")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: defaultSubscriptionFetch}
+	}
+	if userAgent == "" {
+		userAgent = DefaultSubscriptionUserAgent
+	}
+	name := redactSubscriptionURL(u)
+
+	request, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("subscription %s: %w", name, err)
+	}
+	request.Header.Set("User-Agent", userAgent)
+	if cache.ETag != "" {
+		request.Header.Set("If-None-Match", cache.ETag)
+	}
+	if cache.LastModified != "" {
+		request.Header.Set("If-Modified-Since", cache.LastModified)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		// url.Error repeats the full URL, token included.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("subscription %s: %w", name, err)
+	}
+	defer response.Body.Close()
+
+	subscription := &Subscription{
+		UserInfo:     ParseSubscriptionUserInfo(response.Header.Get("Subscription-Userinfo")),
+		Name:         contentDispositionName(response.Header.Get("Content-Disposition")),
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}
+	if interval, err := strconv.Atoi(strings.TrimSpace(response.Header.Get("Profile-Update-Interval"))); err == nil && interval > 0 {
+		subscription.UpdateInterval = interval
+	}
+	if response.StatusCode == http.StatusNotModified && (cache.ETag != "" || cache.LastModified != "") {
+		// A 304 may leave out the validators it confirmed.
+		if subscription.ETag == "" {
+			subscription.ETag = cache.ETag
+		}
+		if subscription.LastModified == "" {
+			subscription.LastModified = cache.LastModified
+		}
+		subscription.ContentHash = cache.ContentHash
+		subscription.NotModified = true
+		return subscription, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("subscription %s: HTTP %d", name, response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSubscriptionBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("subscription %s: %w", name, err)
+	}
+	if len(body) > maxSubscriptionBytes {
+		return nil, fmt.Errorf("subscription %s: body is larger than %d bytes", name, maxSubscriptionBytes)
+	}
+
+	sum := sha256.Sum256(body)
+	subscription.ContentHash = hex.EncodeToString(sum[:])
+	if subscription.ContentHash == cache.ContentHash {
+		subscription.NotModified = true
+		return subscription, nil
+	}
+	subscription.Text = string(body)
+	return subscription, nil
+}
+
+// ParseSubscriptionUserInfo reads "upload=1; download=2; total=3;
+// expire=4". Unknown keys and unreadable values are skipped; nil means no
+// field was read.
+func ParseSubscriptionUserInfo(header string) *SubscriptionUserInfo {
+	var info SubscriptionUserInfo
+	found := false
+	for _, field := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		number, ok := parseUserInfoNumber(value)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			info.Upload = number
+		case "download":
+			info.Download = number
+		case "total":
+			info.Total = number
+		case "expire":
+			info.Expire = number
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return &info
+}
+
+// parseUserInfoNumber also reads the "1.5e10" some panels write.
+func parseUserInfoNumber(value string) (int64, bool) {
+	value = strings.TrimSpace(value)
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return number, number >= 0
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	// Written this way round, the check also rejects NaN.
+	if err != nil || !(number >= 0 && number < 1<<63) {
+		return 0, false
+	}
+	return int64(number), true
+}
+
+// contentDispositionName returns the filename, or filename* with its
+// charset decoded, of a content-disposition header.
+func contentDispositionName(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params["filename"])
+}
+
+func redactSubscriptionURL(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,140 @@
+package share
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchSubscription(t *testing.T) {
+	const links = "trojan://secret@example.com:443?security=tls#Fetched\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sub/token", r.URL.Path)
+		assert.Equal(t, "clash-verge/v2", r.UserAgent())
+		w.Header().Set("Subscription-Userinfo", "upload=1024; download=2048; total=1.073741824e10; expire=1792368000")
+		w.Header().Set("Profile-Update-Interval", "12")
+		w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''%E6%9C%BA%E5%9C%BA`)
+		w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(links))))
+	}))
+	defer server.Close()
+
+	subscription, err := FetchSubscription(server.Client(), server.URL+"/sub/token", "clash-verge/v2")
+	require.NoError(t, err)
+	assert.Equal(t, &SubscriptionUserInfo{Upload: 1024, Download: 2048, Total: 10737418240, Expire: 1792368000}, subscription.UserInfo)
+	assert.Equal(t, 12, subscription.UpdateInterval)
+	assert.Equal(t, "机场", subscription.Name)
+
+	config, err := ConvertShareLinksToXrayJson(subscription.Text)
+	require.NoError(t, err)
+	assert.Len(t, config.OutboundConfigs, 1)
+}
+
+func TestFetchSubscription_DefaultsAndErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plain":
+			assert.Equal(t, DefaultSubscriptionUserAgent, r.UserAgent())
+			w.Header().Set("Content-Disposition", `attachment; filename="provider.yaml"`)
+			w.Write([]byte("proxies: []\n"))
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", maxSubscriptionBytes+1)))
+		default:
+			http.Error(w, "token expired", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	subscription, err := FetchSubscription(server.Client(), server.URL+"/plain", "")
+	require.NoError(t, err)
+	assert.Nil(t, subscription.UserInfo)
+	assert.Zero(t, subscription.UpdateInterval)
+	assert.Equal(t, "provider.yaml", subscription.Name)
+
+	_, err = FetchSubscription(server.Client(), server.URL+"/sub/secret-token?key=hidden", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "HTTP 403")
+	assert.NotContains(t, err.Error(), "secret-token")
+	assert.NotContains(t, err.Error(), "hidden")
+
+	_, err = FetchSubscription(server.Client(), server.URL+"/large", "")
+	assert.ErrorContains(t, err, "larger than")
+
+	_, err = FetchSubscription(server.Client(), "ftp://example.com/sub", "")
+	assert.Error(t, err)
+}
+
+func TestParseSubscriptionUserInfo(t *testing.T) {
+	for header, want := range map[string]*SubscriptionUserInfo{
+		"":                               nil,
+		"garbage":                        nil,
+		"upload=; download=x":            nil,
+		"upload=1;download=2":            {Upload: 1, Download: 2},
+		" Upload = 5 ; TOTAL=10; foo=3 ": {Upload: 5, Total: 10},
+		"total=-1; expire=1792368000":    {Expire: 1792368000},
+	} {
+		assert.Equal(t, want, ParseSubscriptionUserInfo(header), header)
+	}
+}
+
+func TestFetchSubscriptionWithCache(t *testing.T) {
+	const body = "trojan://secret@example.com:443?security=tls#Cached\n"
+	const changed = "trojan://secret@example.com:443?security=tls#Changed\n"
+	const etag = `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/etag":
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Last-Modified", "Mon, 19 Oct 2026 00:00:00 GMT")
+		case "/modified":
+			if r.Header.Get("If-Modified-Since") == "Mon, 19 Oct 2026 00:00:00 GMT" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Last-Modified", "Mon, 19 Oct 2026 00:00:00 GMT")
+		case "/changed":
+			w.Write([]byte(changed))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	first, err := FetchSubscriptionWithCache(server.Client(), server.URL+"/etag", "", SubscriptionCache{})
+	require.NoError(t, err)
+	assert.False(t, first.NotModified)
+	assert.Equal(t, body, first.Text)
+	assert.Equal(t, etag, first.ETag)
+	assert.Len(t, first.ContentHash, 64)
+
+	cache := SubscriptionCache{ETag: first.ETag, LastModified: first.LastModified, ContentHash: first.ContentHash}
+	second, err := FetchSubscriptionWithCache(server.Client(), server.URL+"/etag", "", cache)
+	require.NoError(t, err)
+	assert.True(t, second.NotModified)
+	assert.Empty(t, second.Text)
+	assert.Equal(t, cache, SubscriptionCache{ETag: second.ETag, LastModified: second.LastModified, ContentHash: second.ContentHash})
+
+	second, err = FetchSubscriptionWithCache(server.Client(), server.URL+"/modified", "", SubscriptionCache{LastModified: first.LastModified})
+	require.NoError(t, err)
+	assert.True(t, second.NotModified)
+
+	// Without validators, the same body is recognized by its hash.
+	second, err = FetchSubscriptionWithCache(server.Client(), server.URL+"/plain", "", SubscriptionCache{ContentHash: first.ContentHash})
+	require.NoError(t, err)
+	assert.True(t, second.NotModified)
+	assert.Empty(t, second.Text)
+
+	second, err = FetchSubscriptionWithCache(server.Client(), server.URL+"/changed", "", SubscriptionCache{ContentHash: first.ContentHash})
+	require.NoError(t, err)
+	assert.False(t, second.NotModified)
+	assert.Equal(t, changed, second.Text)
+	assert.NotEqual(t, first.ContentHash, second.ContentHash)
+}
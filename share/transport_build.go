@@ -58,6 +58,8 @@ func transportFieldsFromVmessQR(p vmessQrCode) shareTransportFields {
 	case "grpc", "gun":
 		t.GrpcServiceName = p.Path
 		t.GrpcMultiMode = p.Type == "multi"
+		// v2rayN writes the gRPC authority as host.
+		t.GrpcAuthority = p.Host
 	case "kcp", "mkcp":
 		t.Seed = p.Path
 	}
@@ -105,7 +107,11 @@ func buildStreamFromTransportFields(t shareTransportFields) (*conf.StreamConfig,
 			}
 			kcpSettings.HeaderConfig = headerRawMessage
 		}
-		kcpSettings.Seed = new(t.Seed)
+		// Xray rejects any seed, even an empty one, so a plain kcp link
+		// must leave it unset.
+		if t.Seed != "" {
+			kcpSettings.Seed = new(t.Seed)
+		}
 		streamSettings.KCPSettings = kcpSettings
 	case "ws", "websocket":
 		streamSettings.WSSettings = &conf.WebSocketConfig{Path: t.Path, Host: t.Host}
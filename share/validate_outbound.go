@@ -9,7 +9,7 @@ import (
 	"github.com/xtls/xray-core/infra/conf"
 )
 
-func filterBuildableOutbounds(config *conf.Config) (*conf.Config, error) {
+func filterBuildableOutbounds(config *conf.Config, diagnostics *shareDiagnostics) (*conf.Config, error) {
 	raw, err := json.Marshal(config.OutboundConfigs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to copy outbounds for validation: %w", err)
@@ -31,6 +31,11 @@ func filterBuildableOutbounds(config *conf.Config) (*conf.Config, error) {
 			if firstBuildError == nil {
 				firstBuildError = err
 			}
+			origin := indexOrigin(index, getOutboundName(config.OutboundConfigs[index]))
+			if recorded := diagnostics.origin(index, len(config.OutboundConfigs)); recorded != nil {
+				origin = *recorded
+			}
+			diagnostics.drop(origin, ShareDiagnosticStageBuild, err)
 			continue
 		}
 		validOutbounds = append(validOutbounds, config.OutboundConfigs[index])
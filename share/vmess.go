@@ -1,6 +1,7 @@
 package share
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -11,10 +12,12 @@ import (
 
 // https://github.com/2dust/v2rayN/wiki/%E5%88%86%E4%BA%AB%E9%93%BE%E6%8E%A5%E6%A0%BC%E5%BC%8F%E8%AF%B4%E6%98%8E(ver-2)
 type vmessQrCode struct {
+	V    string      `json:"v,omitempty"`
 	Ps   string      `json:"ps,omitempty"`
 	Add  string      `json:"add,omitempty"`
 	Port interface{} `json:"port,omitempty"`
 	Id   string      `json:"id,omitempty"`
+	Aid  interface{} `json:"aid,omitempty"`
 	Scy  string      `json:"scy,omitempty"`
 	Net  string      `json:"net,omitempty"`
 	Type string      `json:"type,omitempty"`
@@ -97,3 +100,117 @@ func (proxy vmessQrCode) parseSecurity(streamSettings *conf.StreamConfig) error
 	}
 	return nil
 }
+
+// vmessQrCodeLink writes a VMess outbound as a v2rayN base64 JSON link. The
+// JSON has no keys for REALITY, ECH, pinned certificates, xhttp extra or a
+// final mask, so an outbound using them is refused rather than weakened.
+func vmessQrCodeLink(outbound conf.OutboundDetourConfig) (string, error) {
+	qrCode, err := vmessQrCodeFromOutbound(outbound)
+	if err != nil {
+		return "", err
+	}
+	qrCodeBytes, err := json.Marshal(qrCode)
+	if err != nil {
+		return "", err
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(qrCodeBytes), nil
+}
+
+// vmessQrCodeFromOutbound is the reverse of vmessQrCode.outbound.
+func vmessQrCodeFromOutbound(outbound conf.OutboundDetourConfig) (*vmessQrCode, error) {
+	settings, err := decodeOutboundSettings[conf.VMessOutboundConfig](outbound)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Address == nil {
+		return nil, fmt.Errorf("vmess outbound has no server address")
+	}
+	qrCode := &vmessQrCode{
+		V:    "2",
+		Ps:   getOutboundName(outbound),
+		Add:  strings.Trim(settings.Address.String(), "[]"),
+		Port: strconv.Itoa(int(settings.Port)),
+		Id:   settings.ID,
+		Aid:  "0",
+		Scy:  settings.Security,
+		Net:  "tcp",
+	}
+
+	streamSettings := outbound.StreamSetting
+	if streamSettings == nil {
+		return qrCode, nil
+	}
+	if streamSettings.FinalMask != nil {
+		return nil, fmt.Errorf("vmess QR code cannot carry finalmask")
+	}
+
+	switch network := streamNetwork(streamSettings); network {
+	case "raw", "tcp":
+		header, err := rawHTTPHeader(streamSettings)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			qrCode.Type = header.Type
+			if header.Request != nil {
+				qrCode.Path = strings.Join(header.Request.Path, ",")
+				if header.Request.Headers != nil {
+					qrCode.Host = strings.Join(header.Request.Headers.Host, ",")
+				}
+			}
+		}
+	case "kcp", "mkcp":
+		qrCode.Net = "kcp"
+		if kcpSettings := streamSettings.KCPSettings; kcpSettings != nil && kcpSettings.Seed != nil {
+			qrCode.Path = *kcpSettings.Seed
+		}
+	case "ws", "websocket":
+		qrCode.Net = "ws"
+		if wsSettings := streamSettings.WSSettings; wsSettings != nil {
+			qrCode.Host, qrCode.Path = wsSettings.Host, wsSettings.Path
+		}
+	case "grpc", "gun":
+		qrCode.Net = "grpc"
+		qrCode.Type = "gun"
+		if grpcSettings := streamSettings.GRPCSettings; grpcSettings != nil {
+			qrCode.Host, qrCode.Path = grpcSettings.Authority, grpcSettings.ServiceName
+			if grpcSettings.MultiMode {
+				qrCode.Type = "multi"
+			}
+		}
+	case "httpupgrade":
+		qrCode.Net = network
+		if httpUpgradeSettings := streamSettings.HTTPUPGRADESettings; httpUpgradeSettings != nil {
+			qrCode.Host, qrCode.Path = httpUpgradeSettings.Host, httpUpgradeSettings.Path
+		}
+	case "xhttp", "splithttp":
+		qrCode.Net = "xhttp"
+		if xhttpSettings := streamXHTTPSettings(streamSettings); xhttpSettings != nil {
+			if compactJSON(xhttpSettings.Extra) != "" {
+				return nil, fmt.Errorf("vmess QR code cannot carry xhttp extra")
+			}
+			qrCode.Host, qrCode.Path, qrCode.Type = xhttpSettings.Host, xhttpSettings.Path, xhttpSettings.Mode
+		}
+	default:
+		return nil, fmt.Errorf("vmess QR code cannot carry network %q", network)
+	}
+
+	switch streamSettings.Security {
+	case "", "none":
+	case "tls":
+		qrCode.Tls = "tls"
+		if tlsSettings := streamSettings.TLSSettings; tlsSettings != nil {
+			if tlsSettings.ECHConfigList != "" || tlsSettings.PinnedPeerCertSha256 != "" || tlsSettings.VerifyPeerCertByName != "" {
+				return nil, fmt.Errorf("vmess QR code cannot carry ech, pcs or vcn")
+			}
+			qrCode.Sni = tlsSettings.ServerName
+			qrCode.Fp = tlsSettings.Fingerprint
+			if tlsSettings.ALPN != nil {
+				qrCode.Alpn = strings.Join(*tlsSettings.ALPN, ",")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("vmess QR code cannot carry security %q", streamSettings.Security)
+	}
+	return qrCode, nil
+}
@@ -0,0 +1,275 @@
+package share
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// wireguard:// and wg:// links carry the private key as user info and the
+// peer endpoint as host:port. Clients disagree on query names, so the
+// common spellings are accepted.
+var (
+	wireGuardPublicKeyNames    = []string{"publickey", "public_key", "peer_public_key", "publicKey"}
+	wireGuardPreSharedKeyNames = []string{"presharedkey", "pre_shared_key", "psk", "preSharedKey"}
+	wireGuardAddressNames      = []string{"address", "ip", "local_address"}
+)
+
+func (proxy xrayShareLink) wireguardOutbound() (*conf.OutboundDetourConfig, error) {
+	outbound := &conf.OutboundDetourConfig{}
+	outbound.Protocol = "wireguard"
+	setOutboundName(outbound, proxy.link.Fragment)
+	query := proxy.link.Query()
+
+	if _, err := strconv.Atoi(proxy.link.Port()); err != nil {
+		return nil, fmt.Errorf("invalid wireguard port: %w", err)
+	}
+	secretKey := proxy.link.User.Username()
+	if secretKey == "" {
+		secretKey = wireGuardQueryKey(query, "privatekey", "private_key", "secretKey")
+	}
+	if secretKey == "" {
+		return nil, fmt.Errorf("missing wireguard private key")
+	}
+	publicKey := wireGuardQueryKey(query, wireGuardPublicKeyNames...)
+	if publicKey == "" {
+		return nil, fmt.Errorf("missing wireguard peer public key")
+	}
+
+	settings := &conf.WireGuardConfig{IsClient: true}
+	settings.SecretKey = secretKey
+	settings.Address = splitWireGuardList(firstQueryValue(query, wireGuardAddressNames...))
+	settings.Peers = []*conf.WireGuardPeerConfig{{
+		PublicKey:    publicKey,
+		PreSharedKey: wireGuardQueryKey(query, wireGuardPreSharedKeyNames...),
+		Endpoint:     proxy.link.Host,
+	}}
+	if mtu := query.Get("mtu"); mtu != "" {
+		value, err := strconv.ParseInt(mtu, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wireguard mtu: %w", err)
+		}
+		settings.MTU = int32(value)
+	}
+	if reserved := query.Get("reserved"); reserved != "" {
+		value, err := parseWireGuardReserved(reserved)
+		if err != nil {
+			return nil, err
+		}
+		settings.Reserved = value
+	}
+
+	settingsRawMessage, err := convertJsonToRawMessage(settings)
+	if err != nil {
+		return nil, err
+	}
+	outbound.Settings = &settingsRawMessage
+	return outbound, nil
+}
+
+func firstQueryValue(query url.Values, names ...string) string {
+	for _, name := range names {
+		if value := query.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// wireGuardQueryKey restores '+' in base64 keys that query decoding turned
+// into spaces.
+func wireGuardQueryKey(query url.Values, names ...string) string {
+	return strings.ReplaceAll(firstQueryValue(query, names...), " ", "+")
+}
+
+func splitWireGuardList(text string) []string {
+	var values []string
+	for value := range strings.SplitSeq(text, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseWireGuardReserved accepts the 3 reserved bytes as a decimal list
+// ("1,2,3") or as base64.
+func parseWireGuardReserved(text string) ([]byte, error) {
+	parts := splitWireGuardList(text)
+	if len(parts) == 3 {
+		reserved := make([]byte, 0, 3)
+		for _, part := range parts {
+			value, err := strconv.ParseUint(part, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid wireguard reserved %q", text)
+			}
+			reserved = append(reserved, byte(value))
+		}
+		return reserved, nil
+	}
+	decoded, err := decodeBase64Text(text)
+	if err != nil || len(decoded) != 3 {
+		return nil, fmt.Errorf("invalid wireguard reserved %q", text)
+	}
+	return []byte(decoded), nil
+}
+
+func formatWireGuardReserved(reserved []byte) string {
+	values := make([]string, len(reserved))
+	for i, value := range reserved {
+		values[i] = strconv.Itoa(int(value))
+	}
+	return strings.Join(values, ",")
+}
+
+func wireguardLink(proxy conf.OutboundDetourConfig, link *url.URL) error {
+	settings, err := decodeOutboundSettings[conf.WireGuardConfig](proxy)
+	if err != nil {
+		return err
+	}
+	if len(settings.Peers) == 0 || settings.Peers[0] == nil {
+		return fmt.Errorf("wireguard outbound has no peer")
+	}
+	peer := settings.Peers[0]
+	if peer.Endpoint == "" {
+		return fmt.Errorf("wireguard peer has no endpoint")
+	}
+
+	link.Fragment = getOutboundName(proxy)
+	link.Scheme = "wireguard"
+
+	link.Host = peer.Endpoint
+	link.User = url.User(settings.SecretKey)
+	query := url.Values{}
+	query.Set("publickey", peer.PublicKey)
+	if peer.PreSharedKey != "" {
+		query.Set("presharedkey", peer.PreSharedKey)
+	}
+	if len(settings.Address) > 0 {
+		query.Set("address", strings.Join(settings.Address, ","))
+	}
+	if len(settings.Reserved) > 0 {
+		query.Set("reserved", formatWireGuardReserved(settings.Reserved))
+	}
+	if settings.MTU != 0 {
+		query.Set("mtu", strconv.Itoa(int(settings.MTU)))
+	}
+	link.RawQuery = query.Encode()
+
+	return nil
+}
+
+// hasWireGuardINI reports whether text is a wg-quick configuration.
+func hasWireGuardINI(text string) bool {
+	found := false
+	forEachLine(text, func(raw string) bool {
+		if strings.EqualFold(strings.TrimSpace(raw), "[Interface]") {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// parseWireGuardINI converts wg-quick INI text into one WireGuard outbound
+// with every [Peer]. Keys that Xray has no use for, such as DNS and the
+// PostUp hooks, are ignored.
+func parseWireGuardINI(text string) (*conf.Config, error) {
+	settings := &conf.WireGuardConfig{IsClient: true}
+	var peer *conf.WireGuardPeerConfig
+	section := ""
+	lineNumber := 0
+	var parseErr error
+	forEachLine(text, func(raw string) bool {
+		lineNumber++
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			return true
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			if section == "peer" {
+				peer = &conf.WireGuardPeerConfig{}
+				settings.Peers = append(settings.Peers, peer)
+			}
+			return true
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			parseErr = fmt.Errorf("invalid wireguard config line %d", lineNumber)
+			return false
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch section {
+		case "interface":
+			switch key {
+			case "privatekey":
+				settings.SecretKey = value
+			case "address":
+				settings.Address = append(settings.Address, splitWireGuardList(value)...)
+			case "mtu":
+				mtu, err := strconv.ParseInt(value, 10, 32)
+				if err != nil {
+					parseErr = fmt.Errorf("invalid wireguard MTU on line %d", lineNumber)
+					return false
+				}
+				settings.MTU = int32(mtu)
+			}
+		case "peer":
+			switch key {
+			case "publickey":
+				peer.PublicKey = value
+			case "presharedkey":
+				peer.PreSharedKey = value
+			case "endpoint":
+				peer.Endpoint = value
+			case "allowedips":
+				peer.AllowedIPs = append(peer.AllowedIPs, splitWireGuardList(value)...)
+			case "persistentkeepalive":
+				keepAlive, err := strconv.ParseUint(value, 10, 32)
+				if err != nil {
+					parseErr = fmt.Errorf(
+						"invalid wireguard PersistentKeepalive on line %d",
+						lineNumber,
+					)
+					return false
+				}
+				peer.KeepAlive = uint32(keepAlive)
+			}
+		}
+		return true
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if settings.SecretKey == "" {
+		return nil, fmt.Errorf("wireguard config has no PrivateKey")
+	}
+	if len(settings.Peers) == 0 {
+		return nil, fmt.Errorf("wireguard config has no [Peer]")
+	}
+	for _, peer := range settings.Peers {
+		if peer.PublicKey == "" || peer.Endpoint == "" {
+			return nil, fmt.Errorf("wireguard peer needs PublicKey and Endpoint")
+		}
+		if _, _, err := net.SplitHostPort(peer.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid wireguard peer Endpoint %q", peer.Endpoint)
+		}
+	}
+
+	outbound := conf.OutboundDetourConfig{}
+	outbound.Protocol = "wireguard"
+	settingsRawMessage, err := convertJsonToRawMessage(settings)
+	if err != nil {
+		return nil, err
+	}
+	outbound.Settings = &settingsRawMessage
+	return &conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{outbound}}, nil
+}
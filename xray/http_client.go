@@ -0,0 +1,120 @@
+package xray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	xrayNet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/outbound"
+)
+
+// OutboundHTTPClient returns an HTTP client whose TCP connections go through
+// one outbound of xrayJSON, chosen like a PingBatchItem: outboundTag, else
+// the "proxy" outbound, else the first one. Timeout is in seconds. The caller
+// must call close once the client is no longer used.
+func OutboundHTTPClient(
+	xrayJSON string,
+	outboundTag string,
+	timeout int,
+) (*http.Client, func() error, error) {
+	if timeout <= 0 {
+		return nil, nil, errors.New("http client timeout must be greater than zero")
+	}
+	outbounds, err := readPingOutbounds(xrayJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+	prepared, tag, err := preparePingOutbounds(outbounds, outboundTag, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	server, err := startPingBatchServer(prepared)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		DialContext:       outboundDialContext(server, tag),
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Second * time.Duration(timeout),
+	}
+	closeClient := func() error {
+		transport.CloseIdleConnections()
+		return server.Close()
+	}
+	return client, closeClient, nil
+}
+
+// RunningOutboundHTTPClient is OutboundHTTPClient for outbound outboundTag
+// of the instance started by RunXray. The client stops working once that
+// instance stops.
+func RunningOutboundHTTPClient(
+	outboundTag string,
+	timeout int,
+) (*http.Client, func() error, error) {
+	if timeout <= 0 {
+		return nil, nil, errors.New("http client timeout must be greater than zero")
+	}
+	if outboundTag == "" {
+		return nil, nil, errors.New("running outbound tag is empty")
+	}
+	coreServerMu.Lock()
+	server := coreServer
+	coreServerMu.Unlock()
+	if server == nil || !server.IsRunning() {
+		return nil, nil, ErrNotRunning
+	}
+	manager, ok := server.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if !ok {
+		return nil, nil, errors.New("outbound manager is not registered in Xray core")
+	}
+	if manager.GetHandler(outboundTag) == nil {
+		return nil, nil, fmt.Errorf("outbound tag %q not found", outboundTag)
+	}
+
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		DialContext:       outboundDialContext(server, outboundTag),
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Second * time.Duration(timeout),
+	}
+	closeClient := func() error {
+		transport.CloseIdleConnections()
+		return nil
+	}
+	return client, closeClient, nil
+}
+
+// outboundDialContext dials TCP through outboundTag of server, for use as
+// http.Transport.DialContext.
+func outboundDialContext(
+	server *core.Instance,
+	outboundTag string,
+) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	return func(
+		ctx context.Context,
+		network string,
+		address string,
+	) (net.Conn, error) {
+		if network != "tcp" && network != "tcp4" && network != "tcp6" {
+			return nil, fmt.Errorf("unsupported network %q", network)
+		}
+		destination, err := xrayNet.ParseDestination("tcp:" + address)
+		if err != nil {
+			return nil, err
+		}
+		ctx = session.SetForcedOutboundTagToContext(ctx, outboundTag)
+		return core.Dial(ctx, server, destination)
+	}
+}
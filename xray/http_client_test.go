@@ -0,0 +1,82 @@
+package xray
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestOutboundHTTPClientUsesOutbound(t *testing.T) {
+	server := pingHTTPServerForTest(t)
+	client, closeClient, err := OutboundHTTPClient(
+		`{"outbounds":[{"protocol":"blackhole","tag":"block"},{"protocol":"freedom","tag":"direct"}]}`,
+		"direct",
+		2,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient()
+
+	response, err := client.Get(server.URL + "/portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if string(body) != "captive portal" {
+		t.Fatalf("body = %q, want the test server response", body)
+	}
+}
+
+func TestOutboundHTTPClientRejectsInvalidRequests(t *testing.T) {
+	if _, _, err := OutboundHTTPClient(`{"outbounds":[{"protocol":"freedom"}]}`, "", 0); err == nil {
+		t.Fatal("zero timeout was accepted")
+	}
+	if _, _, err := OutboundHTTPClient(`{"outbounds":[{"protocol":"freedom"}]}`, "missing", 2); err == nil {
+		t.Fatal("missing outbound tag was accepted")
+	}
+}
+
+func TestRunningOutboundHTTPClientUsesRunningInstance(t *testing.T) {
+	server := pingHTTPServerForTest(t)
+	if err := StopXray(); err != nil {
+		t.Fatalf("reset xray state: %v", err)
+	}
+	if _, _, err := RunningOutboundHTTPClient("direct", 2); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("error = %v, want %v", err, ErrNotRunning)
+	}
+	if err := RunXray(`{
+		"log": {"loglevel": "none"},
+		"outbounds": [
+			{"protocol": "blackhole", "tag": "block"},
+			{"protocol": "freedom", "tag": "direct"}
+		]
+	}`); err != nil {
+		t.Fatalf("start xray: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := StopXray(); err != nil {
+			t.Errorf("stop xray: %v", err)
+		}
+	})
+
+	if _, _, err := RunningOutboundHTTPClient("missing", 2); err == nil {
+		t.Fatal("missing outbound tag was accepted")
+	}
+	client, closeClient, err := RunningOutboundHTTPClient("direct", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeClient()
+
+	response, err := client.Get(server.URL + "/portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if string(body) != "captive portal" {
+		t.Fatalf("body = %q, want the test server response", body)
+	}
+}
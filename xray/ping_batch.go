@@ -1,21 +1,16 @@
 package xray
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
 	"net/url"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/xtls/libxray/nodep"
-	xrayNet "github.com/xtls/xray-core/common/net"
-	"github.com/xtls/xray-core/common/session"
 	"github.com/xtls/xray-core/core"
 	"github.com/xtls/xray-core/infra/conf"
 	confJSON "github.com/xtls/xray-core/infra/conf/json"
@@ -23,8 +18,46 @@ import (
 
 const (
 	maxPingBatchConfigs = 5
+	maxPingWorkers      = 16
+	defaultPingResolver = "1.1.1.1:53"
 )
 
+type PingMode string
+
+const (
+	PingModeHTTPHead   PingMode = "http-head"
+	PingModeHTTPGet    PingMode = "http-get"
+	PingModeTCPConnect PingMode = "tcp-connect"
+	PingModeUDPDNS     PingMode = "udp-dns"
+)
+
+// PingOptions controls how every item of a batch is probed.
+// Timeout is in seconds. URL is used by the HTTP modes, Target is the
+// host:port used by PingModeTCPConnect, and Resolver is the UDP DNS server
+// used by PingModeUDPDNS. An empty Mode means PingModeHTTPHead.
+// Direct additionally dials each outbound's server without a proxy.
+type PingOptions struct {
+	Timeout  int
+	URL      string
+	Mode     PingMode
+	Target   string
+	Resolver string
+	Direct   bool
+	HTTP     PingHTTPCheck
+}
+
+// PingHTTPCheck customizes the request and the accepted responses of the
+// HTTP modes. Method overrides the method implied by the mode. An empty
+// ExpectedStatus accepts any status. BodyContains and BodySHA256 (hex) are
+// checked against at most 1 MiB of response body.
+type PingHTTPCheck struct {
+	Method         string
+	Headers        map[string]string
+	ExpectedStatus []int
+	BodyContains   string
+	BodySHA256     string
+}
+
 type PingBatchItem struct {
 	XrayJSON    string
 	OutboundTag string
@@ -32,8 +65,20 @@ type PingBatchItem struct {
 
 type PingBatchResult struct {
 	Success bool
-	Delay   int64
-	Error   string
+	// Delay is in milliseconds. With PingModeTCPConnect through a tunneling
+	// protocol such as VLESS, VMess, Trojan or Shadowsocks, it and Success
+	// only cover the connect to the proxy server, not the proxied connect
+	// to Target.
+	Delay int64
+	Error string
+	// Reachable reports that an HTTP mode got a response which failed the
+	// PingHTTPCheck. StatusCode is the status of that response.
+	Reachable  bool
+	StatusCode int
+	// DirectDelay and DirectError hold the baseline requested by
+	// PingOptions.Direct.
+	DirectDelay int64
+	DirectError string
 }
 
 type pingOutboundConfig struct {
@@ -43,14 +88,18 @@ type pingOutboundConfig struct {
 type preparedPingItem struct {
 	resultIndex int
 	outboundTag string
+	outbound    conf.OutboundDetourConfig
 }
 
 func PingBatch(
 	items []PingBatchItem,
-	timeout int,
-	targetURL string,
+	options PingOptions,
 ) ([]PingBatchResult, error) {
-	if err := validatePingBatchRequest(items, timeout, targetURL); err != nil {
+	options, err := normalizePingOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePingBatchRequest(items, options); err != nil {
 		return nil, err
 	}
 
@@ -77,6 +126,7 @@ func PingBatch(
 		prepared = append(prepared, preparedPingItem{
 			resultIndex: index,
 			outboundTag: outboundTag,
+			outbound:    namespaced[0],
 		})
 		mergedOutbounds = append(mergedOutbounds, namespaced...)
 	}
@@ -91,7 +141,21 @@ func PingBatch(
 	}
 	defer server.Close()
 
-	workerCount := len(prepared)
+	runPingJobs(server, prepared, options, results)
+	return results, nil
+}
+
+// runPingJobs probes the prepared items concurrently, at most
+// maxPingWorkers at a time, and stores each result at its resultIndex.
+// PingBatch never reaches the cap; outbound selection probes up to
+// maxSelectCandidates items in one instance.
+func runPingJobs(
+	server *core.Instance,
+	prepared []preparedPingItem,
+	options PingOptions,
+	results []PingBatchResult,
+) {
+	workerCount := min(len(prepared), maxPingWorkers)
 	jobs := make(chan preparedPingItem)
 	var workers sync.WaitGroup
 	workers.Add(workerCount)
@@ -102,20 +166,26 @@ func PingBatch(
 				delay, err := measureOutboundDelay(
 					server,
 					item.outboundTag,
-					timeout,
-					targetURL,
+					options,
 				)
-				if err != nil {
-					results[item.resultIndex] = failedPingBatchResult(
-						delay,
-						err,
-					)
-					continue
-				}
-				results[item.resultIndex] = PingBatchResult{
+				result := PingBatchResult{
 					Success: true,
 					Delay:   delay,
 				}
+				if err != nil {
+					result = failedPingBatchResult(delay, err)
+				}
+				if options.Direct {
+					delay, err := measureDirectDelay(
+						item.outbound,
+						options.Timeout,
+					)
+					result.DirectDelay = delay
+					if err != nil {
+						result.DirectError = err.Error()
+					}
+				}
+				results[item.resultIndex] = result
 			}
 		}()
 	}
@@ -125,14 +195,26 @@ func PingBatch(
 	}
 	close(jobs)
 	workers.Wait()
+}
 
-	return results, nil
+func normalizePingOptions(options PingOptions) (PingOptions, error) {
+	switch options.Mode {
+	case "":
+		options.Mode = PingModeHTTPHead
+	case PingModeHTTPHead, PingModeHTTPGet, PingModeTCPConnect:
+	case PingModeUDPDNS:
+		if options.Resolver == "" {
+			options.Resolver = defaultPingResolver
+		}
+	default:
+		return options, fmt.Errorf("unsupported ping mode %q", options.Mode)
+	}
+	return options, nil
 }
 
 func validatePingBatchRequest(
 	items []PingBatchItem,
-	timeout int,
-	targetURL string,
+	options PingOptions,
 ) error {
 	if len(items) == 0 {
 		return errors.New("ping batch configs are empty")
@@ -143,11 +225,26 @@ func validatePingBatchRequest(
 			maxPingBatchConfigs,
 		)
 	}
-	if timeout <= 0 {
+	return validatePingOptions(options)
+}
+
+func validatePingOptions(options PingOptions) error {
+	if options.Timeout <= 0 {
 		return errors.New("ping batch timeout must be greater than zero")
 	}
 
-	parsedURL, err := url.ParseRequestURI(targetURL)
+	switch options.Mode {
+	case PingModeUDPDNS:
+		_, err := parsePingResolver(options.Resolver)
+		return err
+	case PingModeTCPConnect:
+		_, err := parsePingTarget(options.Target)
+		return err
+	}
+	if err := validatePingHTTPCheck(options); err != nil {
+		return err
+	}
+	parsedURL, err := url.ParseRequestURI(options.URL)
 	if err != nil || parsedURL.Host == "" ||
 		(parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
 		return errors.New("ping batch URL must be an absolute HTTP or HTTPS URL")
@@ -189,18 +286,7 @@ func preparePingOutbounds(
 	requestedTag string,
 	itemIndex int,
 ) ([]conf.OutboundDetourConfig, string, error) {
-	tagIndexes := make(map[string]int, len(outbounds))
-	duplicateTags := make(map[string]struct{})
-	for index, outbound := range outbounds {
-		if outbound.Tag == "" {
-			continue
-		}
-		if _, found := tagIndexes[outbound.Tag]; found {
-			duplicateTags[outbound.Tag] = struct{}{}
-			continue
-		}
-		tagIndexes[outbound.Tag] = index
-	}
+	tagIndexes, duplicateTags := indexPingOutboundTags(outbounds)
 
 	targetIndex := 0
 	if requestedTag != "" {
@@ -218,7 +304,43 @@ func preparePingOutbounds(
 		}
 		targetIndex = proxyIndex
 	}
+	return namespacePingOutbounds(
+		outbounds,
+		tagIndexes,
+		duplicateTags,
+		targetIndex,
+		itemIndex,
+	)
+}
+
+func indexPingOutboundTags(
+	outbounds []conf.OutboundDetourConfig,
+) (map[string]int, map[string]struct{}) {
+	tagIndexes := make(map[string]int, len(outbounds))
+	duplicateTags := make(map[string]struct{})
+	for index, outbound := range outbounds {
+		if outbound.Tag == "" {
+			continue
+		}
+		if _, found := tagIndexes[outbound.Tag]; found {
+			duplicateTags[outbound.Tag] = struct{}{}
+			continue
+		}
+		tagIndexes[outbound.Tag] = index
+	}
+	return tagIndexes, duplicateTags
+}
 
+// namespacePingOutbounds copies the outbound at targetIndex and its
+// dependencies under tags unique to itemIndex, so several configs can share
+// one ping instance.
+func namespacePingOutbounds(
+	outbounds []conf.OutboundDetourConfig,
+	tagIndexes map[string]int,
+	duplicateTags map[string]struct{},
+	targetIndex int,
+	itemIndex int,
+) ([]conf.OutboundDetourConfig, string, error) {
 	selectedIndexes, err := collectPingOutboundDependencies(
 		outbounds,
 		tagIndexes,
@@ -242,6 +364,9 @@ func preparePingOutbounds(
 	for _, index := range selectedIndexes {
 		outbound := outbounds[index]
 		outbound.Tag = namespacedTags[index]
+		if pingOutboundDisplayName(outbound) != "" {
+			outbound.SendThrough = nil
+		}
 
 		if outbound.StreamSetting != nil &&
 			outbound.StreamSetting.SocketSettings != nil {
@@ -343,6 +468,23 @@ func pingOutboundDependencyTags(outbound conf.OutboundDetourConfig) []string {
 	return dependencies
 }
 
+// pingOutboundDisplayName returns the name that share link conversion keeps
+// in sendThrough. Values Xray accepts there are not names.
+func pingOutboundDisplayName(outbound conf.OutboundDetourConfig) string {
+	if outbound.SendThrough == nil {
+		return ""
+	}
+	value := *outbound.SendThrough
+	if value == "" || value == "origin" || value == "srcip" ||
+		net.ParseIP(value) != nil {
+		return ""
+	}
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return ""
+	}
+	return value
+}
+
 func startPingBatchServer(
 	outbounds []conf.OutboundDetourConfig,
 ) (*core.Instance, error) {
@@ -364,44 +506,41 @@ func startPingBatchServer(
 func measureOutboundDelay(
 	server *core.Instance,
 	outboundTag string,
-	timeout int,
-	targetURL string,
+	options PingOptions,
 ) (int64, error) {
-	httpTimeout := time.Second * time.Duration(timeout)
-	transport := &http.Transport{
-		DisableKeepAlives: true,
-		DialContext: func(
-			ctx context.Context,
-			network string,
-			address string,
-		) (net.Conn, error) {
-			if network != "tcp" && network != "tcp4" && network != "tcp6" {
-				return nil, fmt.Errorf("unsupported ping network %q", network)
-			}
-			destination, err := xrayNet.ParseDestination("tcp:" + address)
-			if err != nil {
-				return nil, err
-			}
-			ctx = session.SetForcedOutboundTagToContext(ctx, outboundTag)
-			return core.Dial(ctx, server, destination)
-		},
-	}
-	defer transport.CloseIdleConnections()
-
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   httpTimeout,
+	switch options.Mode {
+	case PingModeUDPDNS:
+		return measureOutboundUDPDelay(
+			server,
+			outboundTag,
+			options.Timeout,
+			options.Resolver,
+		)
+	case PingModeTCPConnect:
+		return measureOutboundTCPDelay(
+			server,
+			outboundTag,
+			options.Timeout,
+			options.Target,
+		)
+	default:
+		return measureOutboundHTTPDelay(server, outboundTag, options)
 	}
-	return nodep.PingHTTPRequest(client, targetURL, timeout)
 }
 
 func failedPingBatchResult(delay int64, err error) PingBatchResult {
 	if delay != nodep.PingDelayTimeout {
 		delay = nodep.PingDelayError
 	}
-	return PingBatchResult{
+	result := PingBatchResult{
 		Success: false,
 		Delay:   delay,
 		Error:   err.Error(),
 	}
+	var responseErr *pingHTTPResponseError
+	if errors.As(err, &responseErr) {
+		result.Reachable = true
+		result.StatusCode = responseErr.statusCode
+	}
+	return result
 }
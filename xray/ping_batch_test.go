@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -198,8 +199,7 @@ func TestPingBatchRunsRequestsConcurrently(t *testing.T) {
 				{XrayJSON: config},
 				{XrayJSON: config},
 			},
-			2,
-			server.URL,
+			PingOptions{Timeout: 2, URL: server.URL},
 		)
 		done <- batchResult{results: results, err: err}
 	}()
@@ -231,14 +231,88 @@ func TestPingBatchRunsRequestsConcurrently(t *testing.T) {
 	}
 }
 
+func TestRunPingJobsCapsWorkers(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		response.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	const items = maxPingWorkers * 3
+	outbounds := make([]conf.OutboundDetourConfig, items)
+	prepared := make([]preparedPingItem, items)
+	for index := range items {
+		tag := fmt.Sprintf("probe-%d", index)
+		outbounds[index] = conf.OutboundDetourConfig{Protocol: "freedom", Tag: tag}
+		prepared[index] = preparedPingItem{resultIndex: index, outboundTag: tag}
+	}
+	instance, err := startPingBatchServer(outbounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Close()
+
+	results := make([]PingBatchResult, items)
+	runPingJobs(instance, prepared, PingOptions{Timeout: 5, URL: server.URL, Mode: PingModeHTTPHead}, results)
+	for index, result := range results {
+		if !result.Success {
+			t.Fatalf("result %d failed: %s", index, result.Error)
+		}
+	}
+	if peak > maxPingWorkers {
+		t.Fatalf("%d probes ran at once, want at most %d", peak, maxPingWorkers)
+	}
+}
+
+func TestPingBatchIgnoresShareLinkNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		response.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// Share link conversion keeps the link name in sendThrough, which Xray
+	// would read as a source address and refuse to build.
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom","tag":"proxy","sendThrough":"Tokyo 01"}]}`},
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom","tag":"proxy","sendThrough":"192.0.2.1"}]}`},
+		},
+		PingOptions{Timeout: 2, URL: server.URL},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Success {
+		t.Fatalf("named outbound failed: %s", results[0].Error)
+	}
+	// A real source address is kept, and cannot be bound here.
+	if results[1].Success {
+		t.Fatal("sendThrough address was dropped")
+	}
+}
+
 func TestPingBatchKeepsPerItemConfigErrorsInInputOrder(t *testing.T) {
 	results, err := PingBatch(
 		[]PingBatchItem{
 			{XrayJSON: "not JSON"},
 			{XrayJSON: `{"outbounds":[]}`},
 		},
-		1,
-		"https://example.com",
+		PingOptions{Timeout: 1, URL: "https://example.com"},
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -269,23 +343,30 @@ func TestValidatePingBatchRequest(t *testing.T) {
 	tests := []struct {
 		name      string
 		items     []PingBatchItem
-		timeout   int
-		targetURL string
+		options   PingOptions
 		errorText string
 	}{
 		{
 			name:      "empty configs",
-			timeout:   1,
-			targetURL: "https://example.com",
+			options:   PingOptions{Timeout: 1, URL: "https://example.com"},
 			errorText: "configs are empty",
 		},
 		{
 			name:      "invalid URL",
 			items:     []PingBatchItem{{}},
-			timeout:   1,
-			targetURL: "example.com",
+			options:   PingOptions{Timeout: 1, URL: "example.com"},
 			errorText: "absolute HTTP",
 		},
+		{
+			name:  "invalid resolver",
+			items: []PingBatchItem{{}},
+			options: PingOptions{
+				Timeout:  1,
+				Mode:     PingModeUDPDNS,
+				Resolver: "dns.example:53",
+			},
+			errorText: "IP:port",
+		},
 		{
 			name: "too many configs",
 			items: []PingBatchItem{
@@ -296,19 +377,18 @@ func TestValidatePingBatchRequest(t *testing.T) {
 				{},
 				{},
 			},
-			timeout:   1,
-			targetURL: "https://example.com",
+			options:   PingOptions{Timeout: 1, URL: "https://example.com"},
 			errorText: "more than 5 configs",
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			err := validatePingBatchRequest(
-				test.items,
-				test.timeout,
-				test.targetURL,
-			)
+			options, err := normalizePingOptions(test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = validatePingBatchRequest(test.items, options)
 			if err == nil || !strings.Contains(err.Error(), test.errorText) {
 				t.Fatalf("error = %v, want %q", err, test.errorText)
 			}
@@ -325,8 +405,7 @@ func TestValidatePingBatchRequestAcceptsFiveConfigs(t *testing.T) {
 			{},
 			{},
 		},
-		1,
-		"https://example.com",
+		PingOptions{Timeout: 1, URL: "https://example.com"},
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -336,8 +415,7 @@ func TestValidatePingBatchRequestAcceptsFiveConfigs(t *testing.T) {
 func ExamplePingBatch() {
 	results, _ := PingBatch(
 		[]PingBatchItem{{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`}},
-		5,
-		"https://cp.cloudflare.com/",
+		PingOptions{Timeout: 5, URL: "https://cp.cloudflare.com/"},
 	)
 	fmt.Println(len(results))
 }
@@ -0,0 +1,153 @@
+package xray
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xtls/libxray/nodep"
+	"github.com/xtls/xray-core/core"
+	"golang.org/x/net/http/httpguts"
+)
+
+const maxPingHTTPBodyBytes = 1024 * 1024
+
+// pingHTTPResponseError means the target answered, but not as PingHTTPCheck
+// expects.
+type pingHTTPResponseError struct {
+	statusCode int
+	reason     string
+}
+
+func (e *pingHTTPResponseError) Error() string {
+	return fmt.Sprintf("unexpected HTTP %d response: %s", e.statusCode, e.reason)
+}
+
+func pingHTTPMethod(options PingOptions) string {
+	if options.HTTP.Method != "" {
+		return options.HTTP.Method
+	}
+	if options.Mode == PingModeHTTPGet {
+		return http.MethodGet
+	}
+	return http.MethodHead
+}
+
+func validatePingHTTPCheck(options PingOptions) error {
+	check := options.HTTP
+	method := pingHTTPMethod(options)
+	if !httpguts.ValidHeaderFieldName(method) {
+		return fmt.Errorf("invalid ping HTTP method %q", method)
+	}
+	for name, value := range check.Headers {
+		if !httpguts.ValidHeaderFieldName(name) ||
+			!httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid ping HTTP header %q", name)
+		}
+	}
+	for _, status := range check.ExpectedStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid expected HTTP status %d", status)
+		}
+	}
+	if check.BodySHA256 != "" {
+		digest, err := hex.DecodeString(check.BodySHA256)
+		if err != nil || len(digest) != sha256.Size {
+			return errors.New("ping body SHA-256 must be 64 hex characters")
+		}
+	}
+	if (check.BodyContains != "" || check.BodySHA256 != "") &&
+		method == http.MethodHead {
+		return errors.New("ping body checks cannot be used with HEAD")
+	}
+	return nil
+}
+
+func measureOutboundHTTPDelay(
+	server *core.Instance,
+	outboundTag string,
+	options PingOptions,
+) (int64, error) {
+	httpTimeout := time.Second * time.Duration(options.Timeout)
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		DialContext:       outboundDialContext(server, outboundTag),
+	}
+	defer transport.CloseIdleConnections()
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   httpTimeout,
+	}
+	request, err := http.NewRequest(pingHTTPMethod(options), options.URL, nil)
+	if err != nil {
+		return nodep.PingDelayError, err
+	}
+	for name, value := range options.HTTP.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			request.Host = value
+			continue
+		}
+		request.Header.Set(name, value)
+	}
+
+	start := time.Now()
+	response, err := client.Do(request)
+	delay := time.Since(start).Milliseconds()
+	if err != nil {
+		return nodep.PingFailureDelay(delay, options.Timeout), err
+	}
+	defer response.Body.Close()
+	if err := checkPingHTTPResponse(response, options.HTTP); err != nil {
+		return nodep.PingDelayError, err
+	}
+	return delay, nil
+}
+
+func checkPingHTTPResponse(response *http.Response, check PingHTTPCheck) error {
+	if len(check.ExpectedStatus) > 0 &&
+		!slices.Contains(check.ExpectedStatus, response.StatusCode) {
+		return &pingHTTPResponseError{
+			statusCode: response.StatusCode,
+			reason:     "status is not expected",
+		}
+	}
+	if check.BodyContains == "" && check.BodySHA256 == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxPingHTTPBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxPingHTTPBodyBytes {
+		return &pingHTTPResponseError{
+			statusCode: response.StatusCode,
+			reason:     "body exceeds the 1 MiB check limit",
+		}
+	}
+	if check.BodyContains != "" &&
+		!bytes.Contains(body, []byte(check.BodyContains)) {
+		return &pingHTTPResponseError{
+			statusCode: response.StatusCode,
+			reason:     "body does not contain the expected text",
+		}
+	}
+	if check.BodySHA256 != "" {
+		digest := sha256.Sum256(body)
+		if !strings.EqualFold(hex.EncodeToString(digest[:]), check.BodySHA256) {
+			return &pingHTTPResponseError{
+				statusCode: response.StatusCode,
+				reason:     "body SHA-256 does not match",
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package xray
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xtls/libxray/nodep"
+)
+
+func pingHTTPServerForTest(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		switch request.URL.Path {
+		case "/portal":
+			response.WriteHeader(http.StatusForbidden)
+			_, _ = response.Write([]byte("captive portal"))
+		case "/echo":
+			if request.Header.Get("X-Probe") != "libxray" ||
+				request.Host != "probe.example" {
+				response.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = response.Write([]byte(request.Method + " ok"))
+		default:
+			response.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func pingOneForTest(t *testing.T, options PingOptions) PingBatchResult {
+	t.Helper()
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`},
+		},
+		options,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results[0]
+}
+
+func TestPingBatchHTTPCheckAcceptsExpectedResponse(t *testing.T) {
+	server := pingHTTPServerForTest(t)
+	digest := sha256.Sum256([]byte("POST ok"))
+	result := pingOneForTest(t, PingOptions{
+		Timeout: 2,
+		URL:     server.URL + "/echo",
+		HTTP: PingHTTPCheck{
+			Method: http.MethodPost,
+			Headers: map[string]string{
+				"X-Probe": "libxray",
+				"Host":    "probe.example",
+			},
+			ExpectedStatus: []int{http.StatusOK},
+			BodyContains:   "ok",
+			BodySHA256:     hex.EncodeToString(digest[:]),
+		},
+	})
+	if !result.Success {
+		t.Fatalf("probe failed: %s", result.Error)
+	}
+	if result.Reachable || result.StatusCode != 0 {
+		t.Fatalf("successful probe reported %+v", result)
+	}
+}
+
+func TestPingBatchHTTPCheckMarksWrongResponseReachable(t *testing.T) {
+	server := pingHTTPServerForTest(t)
+	tests := []struct {
+		name      string
+		check     PingHTTPCheck
+		errorText string
+	}{
+		{
+			name:      "status",
+			check:     PingHTTPCheck{ExpectedStatus: []int{http.StatusNoContent}},
+			errorText: "status is not expected",
+		},
+		{
+			name: "body text",
+			check: PingHTTPCheck{
+				Method:       http.MethodGet,
+				BodyContains: "welcome",
+			},
+			errorText: "expected text",
+		},
+		{
+			name: "body hash",
+			check: PingHTTPCheck{
+				Method:     http.MethodGet,
+				BodySHA256: strings.Repeat("0", 64),
+			},
+			errorText: "SHA-256",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := pingOneForTest(t, PingOptions{
+				Timeout: 2,
+				URL:     server.URL + "/portal",
+				HTTP:    test.check,
+			})
+			if result.Success {
+				t.Fatal("wrong response unexpectedly succeeded")
+			}
+			if !result.Reachable || result.StatusCode != http.StatusForbidden {
+				t.Fatalf("reachable = %v, status = %d", result.Reachable, result.StatusCode)
+			}
+			if result.Delay != nodep.PingDelayError {
+				t.Fatalf("delay = %d, want %d", result.Delay, nodep.PingDelayError)
+			}
+			if !strings.Contains(result.Error, test.errorText) {
+				t.Fatalf("error = %q, want %q", result.Error, test.errorText)
+			}
+		})
+	}
+}
+
+func TestPingBatchHTTPCheckAcceptsAnyStatusByDefault(t *testing.T) {
+	server := pingHTTPServerForTest(t)
+	result := pingOneForTest(t, PingOptions{
+		Timeout: 2,
+		URL:     server.URL + "/portal",
+	})
+	if !result.Success {
+		t.Fatalf("probe failed: %s", result.Error)
+	}
+}
+
+func TestValidatePingHTTPCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   PingOptions
+		errorText string
+	}{
+		{
+			name:      "method",
+			options:   PingOptions{HTTP: PingHTTPCheck{Method: "GET /"}},
+			errorText: "invalid ping HTTP method",
+		},
+		{
+			name: "header",
+			options: PingOptions{HTTP: PingHTTPCheck{
+				Headers: map[string]string{"X-Probe": "a\nb"},
+			}},
+			errorText: "invalid ping HTTP header",
+		},
+		{
+			name:      "status",
+			options:   PingOptions{HTTP: PingHTTPCheck{ExpectedStatus: []int{42}}},
+			errorText: "invalid expected HTTP status",
+		},
+		{
+			name:      "hash",
+			options:   PingOptions{HTTP: PingHTTPCheck{Method: "GET", BodySHA256: "abc"}},
+			errorText: "64 hex characters",
+		},
+		{
+			name:      "body with HEAD",
+			options:   PingOptions{HTTP: PingHTTPCheck{BodyContains: "ok"}},
+			errorText: "cannot be used with HEAD",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePingHTTPCheck(test.options)
+			if err == nil || !strings.Contains(err.Error(), test.errorText) {
+				t.Fatalf("error = %v, want %q", err, test.errorText)
+			}
+		})
+	}
+
+	err := validatePingHTTPCheck(PingOptions{
+		Mode: PingModeHTTPGet,
+		HTTP: PingHTTPCheck{BodyContains: "ok"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,79 @@
+package xray
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xtls/libxray/nodep"
+	"github.com/xtls/xray-core/features/outbound"
+)
+
+// PingRunning probes outbounds of the instance started by RunXray instead of
+// creating a temporary instance, so it shares that instance's DNS and
+// process-wide state. Each tag is forced for its probe and bypasses routing.
+func PingRunning(
+	outboundTags []string,
+	options PingOptions,
+) ([]PingBatchResult, error) {
+	options, err := normalizePingOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePingRunningRequest(outboundTags, options); err != nil {
+		return nil, err
+	}
+
+	coreServerMu.Lock()
+	server := coreServer
+	coreServerMu.Unlock()
+	if server == nil || !server.IsRunning() {
+		return nil, ErrNotRunning
+	}
+	manager, ok := server.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if !ok {
+		return nil, errors.New("outbound manager is not registered in Xray core")
+	}
+
+	results := make([]PingBatchResult, len(outboundTags))
+	prepared := make([]preparedPingItem, 0, len(outboundTags))
+	for index, tag := range outboundTags {
+		if manager.GetHandler(tag) == nil {
+			results[index] = failedPingBatchResult(
+				nodep.PingDelayError,
+				fmt.Errorf("outbound tag %q not found", tag),
+			)
+			continue
+		}
+		prepared = append(prepared, preparedPingItem{
+			resultIndex: index,
+			outboundTag: tag,
+		})
+	}
+
+	runPingJobs(server, prepared, options, results)
+	return results, nil
+}
+
+func validatePingRunningRequest(
+	outboundTags []string,
+	options PingOptions,
+) error {
+	if len(outboundTags) == 0 {
+		return errors.New("ping running outbound tags are empty")
+	}
+	if len(outboundTags) > maxPingBatchConfigs {
+		return fmt.Errorf(
+			"ping running contains more than %d outbound tags",
+			maxPingBatchConfigs,
+		)
+	}
+	for _, tag := range outboundTags {
+		if tag == "" {
+			return errors.New("ping running outbound tag is empty")
+		}
+	}
+	if options.Direct {
+		return errors.New("direct baseline is not available for the running instance")
+	}
+	return validatePingOptions(options)
+}
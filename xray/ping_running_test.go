@@ -0,0 +1,107 @@
+package xray
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPingRunningUsesRunningInstanceOutbounds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		response.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if err := StopXray(); err != nil {
+		t.Fatalf("reset xray state: %v", err)
+	}
+	if err := RunXray(`{
+		"log": {"loglevel": "none"},
+		"outbounds": [
+			{"protocol": "freedom", "tag": "direct"},
+			{"protocol": "blackhole", "tag": "block"}
+		]
+	}`); err != nil {
+		t.Fatalf("start xray: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := StopXray(); err != nil {
+			t.Errorf("stop xray: %v", err)
+		}
+	})
+
+	results, err := PingRunning(
+		[]string{"direct", "block", "missing"},
+		PingOptions{Timeout: 1, URL: server.URL},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("results = %d, want 3", len(results))
+	}
+	if !results[0].Success {
+		t.Fatalf("direct failed: %s", results[0].Error)
+	}
+	if results[1].Success {
+		t.Fatal("blackhole unexpectedly succeeded")
+	}
+	if results[2].Error != `outbound tag "missing" not found` {
+		t.Fatalf("missing tag error = %q", results[2].Error)
+	}
+}
+
+func TestPingRunningRequiresRunningInstance(t *testing.T) {
+	if err := StopXray(); err != nil {
+		t.Fatalf("reset xray state: %v", err)
+	}
+	_, err := PingRunning(
+		[]string{"proxy"},
+		PingOptions{Timeout: 1, URL: "https://example.com"},
+	)
+	if !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("error = %v, want %v", err, ErrNotRunning)
+	}
+}
+
+func TestValidatePingRunningRequest(t *testing.T) {
+	options := PingOptions{Timeout: 1, URL: "https://example.com"}
+	tests := []struct {
+		name      string
+		tags      []string
+		options   PingOptions
+		errorText string
+	}{
+		{name: "empty", options: options, errorText: "tags are empty"},
+		{name: "empty tag", tags: []string{""}, options: options, errorText: "tag is empty"},
+		{
+			name:      "too many",
+			tags:      []string{"a", "b", "c", "d", "e", "f"},
+			options:   options,
+			errorText: "more than 5 outbound tags",
+		},
+		{
+			name: "direct",
+			tags: []string{"proxy"},
+			options: PingOptions{
+				Timeout: 1,
+				URL:     "https://example.com",
+				Direct:  true,
+			},
+			errorText: "direct baseline",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePingRunningRequest(test.tags, test.options)
+			if err == nil || !strings.Contains(err.Error(), test.errorText) {
+				t.Fatalf("error = %v, want %q", err, test.errorText)
+			}
+		})
+	}
+}
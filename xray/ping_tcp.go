@@ -0,0 +1,309 @@
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xtls/libxray/nodep"
+	"github.com/xtls/xray-core/common/buf"
+	xrayNet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/features/routing"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/proxy"
+	"github.com/xtls/xray-core/proxy/http"
+	"github.com/xtls/xray-core/transport"
+)
+
+func parsePingTarget(target string) (xrayNet.Destination, error) {
+	destination, err := xrayNet.ParseDestination("tcp:" + target)
+	if err != nil || destination.Port == 0 {
+		return xrayNet.Destination{}, errors.New(
+			"ping batch target must be a host:port TCP address",
+		)
+	}
+	return destination, nil
+}
+
+// measureOutboundTCPDelay opens a proxied TCP connection to target through
+// the forced outbound and stops the clock once the outbound has connected.
+// Outbounds read the data to send only after their dial completes, so the
+// first read of the uplink marks the connect: the target itself for freedom,
+// socks and http, and the proxy server for tunneling protocols, which do not
+// report the remote connect, so for them a success says nothing about
+// reaching target. Nothing is sent, so silent targets succeed.
+func measureOutboundTCPDelay(
+	server *core.Instance,
+	outboundTag string,
+	timeout int,
+	target string,
+) (int64, error) {
+	destination, err := parsePingTarget(target)
+	if err != nil {
+		return nodep.PingDelayError, err
+	}
+	dispatcher, ok := server.GetFeature(routing.DispatcherType()).(routing.Dispatcher)
+	if !ok {
+		return nodep.PingDelayError, errors.New("routing.Dispatcher is not registered in Xray core")
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Second*time.Duration(timeout),
+	)
+	defer cancel()
+	ctx = session.SetForcedOutboundTagToContext(ctx, outboundTag)
+	failure := &pingConnectFailure{}
+	ctx = session.TrackedConnectionError(ctx, failure)
+
+	uplink := &pingConnectReader{
+		connected: make(chan struct{}),
+		done:      ctx.Done(),
+	}
+	// The http outbound reads the first payload to send it with CONNECT,
+	// before it dials.
+	if pingOutboundIsHTTP(server, outboundTag) {
+		uplink.skip = 1
+	}
+	downlink := &pingConnectWriter{closed: make(chan struct{})}
+
+	// The outbound may close the downlink before it reads the uplink, as
+	// freedom does for a target that closes at once, so the probe fails
+	// only once the outbound has also returned.
+	finished := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(finished)
+		_ = dispatcher.DispatchLink(ctx, destination, &transport.Link{
+			Reader: uplink,
+			Writer: downlink,
+		})
+		select {
+		case <-downlink.closed:
+		case <-ctx.Done():
+		}
+	}()
+
+	select {
+	case <-uplink.connected:
+		return time.Since(start).Milliseconds(), nil
+	case <-finished:
+		select {
+		case <-uplink.connected:
+			return time.Since(start).Milliseconds(), nil
+		default:
+		}
+		if ctx.Err() != nil {
+			return pingConnectTimeout(target, ctx.Err())
+		}
+		if err := failure.get(); err != nil {
+			return nodep.PingDelayError, err
+		}
+		return nodep.PingDelayError, fmt.Errorf(
+			"outbound closed before connecting to %s",
+			target,
+		)
+	case <-ctx.Done():
+		return pingConnectTimeout(target, ctx.Err())
+	}
+}
+
+func pingConnectTimeout(target string, err error) (int64, error) {
+	return nodep.PingDelayTimeout, fmt.Errorf(
+		"no connection to %s: %w",
+		target,
+		err,
+	)
+}
+
+func pingOutboundIsHTTP(server *core.Instance, outboundTag string) bool {
+	manager, ok := server.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if !ok {
+		return false
+	}
+	handler, ok := manager.GetHandler(outboundTag).(interface {
+		GetOutbound() proxy.Outbound
+	})
+	if !ok {
+		return false
+	}
+	_, isHTTP := handler.GetOutbound().(*http.Client)
+	return isHTTP
+}
+
+// pingConnectReader is the uplink of a tcp-connect probe. It has nothing to
+// send: after the reads it skips, it reports the connect and blocks until
+// the probe ends.
+type pingConnectReader struct {
+	skip      int
+	reads     int
+	connected chan struct{}
+	done      <-chan struct{}
+}
+
+func (r *pingConnectReader) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	// Xray never reads one uplink from two goroutines at once.
+	r.reads++
+	if r.reads <= r.skip {
+		return nil, nil
+	}
+	if r.reads == r.skip+1 {
+		close(r.connected)
+	}
+	<-r.done
+	return nil, io.EOF
+}
+
+// pingConnectWriter discards what the target sends and reports when the
+// outbound is done with the link.
+type pingConnectWriter struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (w *pingConnectWriter) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	buf.ReleaseMulti(mb)
+	return nil
+}
+
+func (w *pingConnectWriter) Close() error {
+	w.close()
+	return nil
+}
+
+func (w *pingConnectWriter) Interrupt() {
+	w.close()
+}
+
+func (w *pingConnectWriter) close() {
+	w.once.Do(func() { close(w.closed) })
+}
+
+// pingConnectFailure keeps the first error the outbound reports.
+type pingConnectFailure struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (f *pingConnectFailure) SubmitError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *pingConnectFailure) get() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// pingServerSettings covers the server address shapes used by Xray client
+// outbounds: the flattened form and the vnext or servers lists.
+type pingServerSettings struct {
+	Address *conf.Address        `json:"address"`
+	Port    uint16               `json:"port"`
+	Vnext   []pingServerEndpoint `json:"vnext"`
+	Servers []pingServerEndpoint `json:"servers"`
+}
+
+type pingServerEndpoint struct {
+	Address *conf.Address `json:"address"`
+	Port    uint16        `json:"port"`
+}
+
+func pingOutboundServer(outbound conf.OutboundDetourConfig) (string, error) {
+	switch outbound.Protocol {
+	case "hysteria", "wireguard":
+		return "", fmt.Errorf(
+			"direct baseline does not support %s outbounds",
+			outbound.Protocol,
+		)
+	}
+	if outbound.StreamSetting != nil && outbound.StreamSetting.Network != nil {
+		switch *outbound.StreamSetting.Network {
+		case "hysteria", "kcp", "mkcp":
+			return "", fmt.Errorf(
+				"direct baseline does not support %s transport",
+				*outbound.StreamSetting.Network,
+			)
+		}
+	}
+	if outbound.Settings == nil {
+		return "", fmt.Errorf("%s outbound has no server address", outbound.Protocol)
+	}
+
+	var settings pingServerSettings
+	if err := json.Unmarshal(*outbound.Settings, &settings); err != nil {
+		return "", err
+	}
+	endpoint := pingServerEndpoint{Address: settings.Address, Port: settings.Port}
+	if endpoint.Address == nil && len(settings.Vnext) > 0 {
+		endpoint = settings.Vnext[0]
+	}
+	if endpoint.Address == nil && len(settings.Servers) > 0 {
+		endpoint = settings.Servers[0]
+	}
+	if endpoint.Address == nil || endpoint.Port == 0 {
+		return "", fmt.Errorf("%s outbound has no server address", outbound.Protocol)
+	}
+	return xrayNet.TCPDestination(
+		endpoint.Address.Address,
+		xrayNet.Port(endpoint.Port),
+	).NetAddr(), nil
+}
+
+// measureDirectDelay connects to the outbound's server without any proxy.
+// The server name is resolved before the clock starts, so the delay covers
+// only the TCP handshake.
+func measureDirectDelay(
+	outbound conf.OutboundDetourConfig,
+	timeout int,
+) (int64, error) {
+	address, err := pingOutboundServer(outbound)
+	if err != nil {
+		return nodep.PingDelayError, err
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nodep.PingDelayError, err
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Second*time.Duration(timeout),
+	)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nodep.PingDelayError, err
+	}
+	if len(ips) == 0 {
+		return nodep.PingDelayError, fmt.Errorf("no address found for %s", host)
+	}
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(
+		ctx,
+		"tcp",
+		net.JoinHostPort(ips[0].String(), port),
+	)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nodep.PingDelayTimeout, err
+		}
+		return nodep.PingDelayError, err
+	}
+	_ = conn.Close()
+	return time.Since(start).Milliseconds(), nil
+}
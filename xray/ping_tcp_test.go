@@ -0,0 +1,309 @@
+package xray
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xtls/libxray/nodep"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func startTCPServerForTest(t *testing.T, banner string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if banner != "" {
+				_, _ = conn.Write([]byte(banner))
+			}
+			_ = conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestPingBatchTCPConnectThroughOutbound(t *testing.T) {
+	target := startTCPServerForTest(t, "SSH-2.0-test\r\n")
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom","tag":"proxy"}]}`},
+		},
+		PingOptions{
+			Timeout: 2,
+			Mode:    PingModeTCPConnect,
+			Target:  target,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Success {
+		t.Fatalf("tcp-connect failed: %s", results[0].Error)
+	}
+}
+
+func TestPingBatchTCPConnectSucceedsOnSilentTarget(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Keep the connection open without sending anything.
+			defer conn.Close()
+		}
+	}()
+	closedTarget := startTCPServerForTest(t, "")
+
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`},
+		},
+		PingOptions{
+			Timeout: 2,
+			Mode:    PingModeTCPConnect,
+			Target:  listener.Addr().String(),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Success {
+		t.Fatalf("silent target failed: %s", results[0].Error)
+	}
+
+	results, err = PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`},
+		},
+		PingOptions{Timeout: 2, Mode: PingModeTCPConnect, Target: closedTarget},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Success {
+		t.Fatalf("target that closes after accepting failed: %s", results[0].Error)
+	}
+}
+
+func TestPingBatchTCPConnectFailsOnRefusedTarget(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := listener.Addr().String()
+	_ = listener.Close()
+
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`},
+		},
+		PingOptions{Timeout: 2, Mode: PingModeTCPConnect, Target: target},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Success {
+		t.Fatal("refused target unexpectedly succeeded")
+	}
+}
+
+func TestPingBatchHTTPGetUsesGet(t *testing.T) {
+	methods := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		methods <- request.Method
+		response.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`},
+		},
+		PingOptions{Timeout: 2, URL: server.URL, Mode: PingModeHTTPGet},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Success {
+		t.Fatalf("http-get failed: %s", results[0].Error)
+	}
+	if method := <-methods; method != http.MethodGet {
+		t.Fatalf("method = %q, want GET", method)
+	}
+}
+
+func TestPingBatchDirectBaseline(t *testing.T) {
+	host, port, err := net.SplitHostPort(startTCPServerForTest(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xrayJSON := `{"outbounds":[{"protocol":"socks","settings":{"address":"` +
+		host + `","port":` + port + `}}]}`
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: xrayJSON},
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`},
+		},
+		PingOptions{
+			Timeout: 2,
+			Mode:    PingModeTCPConnect,
+			Target:  "127.0.0.1:1",
+			Direct:  true,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].DirectError != "" {
+		t.Fatalf("direct baseline failed: %s", results[0].DirectError)
+	}
+	if results[0].DirectDelay >= nodep.PingDelayError {
+		t.Fatalf("direct delay = %d", results[0].DirectDelay)
+	}
+	if !strings.Contains(results[1].DirectError, "no server address") {
+		t.Fatalf("freedom direct error = %q", results[1].DirectError)
+	}
+}
+
+func TestPingOutboundServer(t *testing.T) {
+	tests := []struct {
+		name      string
+		outbound  string
+		address   string
+		errorText string
+	}{
+		{
+			name:     "flattened",
+			outbound: `{"protocol":"vless","settings":{"address":"example.com","port":443,"id":"x"}}`,
+			address:  "example.com:443",
+		},
+		{
+			name:     "vnext",
+			outbound: `{"protocol":"vmess","settings":{"vnext":[{"address":"192.0.2.1","port":8443}]}}`,
+			address:  "192.0.2.1:8443",
+		},
+		{
+			name:     "servers",
+			outbound: `{"protocol":"trojan","settings":{"servers":[{"address":"2001:db8::1","port":443}]}}`,
+			address:  "[2001:db8::1]:443",
+		},
+		{
+			name:      "hysteria",
+			outbound:  `{"protocol":"hysteria","settings":{"address":"example.com","port":443}}`,
+			errorText: "does not support hysteria",
+		},
+		{
+			name:      "kcp transport",
+			outbound:  `{"protocol":"vless","settings":{"address":"example.com","port":443},"streamSettings":{"network":"kcp"}}`,
+			errorText: "does not support kcp",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var outbound conf.OutboundDetourConfig
+			if err := json.Unmarshal([]byte(test.outbound), &outbound); err != nil {
+				t.Fatal(err)
+			}
+			address, err := pingOutboundServer(outbound)
+			if test.errorText != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errorText) {
+					t.Fatalf("error = %v, want %q", err, test.errorText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if address != test.address {
+				t.Fatalf("address = %q, want %q", address, test.address)
+			}
+		})
+	}
+}
+
+func TestValidatePingBatchRequestRequiresTCPTarget(t *testing.T) {
+	for _, target := range []string{"", "example.com", "example.com:0"} {
+		err := validatePingBatchRequest(
+			[]PingBatchItem{{}},
+			PingOptions{Timeout: 1, Mode: PingModeTCPConnect, Target: target},
+		)
+		if err == nil || !strings.Contains(err.Error(), "host:port") {
+			t.Fatalf("target %q error = %v", target, err)
+		}
+	}
+	err := validatePingBatchRequest(
+		[]PingBatchItem{{}},
+		PingOptions{
+			Timeout: 1,
+			Mode:    PingModeTCPConnect,
+			Target:  "example.com:443",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPingBatchTCPConnectThroughHTTPProxy(t *testing.T) {
+	target := startTCPServerForTest(t, "")
+	connects := make(chan string, 1)
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		connects <- request.Host
+		response.WriteHeader(http.StatusOK)
+		conn, _, err := response.(http.Hijacker).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer proxyServer.Close()
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(proxyServer.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := PingBatch(
+		[]PingBatchItem{{
+			XrayJSON: `{"outbounds":[{"protocol":"http","settings":{"address":"` +
+				host + `","port":` + port + `}}]}`,
+		}},
+		PingOptions{Timeout: 2, Mode: PingModeTCPConnect, Target: target},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Success {
+		t.Fatalf("tcp-connect through http proxy failed: %s", results[0].Error)
+	}
+	select {
+	case host := <-connects:
+		if host != target {
+			t.Fatalf("CONNECT host = %q, want %q", host, target)
+		}
+	default:
+		t.Fatal("the probe ended before the proxy got CONNECT")
+	}
+}
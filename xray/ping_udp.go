@@ -0,0 +1,121 @@
+package xray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/xtls/libxray/nodep"
+	xrayNet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/core"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const maxPingDNSResponseBytes = 4096
+
+func parsePingResolver(resolver string) (xrayNet.Destination, error) {
+	destination, err := xrayNet.ParseDestination("udp:" + resolver)
+	if err != nil || destination.Port == 0 ||
+		!destination.Address.Family().IsIP() {
+		return xrayNet.Destination{}, errors.New(
+			"ping batch resolver must be an IP:port UDP address",
+		)
+	}
+	return destination, nil
+}
+
+// measureOutboundUDPDelay sends one DNS query to resolver through the forced
+// outbound and returns the round trip of the first datagram carrying the
+// same query ID. A plain UDP echo therefore also counts as a reply.
+func measureOutboundUDPDelay(
+	server *core.Instance,
+	outboundTag string,
+	timeout int,
+	resolver string,
+) (int64, error) {
+	destination, err := parsePingResolver(resolver)
+	if err != nil {
+		return nodep.PingDelayError, err
+	}
+	query, queryID, err := newPingDNSQuery()
+	if err != nil {
+		return nodep.PingDelayError, err
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Second*time.Duration(timeout),
+	)
+	defer cancel()
+	ctx = session.SetForcedOutboundTagToContext(ctx, outboundTag)
+
+	conn, err := core.DialUDP(ctx, server)
+	if err != nil {
+		return nodep.PingDelayError, err
+	}
+	defer conn.Close()
+	// The dispatcher connection ignores deadlines, so closing it is the only
+	// way to release a pending read once the timeout expires.
+	stopClose := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stopClose()
+
+	address := &net.UDPAddr{
+		IP:   destination.Address.IP(),
+		Port: int(destination.Port),
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo(query, address); err != nil {
+		return nodep.PingDelayError, err
+	}
+	response := make([]byte, maxPingDNSResponseBytes)
+	for {
+		n, _, err := conn.ReadFrom(response)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nodep.PingDelayTimeout, fmt.Errorf(
+					"no UDP reply from %s: %w",
+					resolver,
+					ctx.Err(),
+				)
+			}
+			return nodep.PingDelayError, err
+		}
+		if pingDNSResponseMatches(response[:n], queryID) {
+			return time.Since(start).Milliseconds(), nil
+		}
+	}
+}
+
+func newPingDNSQuery() ([]byte, uint16, error) {
+	queryID := uint16(rand.Uint32())
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               queryID,
+		RecursionDesired: true,
+	})
+	if err := builder.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := builder.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("."),
+		Type:  dnsmessage.TypeNS,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, 0, err
+	}
+	query, err := builder.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+	return query, queryID, nil
+}
+
+func pingDNSResponseMatches(response []byte, queryID uint16) bool {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	return err == nil && header.ID == queryID
+}
@@ -0,0 +1,150 @@
+package xray
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/xtls/libxray/nodep"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func startUDPServerForTest(
+	t *testing.T,
+	reply func([]byte) []byte,
+) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, address, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if response := reply(buffer[:n]); response != nil {
+				_, _ = conn.WriteTo(response, address)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func dnsStubReply(query []byte) []byte {
+	var message dnsmessage.Message
+	if err := message.Unpack(query); err != nil {
+		return nil
+	}
+	message.Header.Response = true
+	response, err := message.Pack()
+	if err != nil {
+		return nil
+	}
+	return response
+}
+
+func TestPingBatchUDPDNSThroughOutbound(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		reply func([]byte) []byte
+	}{
+		{name: "dns stub", reply: dnsStubReply},
+		{name: "echo", reply: func(query []byte) []byte { return query }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := startUDPServerForTest(t, test.reply)
+			results, err := PingBatch(
+				[]PingBatchItem{
+					{XrayJSON: `{"outbounds":[{"protocol":"freedom","tag":"proxy"}]}`},
+				},
+				PingOptions{
+					Timeout:  2,
+					Mode:     PingModeUDPDNS,
+					Resolver: resolver,
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !results[0].Success {
+				t.Fatalf("UDP probe failed: %s", results[0].Error)
+			}
+		})
+	}
+}
+
+func TestPingBatchUDPDNSTimeout(t *testing.T) {
+	resolver := startUDPServerForTest(t, func([]byte) []byte { return nil })
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`},
+		},
+		PingOptions{
+			Timeout:  1,
+			Mode:     PingModeUDPDNS,
+			Resolver: resolver,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Success {
+		t.Fatal("silent resolver unexpectedly succeeded")
+	}
+	if results[0].Delay != nodep.PingDelayTimeout {
+		t.Fatalf("delay = %d, want %d", results[0].Delay, nodep.PingDelayTimeout)
+	}
+}
+
+func TestPingBatchUDPDNSIgnoresMismatchedReplies(t *testing.T) {
+	resolver := startUDPServerForTest(t, func(query []byte) []byte {
+		response := dnsStubReply(query)
+		if response != nil {
+			response[0] ^= 0xff
+		}
+		return response
+	})
+	results, err := PingBatch(
+		[]PingBatchItem{
+			{XrayJSON: `{"outbounds":[{"protocol":"freedom"}]}`},
+		},
+		PingOptions{
+			Timeout:  1,
+			Mode:     PingModeUDPDNS,
+			Resolver: resolver,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Success {
+		t.Fatal("reply with another query ID unexpectedly succeeded")
+	}
+}
+
+func TestNormalizePingOptions(t *testing.T) {
+	options, err := normalizePingOptions(PingOptions{Mode: PingModeUDPDNS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Resolver != defaultPingResolver {
+		t.Fatalf("resolver = %q, want %q", options.Resolver, defaultPingResolver)
+	}
+
+	options, err = normalizePingOptions(PingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Mode != PingModeHTTPHead {
+		t.Fatalf("mode = %q, want %q", options.Mode, PingModeHTTPHead)
+	}
+
+	_, err = normalizePingOptions(PingOptions{Mode: "icmp"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported ping mode") {
+		t.Fatalf("error = %v, want unsupported mode", err)
+	}
+}
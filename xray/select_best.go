@@ -0,0 +1,474 @@
+package xray
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/xtls/libxray/nodep"
+	"github.com/xtls/xray-core/infra/conf"
+	confJSON "github.com/xtls/xray-core/infra/conf/json"
+)
+
+const (
+	defaultSelectRounds = 3
+	maxSelectRounds     = 10
+	maxSelectCandidates = 200
+	selectedOutboundTag = "proxy"
+)
+
+type SelectPolicy string
+
+const (
+	SelectPolicyLowestMedian   SelectPolicy = "lowest-median"
+	SelectPolicyThreshold      SelectPolicy = "threshold"
+	SelectPolicyWeightedRandom SelectPolicy = "weighted-random"
+)
+
+// SelectOptions controls SelectBestOutbound. Every candidate is probed
+// Rounds times with Ping. MaxDelay is the latency limit in milliseconds of
+// SelectPolicyThreshold. EmitConfig asks for a config that runs the winner.
+type SelectOptions struct {
+	Ping       PingOptions
+	Policy     SelectPolicy
+	Rounds     int
+	MaxDelay   int64
+	EmitConfig bool
+}
+
+// OutboundRank is the probe summary of one candidate. Index is the position
+// in the input outbounds and Name is the share link name, if any.
+// MedianDelay is nodep.PingDelayError when every probe failed.
+type OutboundRank struct {
+	Index       int
+	Tag         string
+	Name        string
+	MedianDelay int64
+	Successes   int
+	Failures    int
+	Error       string
+}
+
+// SelectResult holds the ranking, best first. Selected is false when no
+// candidate satisfies the policy. XrayJSON is set when EmitConfig was
+// requested and a candidate was selected.
+type SelectResult struct {
+	Selected bool
+	Index    int
+	Tag      string
+	Ranking  []OutboundRank
+	XrayJSON string
+}
+
+// SelectBestOutbound probes the proxy outbounds of xrayJSON in one temporary
+// instance and picks one by policy. Freedom, blackhole, DNS, and loopback
+// outbounds are not candidates.
+func SelectBestOutbound(
+	xrayJSON string,
+	options SelectOptions,
+) (SelectResult, error) {
+	options, err := normalizeSelectOptions(options)
+	if err != nil {
+		return SelectResult{}, err
+	}
+	outbounds, err := readPingOutbounds(xrayJSON)
+	if err != nil {
+		return SelectResult{}, err
+	}
+
+	candidates := make([]int, 0, len(outbounds))
+	for index, outbound := range outbounds {
+		if isSelectCandidate(outbound) {
+			candidates = append(candidates, index)
+		}
+	}
+	if len(candidates) == 0 {
+		return SelectResult{}, errors.New("config has no proxy outbound to select")
+	}
+	if len(candidates) > maxSelectCandidates {
+		return SelectResult{}, fmt.Errorf(
+			"config contains more than %d proxy outbounds",
+			maxSelectCandidates,
+		)
+	}
+
+	ranks := make([]OutboundRank, len(outbounds))
+	delays := make([][]int64, len(outbounds))
+	prepared := make([]preparedPingItem, 0, len(candidates))
+	mergedOutbounds := make([]conf.OutboundDetourConfig, 0, len(outbounds))
+	tagIndexes, duplicateTags := indexPingOutboundTags(outbounds)
+	for _, index := range candidates {
+		ranks[index] = OutboundRank{
+			Index:       index,
+			Tag:         outbounds[index].Tag,
+			Name:        pingOutboundDisplayName(outbounds[index]),
+			MedianDelay: nodep.PingDelayError,
+		}
+		namespaced, outboundTag, err := namespacePingOutbounds(
+			outbounds,
+			tagIndexes,
+			duplicateTags,
+			index,
+			index,
+		)
+		if err != nil {
+			ranks[index].Failures = options.Rounds
+			ranks[index].Error = err.Error()
+			continue
+		}
+		prepared = append(prepared, preparedPingItem{
+			resultIndex: index,
+			outboundTag: outboundTag,
+			outbound:    namespaced[0],
+		})
+		mergedOutbounds = append(mergedOutbounds, namespaced...)
+	}
+
+	if len(prepared) > 0 {
+		server, err := startPingBatchServer(mergedOutbounds)
+		if err != nil {
+			return SelectResult{}, err
+		}
+		defer server.Close()
+
+		results := make([]PingBatchResult, len(outbounds))
+		for range options.Rounds {
+			runPingJobs(server, prepared, options.Ping, results)
+			for _, item := range prepared {
+				result := results[item.resultIndex]
+				if result.Success {
+					delays[item.resultIndex] = append(delays[item.resultIndex], result.Delay)
+					continue
+				}
+				ranks[item.resultIndex].Failures++
+				ranks[item.resultIndex].Error = result.Error
+			}
+		}
+	}
+
+	ranking := make([]OutboundRank, 0, len(candidates))
+	for _, index := range candidates {
+		rank := ranks[index]
+		rank.Successes = len(delays[index])
+		if rank.Successes > 0 {
+			rank.MedianDelay = medianDelay(delays[index])
+		}
+		ranking = append(ranking, rank)
+	}
+	sortOutboundRanking(ranking, options)
+
+	selection := SelectResult{Index: -1, Ranking: ranking}
+	winner, found := pickOutboundRank(ranking, options)
+	if !found {
+		return selection, nil
+	}
+	selection.Selected = true
+	selection.Index = winner.Index
+	selection.Tag = winner.Tag
+	if options.EmitConfig {
+		selection.XrayJSON, err = emitSelectedConfig(
+			xrayJSON,
+			outbounds,
+			tagIndexes,
+			duplicateTags,
+			winner.Index,
+		)
+		if err != nil {
+			return SelectResult{}, err
+		}
+	}
+	return selection, nil
+}
+
+func normalizeSelectOptions(options SelectOptions) (SelectOptions, error) {
+	switch options.Policy {
+	case "":
+		options.Policy = SelectPolicyLowestMedian
+	case SelectPolicyLowestMedian, SelectPolicyWeightedRandom:
+	case SelectPolicyThreshold:
+		if options.MaxDelay <= 0 {
+			return options, errors.New("threshold policy needs a max delay greater than zero")
+		}
+	default:
+		return options, fmt.Errorf("unsupported select policy %q", options.Policy)
+	}
+	if options.Rounds == 0 {
+		options.Rounds = defaultSelectRounds
+	}
+	if options.Rounds < 0 || options.Rounds > maxSelectRounds {
+		return options, fmt.Errorf("select rounds must be between 1 and %d", maxSelectRounds)
+	}
+	if options.Ping.Direct {
+		return options, errors.New("direct baseline is not available for outbound selection")
+	}
+
+	ping, err := normalizePingOptions(options.Ping)
+	if err != nil {
+		return options, err
+	}
+	if err := validatePingOptions(ping); err != nil {
+		return options, err
+	}
+	options.Ping = ping
+	return options, nil
+}
+
+func isSelectCandidate(outbound conf.OutboundDetourConfig) bool {
+	switch strings.ToLower(outbound.Protocol) {
+	case "freedom", "direct", "blackhole", "block", "dns", "loopback":
+		return false
+	}
+	return true
+}
+
+func medianDelay(delays []int64) int64 {
+	sorted := slices.Clone(delays)
+	slices.Sort(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// sortOutboundRanking orders candidates that answered at least once by
+// median delay, then by failures. The threshold policy ranks candidates
+// within MaxDelay first and prefers fewer failures among them.
+func sortOutboundRanking(ranking []OutboundRank, options SelectOptions) {
+	slices.SortStableFunc(ranking, func(a, b OutboundRank) int {
+		if (a.Successes > 0) != (b.Successes > 0) {
+			if a.Successes > 0 {
+				return -1
+			}
+			return 1
+		}
+		if options.Policy == SelectPolicyThreshold {
+			aWithin := a.Successes > 0 && a.MedianDelay <= options.MaxDelay
+			bWithin := b.Successes > 0 && b.MedianDelay <= options.MaxDelay
+			if aWithin != bWithin {
+				if aWithin {
+					return -1
+				}
+				return 1
+			}
+			if aWithin && a.Failures != b.Failures {
+				return a.Failures - b.Failures
+			}
+		}
+		if a.MedianDelay != b.MedianDelay {
+			if a.MedianDelay < b.MedianDelay {
+				return -1
+			}
+			return 1
+		}
+		return a.Failures - b.Failures
+	})
+}
+
+// pickOutboundRank returns the winner of a sorted ranking. The weighted
+// random policy weighs each candidate by its success ratio divided by its
+// median delay.
+func pickOutboundRank(
+	ranking []OutboundRank,
+	options SelectOptions,
+) (OutboundRank, bool) {
+	if len(ranking) == 0 || ranking[0].Successes == 0 {
+		return OutboundRank{}, false
+	}
+	switch options.Policy {
+	case SelectPolicyThreshold:
+		if ranking[0].MedianDelay > options.MaxDelay {
+			return OutboundRank{}, false
+		}
+	case SelectPolicyWeightedRandom:
+		weights := make([]float64, 0, len(ranking))
+		var total float64
+		for _, rank := range ranking {
+			if rank.Successes == 0 {
+				break
+			}
+			weight := float64(rank.Successes) /
+				float64(options.Rounds) /
+				float64(max(rank.MedianDelay, 1))
+			weights = append(weights, weight)
+			total += weight
+		}
+		point := rand.Float64() * total
+		for index, weight := range weights {
+			if point < weight {
+				return ranking[index], true
+			}
+			point -= weight
+		}
+		return ranking[len(weights)-1], true
+	}
+	return ranking[0], true
+}
+
+// emitSelectedConfig rewrites xrayJSON so the winner, tagged proxy, is the
+// first outbound. Its dependencies and the non-proxy outbounds are kept;
+// other candidates are dropped, and routing rules that named the winner or
+// a dropped candidate now name proxy. Every other top-level field is
+// unchanged.
+func emitSelectedConfig(
+	xrayJSON string,
+	outbounds []conf.OutboundDetourConfig,
+	tagIndexes map[string]int,
+	duplicateTags map[string]struct{},
+	winnerIndex int,
+) (string, error) {
+	var root map[string]json.RawMessage
+	decoder := json.NewDecoder(&confJSON.Reader{Reader: strings.NewReader(xrayJSON)})
+	if err := decoder.Decode(&root); err != nil {
+		return "", err
+	}
+	var rawOutbounds []json.RawMessage
+	if err := json.Unmarshal(root["outbounds"], &rawOutbounds); err != nil {
+		return "", err
+	}
+	if len(rawOutbounds) != len(outbounds) {
+		return "", errors.New("outbounds changed while emitting selected config")
+	}
+
+	selectedIndexes, err := collectPingOutboundDependencies(
+		outbounds,
+		tagIndexes,
+		duplicateTags,
+		winnerIndex,
+	)
+	if err != nil {
+		return "", err
+	}
+	kept := slices.Clone(selectedIndexes)
+	for index, outbound := range outbounds {
+		if !isSelectCandidate(outbound) && !slices.Contains(kept, index) {
+			kept = append(kept, index)
+		}
+	}
+
+	emitted := make([]json.RawMessage, 0, len(kept))
+	for _, index := range kept {
+		if index != winnerIndex && outbounds[index].Tag == selectedOutboundTag {
+			return "", fmt.Errorf(
+				"outbound tag %q is already used by another kept outbound",
+				selectedOutboundTag,
+			)
+		}
+		if index != winnerIndex && pingOutboundDisplayName(outbounds[index]) == "" {
+			emitted = append(emitted, rawOutbounds[index])
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rawOutbounds[index], &fields); err != nil {
+			return "", err
+		}
+		if pingOutboundDisplayName(outbounds[index]) != "" {
+			delete(fields, "sendThrough")
+		}
+		if index == winnerIndex {
+			fields["tag"] = json.RawMessage(`"` + selectedOutboundTag + `"`)
+		}
+		raw, err := json.Marshal(fields)
+		if err != nil {
+			return "", err
+		}
+		emitted = append(emitted, raw)
+	}
+
+	raw, err := json.Marshal(emitted)
+	if err != nil {
+		return "", err
+	}
+	root["outbounds"] = raw
+
+	retargeted := make(map[string]bool)
+	for index, outbound := range outbounds {
+		if outbound.Tag == "" || outbound.Tag == selectedOutboundTag {
+			continue
+		}
+		if index == winnerIndex ||
+			(isSelectCandidate(outbound) && !slices.Contains(kept, index)) {
+			retargeted[outbound.Tag] = true
+		}
+	}
+	// A dropped candidate may share its tag with a kept outbound.
+	for _, index := range kept {
+		if index != winnerIndex {
+			delete(retargeted, outbounds[index].Tag)
+		}
+	}
+	if routing, found := root["routing"]; found && len(retargeted) > 0 {
+		if root["routing"], err = retargetSelectedRouting(routing, retargeted); err != nil {
+			return "", err
+		}
+	}
+	config, err := json.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+	return string(config), nil
+}
+
+// retargetSelectedRouting points the routing rules whose outboundTag is in
+// retargeted at selectedOutboundTag. Balancers select outbounds by tag
+// prefix, which cannot be rewritten the same way, so a balancer that
+// selects a retargeted tag is rejected.
+func retargetSelectedRouting(
+	rawRouting json.RawMessage,
+	retargeted map[string]bool,
+) (json.RawMessage, error) {
+	var routing map[string]json.RawMessage
+	if err := json.Unmarshal(rawRouting, &routing); err != nil || routing == nil {
+		return rawRouting, err
+	}
+
+	if rawBalancers, found := routing["balancers"]; found {
+		var balancers []struct {
+			Tag      string   `json:"tag"`
+			Selector []string `json:"selector"`
+		}
+		if err := json.Unmarshal(rawBalancers, &balancers); err != nil {
+			return nil, err
+		}
+		for _, balancer := range balancers {
+			for _, selector := range balancer.Selector {
+				for tag := range retargeted {
+					if strings.HasPrefix(tag, selector) {
+						return nil, fmt.Errorf(
+							"balancer %q selects outbound %q, which the selected config retags or drops",
+							balancer.Tag,
+							tag,
+						)
+					}
+				}
+			}
+		}
+	}
+
+	rawRules, found := routing["rules"]
+	if !found {
+		return rawRouting, nil
+	}
+	var rules []map[string]json.RawMessage
+	if err := json.Unmarshal(rawRules, &rules); err != nil {
+		return nil, err
+	}
+	selectedTag, err := json.Marshal(selectedOutboundTag)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		var tag string
+		if err := json.Unmarshal(rule["outboundTag"], &tag); err == nil && retargeted[tag] {
+			rule["outboundTag"] = selectedTag
+		}
+	}
+	if routing["rules"], err = json.Marshal(rules); err != nil {
+		return nil, err
+	}
+	return json.Marshal(routing)
+}
@@ -0,0 +1,294 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xtls/libxray/nodep"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// startSocksProxyForTest starts an Xray SOCKS inbound that forwards through
+// freedom and returns its port.
+func startSocksProxyForTest(t *testing.T) int {
+	t.Helper()
+	ports, err := nodep.GetFreePorts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config conf.Config
+	if err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"log": {"loglevel": "none"},
+		"inbounds": [{
+			"listen": "127.0.0.1",
+			"port": %d,
+			"protocol": "socks",
+			"settings": {"auth": "noauth"}
+		}],
+		"outbounds": [{"protocol": "freedom"}]
+	}`, ports[0])), &config); err != nil {
+		t.Fatal(err)
+	}
+	built, err := config.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := core.New(built)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	return ports[0]
+}
+
+func selectConfigForTest(t *testing.T) string {
+	t.Helper()
+	closedPort := startTCPServerForTest(t, "")
+	_, closedPortText, _ := strings.Cut(closedPort, ":")
+	return fmt.Sprintf(`{
+		"routing": {"rules": [{"ip": ["10.0.0.0/8"], "outboundTag": "direct"}]},
+		"outbounds": [
+			{"protocol": "socks", "sendThrough": "Broken",
+				"settings": {"address": "127.0.0.1", "port": %s}},
+			{"protocol": "socks", "tag": "jp", "sendThrough": "Tokyo",
+				"settings": {"address": "127.0.0.1", "port": %d}},
+			{"protocol": "freedom", "tag": "direct"}
+		]
+	}`, closedPortText, startSocksProxyForTest(t))
+}
+
+func TestSelectBestOutboundRanksAndEmitsConfig(t *testing.T) {
+	xrayJSON := selectConfigForTest(t)
+	target := startTCPServerForTest(t, "SSH-2.0-test\r\n")
+
+	for _, policy := range []SelectPolicy{
+		SelectPolicyLowestMedian,
+		SelectPolicyThreshold,
+		SelectPolicyWeightedRandom,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			selection, err := SelectBestOutbound(xrayJSON, SelectOptions{
+				Ping: PingOptions{
+					Timeout: 2,
+					Mode:    PingModeTCPConnect,
+					Target:  target,
+				},
+				Policy:     policy,
+				Rounds:     2,
+				MaxDelay:   2000,
+				EmitConfig: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !selection.Selected || selection.Index != 1 || selection.Tag != "jp" {
+				t.Fatalf("selection = %+v", selection)
+			}
+			if len(selection.Ranking) != 2 {
+				t.Fatalf("ranking = %+v", selection.Ranking)
+			}
+			best, failed := selection.Ranking[0], selection.Ranking[1]
+			if best.Name != "Tokyo" || best.Successes != 2 || best.Failures != 0 {
+				t.Fatalf("best = %+v", best)
+			}
+			if failed.Index != 0 || failed.Name != "Broken" || failed.Failures != 2 ||
+				failed.MedianDelay != nodep.PingDelayError || failed.Error == "" {
+				t.Fatalf("failed = %+v", failed)
+			}
+
+			var emitted struct {
+				Routing   json.RawMessage             `json:"routing"`
+				Outbounds []conf.OutboundDetourConfig `json:"outbounds"`
+			}
+			if err := json.Unmarshal([]byte(selection.XrayJSON), &emitted); err != nil {
+				t.Fatal(err)
+			}
+			if len(emitted.Routing) == 0 || len(emitted.Outbounds) != 2 {
+				t.Fatalf("emitted config = %s", selection.XrayJSON)
+			}
+			if emitted.Outbounds[0].Tag != "proxy" ||
+				emitted.Outbounds[0].SendThrough != nil ||
+				emitted.Outbounds[1].Tag != "direct" {
+				t.Fatalf("emitted outbounds = %s", selection.XrayJSON)
+			}
+			if err := TestXray(selection.XrayJSON); err != nil {
+				t.Fatalf("emitted config does not build: %v", err)
+			}
+		})
+	}
+}
+
+func TestSelectBestOutboundReportsNoSelection(t *testing.T) {
+	closedPort := startTCPServerForTest(t, "")
+	host, port, _ := strings.Cut(closedPort, ":")
+	selection, err := SelectBestOutbound(
+		`{"outbounds":[{"protocol":"socks","settings":{"address":"`+
+			host+`","port":`+port+`}}]}`,
+		SelectOptions{
+			Ping: PingOptions{
+				Timeout: 1,
+				Mode:    PingModeTCPConnect,
+				Target:  "127.0.0.1:1",
+			},
+			Rounds: 1,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selection.Selected || selection.Index != -1 || len(selection.Ranking) != 1 {
+		t.Fatalf("selection = %+v", selection)
+	}
+}
+
+func TestEmitSelectedConfigRetargetsRouting(t *testing.T) {
+	emit := func(xrayJSON string, winnerIndex int) (string, error) {
+		outbounds, err := readPingOutbounds(xrayJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tagIndexes, duplicateTags := indexPingOutboundTags(outbounds)
+		return emitSelectedConfig(xrayJSON, outbounds, tagIndexes, duplicateTags, winnerIndex)
+	}
+
+	emitted, err := emit(`{
+		"routing": {"domainStrategy": "AsIs", "rules": [
+			{"domain": ["example.com"], "outboundTag": "jp"},
+			{"ip": ["192.0.2.0/24"], "outboundTag": "us"},
+			{"ip": ["10.0.0.0/8"], "outboundTag": "direct"},
+			{"network": "udp", "balancerTag": "direct-only"}
+		], "balancers": [{"tag": "direct-only", "selector": ["dir"]}]},
+		"outbounds": [
+			{"protocol": "socks", "tag": "us", "settings": {"address": "192.0.2.1", "port": 1080}},
+			{"protocol": "socks", "tag": "jp", "settings": {"address": "192.0.2.2", "port": 1080}},
+			{"protocol": "freedom", "tag": "direct"}
+		]
+	}`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Routing struct {
+			DomainStrategy string `json:"domainStrategy"`
+			Rules          []struct {
+				OutboundTag string `json:"outboundTag"`
+				BalancerTag string `json:"balancerTag"`
+			} `json:"rules"`
+		} `json:"routing"`
+	}
+	if err := json.Unmarshal([]byte(emitted), &config); err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, rule := range config.Routing.Rules {
+		tags = append(tags, rule.OutboundTag+rule.BalancerTag)
+	}
+	if strings.Join(tags, ",") != "proxy,proxy,direct,direct-only" ||
+		config.Routing.DomainStrategy != "AsIs" {
+		t.Fatalf("emitted routing = %s", emitted)
+	}
+	if err := TestXray(emitted); err != nil {
+		t.Fatalf("emitted config does not build: %v", err)
+	}
+
+	_, err = emit(`{
+		"routing": {"balancers": [{"tag": "auto", "selector": ["node-"]}]},
+		"outbounds": [
+			{"protocol": "socks", "tag": "node-us", "settings": {"address": "192.0.2.1", "port": 1080}},
+			{"protocol": "socks", "tag": "node-jp", "settings": {"address": "192.0.2.2", "port": 1080}}
+		]
+	}`, 0)
+	if err == nil || !strings.Contains(err.Error(), `balancer "auto"`) {
+		t.Fatalf("balancer error = %v", err)
+	}
+}
+
+func TestSortOutboundRankingThresholdPrefersFewerFailures(t *testing.T) {
+	ranking := []OutboundRank{
+		{Index: 0, MedianDelay: 50, Successes: 1, Failures: 2},
+		{Index: 1, MedianDelay: 500, Successes: 3},
+		{Index: 2, MedianDelay: 120, Successes: 3},
+		{Index: 3, MedianDelay: nodep.PingDelayError, Failures: 3},
+	}
+	sortOutboundRanking(ranking, SelectOptions{
+		Policy:   SelectPolicyThreshold,
+		MaxDelay: 200,
+	})
+	var order []int
+	for _, rank := range ranking {
+		order = append(order, rank.Index)
+	}
+	if fmt.Sprint(order) != "[2 0 1 3]" {
+		t.Fatalf("order = %v, want [2 0 1 3]", order)
+	}
+
+	sortOutboundRanking(ranking, SelectOptions{Policy: SelectPolicyLowestMedian})
+	if ranking[0].Index != 0 {
+		t.Fatalf("lowest median winner = %d, want 0", ranking[0].Index)
+	}
+}
+
+func TestMedianDelay(t *testing.T) {
+	if delay := medianDelay([]int64{300, 100, 200}); delay != 200 {
+		t.Fatalf("odd median = %d", delay)
+	}
+	if delay := medianDelay([]int64{400, 100, 200, 300}); delay != 250 {
+		t.Fatalf("even median = %d", delay)
+	}
+}
+
+func TestNormalizeSelectOptions(t *testing.T) {
+	ping := PingOptions{Timeout: 1, URL: "https://example.com"}
+	tests := []struct {
+		name      string
+		options   SelectOptions
+		errorText string
+	}{
+		{
+			name:      "policy",
+			options:   SelectOptions{Ping: ping, Policy: "fastest"},
+			errorText: "unsupported select policy",
+		},
+		{
+			name:      "threshold",
+			options:   SelectOptions{Ping: ping, Policy: SelectPolicyThreshold},
+			errorText: "max delay",
+		},
+		{
+			name:      "rounds",
+			options:   SelectOptions{Ping: ping, Rounds: maxSelectRounds + 1},
+			errorText: "select rounds",
+		},
+		{
+			name: "direct",
+			options: SelectOptions{Ping: PingOptions{
+				Timeout: 1,
+				URL:     "https://example.com",
+				Direct:  true,
+			}},
+			errorText: "direct baseline",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := normalizeSelectOptions(test.options)
+			if err == nil || !strings.Contains(err.Error(), test.errorText) {
+				t.Fatalf("error = %v, want %q", err, test.errorText)
+			}
+		})
+	}
+
+	options, err := normalizeSelectOptions(SelectOptions{Ping: ping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Policy != SelectPolicyLowestMedian || options.Rounds != defaultSelectRounds {
+		t.Fatalf("defaults = %+v", options)
+	}
+}
@@ -16,7 +16,10 @@ var (
 	coreServer   *core.Instance
 )
 
-var ErrAlreadyRunning = errors.New("xray is already running")
+var (
+	ErrAlreadyRunning = errors.New("xray is already running")
+	ErrNotRunning     = errors.New("xray is not running")
+)
 
 func newXrayInstance(xrayJSON string) (*core.Instance, error) {
 	config, err := core.LoadConfig("json", strings.NewReader(xrayJSON))